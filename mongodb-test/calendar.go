@@ -0,0 +1,97 @@
+package mongodb_test
+
+import (
+	"context"
+	"time"
+
+	"mongodb-test/models"
+)
+
+// Finder runs a query, *MongoTest implements it and so can stores that hold matches elsewhere
+type Finder interface {
+	FindMatches(ctx context.Context, q Query, fn func(models.Match) error) error
+}
+
+// StartOfDay will return midnight at the start of the day t falls on in loc
+func StartOfDay(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// DayRange will return the start of the day t falls on in loc and the start of the next day
+// Days are found by date rather than by adding 24 hours, so they are 23 or 25 hours long when the clocks change
+func DayRange(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	start := StartOfDay(t, loc)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// WeekRange will return the start of the Monday of the week t falls on in loc and the start of the next Monday
+func WeekRange(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	start := StartOfDay(t, loc)
+
+	// Weekday counts from Sunday, weeks start on Monday
+	start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+
+	return start, start.AddDate(0, 0, 7)
+}
+
+// Day will select the matches kicking off on the day t falls on in loc
+func (q Query) Day(t time.Time, loc *time.Location) Query {
+	return q.Between(DayRange(t, loc))
+}
+
+// Week will select the matches kicking off from Monday to Sunday of the week t falls on in loc
+func (q Query) Week(t time.Time, loc *time.Location) Query {
+	return q.Between(WeekRange(t, loc))
+}
+
+// Upcoming will select the matches yet to kick off at now, in kick off order
+// Postponed and cancelled matches aren't included as they have no kick off time
+func (q Query) Upcoming(now time.Time) Query {
+	return q.Between(now, time.Time{}).Status(models.Scheduled, models.Timed)
+}
+
+// FindThisMatchday will return the matches of the current matchday
+// This is the matchday of the first match kicking off from the start of today in loc, or of the last match played if there are none
+// A match without a matchday, such as a cup match, has no matchday to return so none are returned
+func FindThisMatchday(ctx context.Context, f Finder, now time.Time, loc *time.Location) (models.MatchList, error) {
+	// Find the first match from today, then the last match before it if the season is over
+	queries := []Query{
+		NewQuery().Between(StartOfDay(now, loc), time.Time{}).Limit(1),
+		NewQuery().Between(time.Time{}, now).OrderBy(ByKickoff, true).Limit(1),
+	}
+
+	for _, q := range queries {
+		var found *models.Match
+		err := f.FindMatches(ctx, q, func(match models.Match) error {
+			found = &match
+			return nil
+		})
+
+		// Check for errors
+		if err != nil {
+			return models.MatchList{}, err
+		}
+
+		if found == nil {
+			continue
+		}
+
+		// Matchday 0 would select every match without a matchday rather than the current matchday
+		if found.Matchday == 0 {
+			return models.MatchList{}, nil
+		}
+
+		// Get every match of its matchday
+		var matchList models.MatchList
+		q = NewQuery().Season(found.Season.Id).Matchdays(found.Matchday, found.Matchday)
+		err = f.FindMatches(ctx, q, func(match models.Match) error {
+			matchList.Matches = append(matchList.Matches, match)
+			return nil
+		})
+
+		return matchList, err
+	}
+
+	return models.MatchList{}, nil
+}
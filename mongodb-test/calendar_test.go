@@ -0,0 +1,216 @@
+package mongodb_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"mongodb-test/models"
+)
+
+// matchFinder runs queries against matches held in memory
+type matchFinder []models.Match
+
+func (f matchFinder) FindMatches(ctx context.Context, q Query, fn func(models.Match) error) error {
+	for _, match := range q.Apply(f) {
+		if err := fn(match); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadLocation will load the time zone, skipping the test if there is no time zone database
+func loadLocation(t testing.TB, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	return loc
+}
+
+// ids will return the ids of the matches in order
+func ids(matches []models.Match) []int {
+	var got []int
+	for _, match := range matches {
+		got = append(got, match.Id)
+	}
+	return got
+}
+
+func TestDayRange(t *testing.T) {
+	london := loadLocation(t, "Europe/London")
+
+	tests := []struct {
+		name      string
+		t         time.Time
+		wantStart time.Time
+		wantHours float64
+	}{
+		// 23:30 BST is still Saturday in London but Sunday is 30 minutes away
+		{"late evening", time.Date(2023, 8, 12, 22, 30, 0, 0, time.UTC), time.Date(2023, 8, 11, 23, 0, 0, 0, time.UTC), 24},
+		// 00:30 BST is Sunday in London while it is still Saturday in UTC
+		{"after midnight", time.Date(2023, 8, 12, 23, 30, 0, 0, time.UTC), time.Date(2023, 8, 12, 23, 0, 0, 0, time.UTC), 24},
+		// The clocks go back, so the day is 25 hours long and ends in GMT
+		{"clocks go back", time.Date(2023, 10, 29, 12, 0, 0, 0, time.UTC), time.Date(2023, 10, 28, 23, 0, 0, 0, time.UTC), 25},
+		// The clocks go forward, so the day is 23 hours long
+		{"clocks go forward", time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), 23},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := DayRange(tt.t, london)
+
+			if !start.Equal(tt.wantStart) {
+				t.Errorf("start = %v, want %v", start.UTC(), tt.wantStart)
+			}
+			if hours := end.Sub(start).Hours(); hours != tt.wantHours {
+				t.Errorf("day is %v hours long, want %v", hours, tt.wantHours)
+			}
+			if h, m, s := end.In(london).Clock(); h != 0 || m != 0 || s != 0 {
+				t.Errorf("end = %v, want midnight in London", end.In(london))
+			}
+		})
+	}
+}
+
+func TestWeekRange(t *testing.T) {
+	london := loadLocation(t, "Europe/London")
+
+	// Monday 7 August 2023 in London
+	week := time.Date(2023, 8, 6, 23, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		t         time.Time
+		wantStart time.Time
+	}{
+		{"Monday midnight", week, week},
+		{"midweek", time.Date(2023, 8, 9, 12, 0, 0, 0, time.UTC), week},
+		// 23:59 BST on Sunday is the last minute of the week
+		{"Sunday night", time.Date(2023, 8, 13, 22, 59, 0, 0, time.UTC), week},
+		// 00:00 BST on Monday is the next week, though it is still Sunday in UTC
+		{"Monday in London, Sunday in UTC", time.Date(2023, 8, 13, 23, 0, 0, 0, time.UTC), week.AddDate(0, 0, 7)},
+		// The week the clocks go back has an extra hour
+		{"clocks go back", time.Date(2023, 10, 29, 12, 0, 0, 0, time.UTC), time.Date(2023, 10, 22, 23, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := WeekRange(tt.t, london)
+
+			if !start.Equal(tt.wantStart) {
+				t.Errorf("start = %v, want %v", start.In(london), tt.wantStart.In(london))
+			}
+			if start.In(london).Weekday() != time.Monday || end.In(london).Weekday() != time.Monday {
+				t.Errorf("week runs from %v to %v, want Monday to Monday", start.In(london), end.In(london))
+			}
+			if !end.Equal(start.AddDate(0, 0, 7)) {
+				t.Errorf("end = %v, want a week after %v", end.In(london), start.In(london))
+			}
+		})
+	}
+}
+
+func TestCalendarQueries(t *testing.T) {
+	matches := readTestMatches(t)
+	tokyo := loadLocation(t, "Asia/Tokyo")
+
+	tests := []struct {
+		name string
+		q    Query
+		want []int
+	}{
+		{"day", NewQuery().Day(time.Date(2023, 8, 13, 14, 0, 0, 0, time.UTC), time.UTC), []int{435946, 435947}},
+		// 15:30 UTC on Sunday is 00:30 on Monday in Tokyo
+		{"day in Tokyo", NewQuery().Day(time.Date(2023, 8, 13, 14, 0, 0, 0, time.UTC), tokyo), []int{435946}},
+		{"next day in Tokyo", NewQuery().Day(time.Date(2023, 8, 13, 15, 0, 0, 0, time.UTC), tokyo), []int{435947}},
+		{"week", NewQuery().Week(time.Date(2023, 8, 13, 15, 0, 0, 0, time.UTC), time.UTC), []int{435943, 435944, 435945, 435946, 435947}},
+		// It is Monday in Tokyo, which holds the last match of matchday 1 but not the last of matchday 2
+		{"week in Tokyo", NewQuery().Week(time.Date(2023, 8, 13, 15, 0, 0, 0, time.UTC), tokyo), []int{435947, 435948, 435949, 435950, 435951}},
+		// Postponed matches have no kick off to wait for
+		{"upcoming", NewQuery().Upcoming(time.Date(2023, 9, 2, 12, 0, 0, 0, time.UTC)), []int{435959, 435960, 435962}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(tt.q.Apply(matches)); !slices.Equal(got, tt.want) {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindThisMatchday(t *testing.T) {
+	finder := matchFinder(readTestMatches(t))
+	losAngeles := loadLocation(t, "America/Los_Angeles")
+
+	tests := []struct {
+		name string
+		now  time.Time
+		loc  *time.Location
+		want int
+	}{
+		{"before the season", time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC), time.UTC, 1},
+		{"during a matchday", time.Date(2023, 8, 19, 15, 0, 0, 0, time.UTC), time.UTC, 2},
+		// The day after matchday 3 is the day of the next matchday
+		{"between matchdays", time.Date(2023, 8, 28, 0, 30, 0, 0, time.UTC), time.UTC, 4},
+		// But in Los Angeles it is still Sunday, the last day of matchday 3
+		{"between matchdays in Los Angeles", time.Date(2023, 8, 28, 0, 30, 0, 0, time.UTC), losAngeles, 3},
+		{"after the season", time.Date(2023, 9, 10, 0, 0, 0, 0, time.UTC), time.UTC, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchList, err := FindThisMatchday(context.Background(), finder, tt.now, tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(matchList.Matches) != 5 {
+				t.Fatalf("found %d matches, want the 5 of a matchday: %v", len(matchList.Matches), ids(matchList.Matches))
+			}
+			for _, match := range matchList.Matches {
+				if match.Matchday != tt.want {
+					t.Errorf("match %d is on matchday %d, want %d", match.Id, match.Matchday, tt.want)
+				}
+			}
+		})
+	}
+
+	// No matches finds an empty matchday
+	matchList, err := FindThisMatchday(context.Background(), matchFinder(nil), time.Now(), time.UTC)
+	if err != nil || len(matchList.Matches) != 0 {
+		t.Errorf("FindThisMatchday with no matches = %v, %v, want none", ids(matchList.Matches), err)
+	}
+
+	// Matches without a matchday, such as cup matches, have no matchday to find rather than sharing matchday 0
+	kickoff := time.Date(2023, 8, 30, 18, 45, 0, 0, time.UTC)
+	cup := matchFinder{
+		{Id: 1, UtcDate: kickoff, Season: models.Season{Id: 1564}},
+		{Id: 2, UtcDate: kickoff.AddDate(0, 1, 0), Season: models.Season{Id: 1564}},
+	}
+	for _, now := range []time.Time{kickoff.AddDate(0, 0, -1), kickoff.AddDate(1, 0, 0)} {
+		matchList, err := FindThisMatchday(context.Background(), cup, now, time.UTC)
+		if err != nil || len(matchList.Matches) != 0 {
+			t.Errorf("FindThisMatchday at %v with matches without a matchday = %v, %v, want none", now, ids(matchList.Matches), err)
+		}
+	}
+}
+
+func TestGetNextFixturesValidation(t *testing.T) {
+	// n is checked before the collection is used, so an unconnected client will do
+	var m MongoTest
+
+	for _, n := range []int{0, -1} {
+		if _, err := m.GetNextFixtures(context.Background(), "Liverpool", n); err == nil || !strings.Contains(err.Error(), "n must be greater than zero") {
+			t.Errorf("GetNextFixtures with n = %d error = %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"mongodb-test"
+	"mongodb-test/models"
+)
+
+// fetchTimeout is the longest time to wait for an HTTP feed
+const fetchTimeout = 30 * time.Second
+
+// readFeed will read the matches from a file, or from an HTTP endpoint if source is a URL
+// token is sent as the football-data.org X-Auth-Token header if it is set
+func readFeed(ctx context.Context, source, token string) ([]models.Match, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return mongodb_test.ReadMatches(f)
+	}
+
+	// Create the request
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		req.Header.Set("X-Auth-Token", token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s: %s", source, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return mongodb_test.ReadMatches(resp.Body)
+}
+
+func main() {
+	// Create the flags
+	source := flag.String("source", "", "JSON file or URL of the matches to ingest, such as testdata/matches.json")
+	token := flag.String("token", os.Getenv("FOOTBALL_DATA_TOKEN"), "football-data.org API token sent with URL sources, defaults to $FOOTBALL_DATA_TOKEN")
+	uri := flag.String("mongo-uri", mongodb_test.DefaultURI, "MongoDB connection string")
+	database := flag.String("mongo-database", mongodb_test.DefaultDatabase, "MongoDB database holding the matches")
+	collection := flag.String("mongo-collection", mongodb_test.DefaultCollection, "MongoDB collection holding the matches")
+	timeout := flag.Duration("timeout", time.Minute, "Longest time the ingestion can take")
+	flag.Parse()
+
+	if *source == "" {
+		fmt.Println("Error: -source must be set")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	// Read the matches
+	matches, err := readFeed(ctx, *source, *token)
+	if err != nil {
+		fmt.Println("Error reading matches:", err)
+		os.Exit(1)
+	}
+
+	// Connect to MongoDB, leaving the timeout to the context so large feeds aren't cut off
+	mongoTest, err := mongodb_test.NewMongoTest(ctx,
+		mongodb_test.WithURI(*uri),
+		mongodb_test.WithDatabase(*database),
+		mongodb_test.WithCollection(*collection),
+		mongodb_test.WithQueryTimeout(0),
+	)
+	if err != nil {
+		fmt.Println("Error connecting to MongoDB:", err)
+		os.Exit(1)
+	}
+	defer mongoTest.Close()
+
+	// Make sure the unique id index exists so upserts can't create duplicates
+	_, err = mongoTest.Migrate(ctx)
+	if err != nil {
+		fmt.Println("Error migrating MongoDB:", err)
+		mongoTest.Close()
+		os.Exit(1)
+	}
+
+	// Write the matches
+	result, err := mongoTest.UpsertMatches(ctx, matches)
+	fmt.Printf("%d matches read: %d inserted, %d updated, %d unchanged\n",
+		len(matches), result.Inserted, result.Updated, result.Unchanged)
+
+	if err != nil {
+		fmt.Println("Error writing matches:", err)
+		mongoTest.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,186 @@
+package mongodb_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mongodb-test/models"
+)
+
+// upsertBatchSize is the number of matches written in each bulk write
+const upsertBatchSize = 500
+
+// SyncResult counts what UpsertMatches did with each match
+type SyncResult struct {
+	// Inserted is the number of matches that weren't in the collection
+	Inserted int
+	// Updated is the number of matches replaced by a version with a newer LastUpdated
+	Updated int
+	// Unchanged is the number of matches whose stored version was as new or newer
+	Unchanged int
+}
+
+// add will add the counts of another result
+func (r *SyncResult) add(other SyncResult) {
+	r.Inserted += other.Inserted
+	r.Updated += other.Updated
+	r.Unchanged += other.Unchanged
+}
+
+// ReadMatches will decode matches in the football-data.org v4 format
+// This is either an object with a matches list, as returned by the matches endpoints, or a bare list of matches
+func ReadMatches(r io.Reader) ([]models.Match, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// A bare list of matches
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		var matches []models.Match
+		err = json.Unmarshal(data, &matches)
+		return matches, err
+	}
+
+	// An object with a matches list
+	var matchList models.MatchList
+	err = json.Unmarshal(data, &matchList)
+	if err != nil {
+		return nil, err
+	}
+
+	if matchList.Matches == nil {
+		return nil, errors.New("no matches list in feed")
+	}
+
+	return matchList.Matches, nil
+}
+
+// UpsertMatches will write the matches to the collection by id
+// Matches that aren't stored are inserted, stored matches are only replaced if the new LastUpdated is later
+func (m *MongoTest) UpsertMatches(ctx context.Context, matches []models.Match) (SyncResult, error) {
+	var total SyncResult
+
+	for start := 0; start < len(matches); start += upsertBatchSize {
+		batch := matches[start:min(start+upsertBatchSize, len(matches))]
+
+		result, err := m.upsertBatch(ctx, batch)
+		total.add(result)
+
+		// Check for errors
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// upsertBatch will write a batch of matches with a single bulk write
+func (m *MongoTest) upsertBatch(ctx context.Context, matches []models.Match) (SyncResult, error) {
+	// Find when the stored matches were last updated
+	ids := make([]int, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, match.Id)
+	}
+
+	stored := make(map[int]models.Match)
+	err := m.FindMatches(ctx, NewQuery().IDs(ids...).Fields("last_updated"), func(match models.Match) error {
+		stored[match.Id] = match
+		return nil
+	})
+
+	// Check for errors
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	// Create a write for each match that is new or newer
+	writes, result := planUpserts(matches, stored)
+
+	if len(writes) == 0 {
+		return result, nil
+	}
+
+	// Limit the write to the query timeout
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	// Write the batch, carrying on past a failed write so the rest are still written
+	bulk, err := m.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+
+	// Count what was written, even if some writes failed
+	result.add(countWrites(len(writes), bulk, err))
+
+	// Check for errors
+	if err != nil {
+		m.logger.Printf("Error writing matches: %v", err)
+		return result, err
+	}
+
+	return result, nil
+}
+
+// planUpserts will create a write for each match that isn't stored or is newer than the stored version
+// The matches that are already as new are counted as unchanged
+func planUpserts(matches []models.Match, stored map[int]models.Match) ([]mongo.WriteModel, SyncResult) {
+	var result SyncResult
+	var writes []mongo.WriteModel
+
+	for _, match := range matches {
+		old, ok := stored[match.Id]
+
+		switch {
+		case !ok:
+			// Upsert rather than insert so that a match written since it was looked up isn't duplicated
+			writes = append(writes, mongo.NewReplaceOneModel().
+				SetFilter(bson.D{{Key: "id", Value: match.Id}}).
+				SetReplacement(match).
+				SetUpsert(true))
+		case match.LastUpdated.After(old.LastUpdated):
+			// Only replace the stored match if it is still older, in case it was updated since it was looked up
+			writes = append(writes, mongo.NewReplaceOneModel().
+				SetFilter(bson.D{
+					{Key: "id", Value: match.Id},
+					{Key: "last_updated", Value: bson.D{{Key: "$lt", Value: match.LastUpdated}}},
+				}).
+				SetReplacement(match))
+		default:
+			result.Unchanged++
+		}
+	}
+
+	return writes, result
+}
+
+// countWrites will count what a bulk write of n writes did, bulk and err being what BulkWrite returned
+// Writes that matched nothing to change lost a race with a newer version, so they are unchanged
+// Failed writes aren't counted, and if the error doesn't say which writes failed only the writes made are
+func countWrites(n int, bulk *mongo.BulkWriteResult, err error) SyncResult {
+	var result SyncResult
+
+	if bulk != nil {
+		result.Inserted = int(bulk.UpsertedCount)
+		result.Updated = int(bulk.ModifiedCount)
+	}
+
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) {
+			return result
+		}
+
+		n -= len(bulkErr.WriteErrors)
+	}
+
+	result.Unchanged = max(0, n-result.Inserted-result.Updated)
+	return result
+}
@@ -0,0 +1,154 @@
+package mongodb_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"mongodb-test/models"
+)
+
+// readTestMatches will read the matches in testdata/matches.json
+func readTestMatches(t testing.TB) []models.Match {
+	t.Helper()
+
+	f, err := os.Open("testdata/matches.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	matches, err := ReadMatches(f)
+	if err != nil {
+		t.Fatalf("ReadMatches error: %v", err)
+	}
+
+	return matches
+}
+
+func TestReadMatches(t *testing.T) {
+	matches := readTestMatches(t)
+
+	if len(matches) != 20 {
+		t.Fatalf("read %d matches, want 20", len(matches))
+	}
+
+	for _, match := range matches {
+		if match.Id == 0 || match.LastUpdated.IsZero() || match.HomeTeam.ShortName == "" {
+			t.Errorf("match wasn't fully decoded: %+v", match)
+		}
+	}
+}
+
+func TestPlanUpserts(t *testing.T) {
+	matches := readTestMatches(t)
+
+	// Store the first 5 matches as an older version, the next 5 as the same and 2 more as a newer one
+	// The other 8 aren't stored
+	stored := make(map[int]models.Match)
+	for i, match := range matches[:12] {
+		switch {
+		case i < 5:
+			match.LastUpdated = match.LastUpdated.Add(-time.Hour)
+		case i >= 10:
+			match.LastUpdated = match.LastUpdated.Add(time.Hour)
+		}
+		stored[match.Id] = match
+	}
+
+	writes, result := planUpserts(matches, stored)
+
+	if want := (SyncResult{Unchanged: 7}); result != want {
+		t.Errorf("result = %+v, want %+v", result, want)
+	}
+
+	var upserts, replaces int
+	for _, write := range writes {
+		replace, ok := write.(*mongo.ReplaceOneModel)
+		if !ok {
+			t.Fatalf("write is a %T, want a replace", write)
+		}
+
+		if replace.Upsert != nil && *replace.Upsert {
+			upserts++
+		} else {
+			replaces++
+		}
+	}
+
+	if upserts != 8 || replaces != 5 {
+		t.Errorf("planned %d inserts and %d updates, want 8 and 5", upserts, replaces)
+	}
+
+	// Nothing stored inserts every match, and everything stored as new writes nothing
+	if writes, result := planUpserts(matches, nil); len(writes) != 20 || result != (SyncResult{}) {
+		t.Errorf("with nothing stored planned %d writes and %+v, want 20 writes", len(writes), result)
+	}
+
+	all := make(map[int]models.Match)
+	for _, match := range matches {
+		all[match.Id] = match
+	}
+	if writes, result := planUpserts(matches, all); len(writes) != 0 || result != (SyncResult{Unchanged: 20}) {
+		t.Errorf("with everything stored planned %d writes and %+v, want none and 20 unchanged", len(writes), result)
+	}
+}
+
+func TestCountWrites(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		bulk *mongo.BulkWriteResult
+		err  error
+		want SyncResult
+	}{
+		{
+			name: "all written",
+			n:    13,
+			bulk: &mongo.BulkWriteResult{UpsertedCount: 8, ModifiedCount: 5},
+			want: SyncResult{Inserted: 8, Updated: 5},
+		},
+		{
+			// An update that found a newer version stored matched nothing
+			name: "lost a race",
+			n:    13,
+			bulk: &mongo.BulkWriteResult{UpsertedCount: 8, ModifiedCount: 4},
+			want: SyncResult{Inserted: 8, Updated: 4, Unchanged: 1},
+		},
+		{
+			name: "failed writes aren't unchanged",
+			n:    13,
+			bulk: &mongo.BulkWriteResult{UpsertedCount: 7, ModifiedCount: 3},
+			err: mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+				{WriteError: mongo.WriteError{Index: 0, Code: 11000}},
+				{WriteError: mongo.WriteError{Index: 9, Code: 11000}},
+			}},
+			want: SyncResult{Inserted: 7, Updated: 3, Unchanged: 1},
+		},
+		{
+			// Without the write errors it isn't known which writes were made
+			name: "other errors",
+			n:    13,
+			bulk: &mongo.BulkWriteResult{UpsertedCount: 2},
+			err:  errors.New("connection reset"),
+			want: SyncResult{Inserted: 2},
+		},
+		{
+			name: "no result",
+			n:    13,
+			err:  errors.New("connection reset"),
+			want: SyncResult{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countWrites(tt.n, tt.bulk, tt.err); got != tt.want {
+				t.Errorf("countWrites = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
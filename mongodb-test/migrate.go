@@ -0,0 +1,252 @@
+package mongodb_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrationsCollection is the collection, in the same database as the matches, that records the applied migrations
+const MigrationsCollection = "migrations"
+
+// Indexes are the indexes the matches collection needs, EnsureIndexes creates any that are missing
+var Indexes = []mongo.IndexModel{
+	// Matches are upserted and looked up by id
+	{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetName("id_unique").SetUnique(true),
+	},
+	// A team's matches are found by either team, and sorted by kick off
+	{
+		Keys:    bson.D{{Key: "home_team.short_name", Value: 1}, {Key: "utc_date", Value: 1}},
+		Options: options.Index().SetName("home_team_kickoff"),
+	},
+	{
+		Keys:    bson.D{{Key: "away_team.short_name", Value: 1}, {Key: "utc_date", Value: 1}},
+		Options: options.Index().SetName("away_team_kickoff"),
+	},
+	// Every other query selects or sorts by kick off
+	{
+		Keys:    bson.D{{Key: "utc_date", Value: 1}},
+		Options: options.Index().SetName("kickoff"),
+	},
+	// Standings and matchday pages select a season's matchdays
+	{
+		Keys:    bson.D{{Key: "season.id", Value: 1}, {Key: "matchday", Value: 1}},
+		Options: options.Index().SetName("season_matchday"),
+	},
+}
+
+// Migration is a versioned change to the matches collection
+// Up must be safe to run again, in case it succeeded but wasn't recorded
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, collection *mongo.Collection) error
+}
+
+// migrationRecord is how an applied migration is stored in the migrations collection
+type migrationRecord struct {
+	Collection  string    `bson:"collection"`
+	Version     int       `bson:"version"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrations are applied in order of version by Migrate, add new migrations to the end with the next version
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Remove duplicate matches, keeping the last updated, so that id can be unique",
+		Up:          removeDuplicateMatches,
+	},
+}
+
+// removeDuplicateMatches will delete all but the last updated document for each match id
+func removeDuplicateMatches(ctx context.Context, collection *mongo.Collection) error {
+	// Find the ids with more than one document, newest first
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "last_updated", Value: -1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$id"},
+			{Key: "docs", Value: bson.D{{Key: "$push", Value: "$_id"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	// Collect every document after the newest
+	var stale bson.A
+	for cursor.Next(ctx) {
+		var group struct {
+			Docs []any `bson:"docs"`
+		}
+		err = cursor.Decode(&group)
+		if err != nil {
+			return err
+		}
+		for _, doc := range group.Docs[1:] {
+			stale = append(stale, doc)
+		}
+	}
+
+	if err = cursor.Err(); err != nil {
+		return err
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	_, err = collection.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: stale}}}})
+	return err
+}
+
+// EnsureIndexes will create any of the Indexes that are missing from the matches collection
+func (m *MongoTest) EnsureIndexes(ctx context.Context) error {
+	names, err := m.collection.Indexes().CreateMany(ctx, Indexes)
+
+	// Check for errors
+	if err != nil {
+		m.logger.Printf("Error creating indexes: %v", err)
+		return err
+	}
+
+	m.logger.Printf("Ensured indexes %v", names)
+
+	return nil
+}
+
+// Migrate will apply the Migrations that haven't been applied to the matches collection, then ensure its indexes
+// It returns the versions applied, migrations that fail stop the rest from being applied
+func (m *MongoTest) Migrate(ctx context.Context) ([]int, error) {
+	return m.migrate(ctx, Migrations)
+}
+
+// migrationStore keeps track of the applied migrations, *MongoTest implements it for the matches collection
+type migrationStore interface {
+	// appliedMigrations will return the versions already applied
+	appliedMigrations(ctx context.Context) (map[int]bool, error)
+	// applyMigration will run the migration's Up
+	applyMigration(ctx context.Context, migration Migration) error
+	// recordMigration will record that the migration has been applied
+	recordMigration(ctx context.Context, migration Migration) error
+	// EnsureIndexes will create any missing indexes
+	EnsureIndexes(ctx context.Context) error
+}
+
+// migrate will apply the migrations that haven't been applied, then ensure the indexes
+func (m *MongoTest) migrate(ctx context.Context, migrations []Migration) ([]int, error) {
+	return runMigrations(ctx, m, migrations, m.logger)
+}
+
+// runMigrations will apply the migrations the store hasn't recorded in order of version, recording each after it succeeds, then ensure the indexes
+func runMigrations(ctx context.Context, store migrationStore, migrations []Migration, logger *log.Logger) ([]int, error) {
+	// Find the migrations already applied
+	applied, err := store.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the rest in order
+	var versions []int
+	last := 0
+	for _, migration := range migrations {
+		if migration.Version <= last {
+			return versions, fmt.Errorf("migration %d is out of order", migration.Version)
+		}
+		last = migration.Version
+
+		if applied[migration.Version] {
+			continue
+		}
+
+		// Don't start another migration once the time is up
+		if err = ctx.Err(); err != nil {
+			return versions, fmt.Errorf("migration %d: %w", migration.Version, err)
+		}
+
+		logger.Printf("Applying migration %d: %s", migration.Version, migration.Description)
+
+		err = store.applyMigration(ctx, migration)
+		if err != nil {
+			logger.Printf("Error applying migration %d: %v", migration.Version, err)
+			return versions, fmt.Errorf("migration %d: %w", migration.Version, err)
+		}
+
+		err = store.recordMigration(ctx, migration)
+		if err != nil {
+			return versions, fmt.Errorf("recording migration %d: %w", migration.Version, err)
+		}
+
+		versions = append(versions, migration.Version)
+	}
+
+	// Indexes come last so that migrations can fix data that would break them
+	return versions, store.EnsureIndexes(ctx)
+}
+
+// appliedMigrations will return the versions recorded in the migrations collection for the matches collection
+func (m *MongoTest) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	records := m.database.Collection(MigrationsCollection)
+
+	// Each migration is recorded once per collection
+	_, err := records.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "collection", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetName("collection_version_unique").SetUnique(true),
+	})
+	if err != nil {
+		m.logger.Printf("Error creating migrations index: %v", err)
+		return nil, err
+	}
+
+	cursor, err := records.Find(ctx, bson.D{{Key: "collection", Value: m.collection.Name()}})
+	if err != nil {
+		return nil, err
+	}
+
+	var done []migrationRecord
+	err = cursor.All(ctx, &done)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool)
+	for _, record := range done {
+		applied[record.Version] = true
+	}
+
+	return applied, nil
+}
+
+// applyMigration will run the migration against the matches collection
+func (m *MongoTest) applyMigration(ctx context.Context, migration Migration) error {
+	return migration.Up(ctx, m.collection)
+}
+
+// recordMigration will record the migration in the migrations collection
+// Another process applying it at the same time isn't an error
+func (m *MongoTest) recordMigration(ctx context.Context, migration Migration) error {
+	_, err := m.database.Collection(MigrationsCollection).InsertOne(ctx, migrationRecord{
+		Collection:  m.collection.Name(),
+		Version:     migration.Version,
+		Description: migration.Description,
+		AppliedAt:   time.Now().UTC(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+
+	return err
+}
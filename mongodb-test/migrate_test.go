@@ -0,0 +1,196 @@
+package mongodb_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeMigrationStore keeps track of migrations in memory
+type fakeMigrationStore struct {
+	applied map[int]bool
+	// ran is the versions applied, in order
+	ran []int
+	// recorded is the versions recorded, in order
+	recorded []int
+	// indexed is set once the indexes have been ensured
+	indexed bool
+}
+
+func (s *fakeMigrationStore) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	return s.applied, nil
+}
+
+func (s *fakeMigrationStore) applyMigration(ctx context.Context, migration Migration) error {
+	s.ran = append(s.ran, migration.Version)
+	return migration.Up(ctx, nil)
+}
+
+func (s *fakeMigrationStore) recordMigration(ctx context.Context, migration Migration) error {
+	s.recorded = append(s.recorded, migration.Version)
+	return nil
+}
+
+func (s *fakeMigrationStore) EnsureIndexes(ctx context.Context) error {
+	s.indexed = true
+	return nil
+}
+
+var errMigration = errors.New("migration failed")
+
+// succeed is an Up that does nothing
+func succeed(ctx context.Context, collection *mongo.Collection) error {
+	return nil
+}
+
+// fail is an Up that fails
+func fail(ctx context.Context, collection *mongo.Collection) error {
+	return errMigration
+}
+
+// hang is an Up that runs until it is cancelled
+func hang(ctx context.Context, collection *mongo.Collection) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRunMigrations(t *testing.T) {
+	tests := []struct {
+		name       string
+		applied    []int
+		migrations []Migration
+		timeout    time.Duration
+
+		wantVersions []int
+		wantRan      []int
+		wantRecorded []int
+		wantIndexed  bool
+		wantErr      error
+	}{
+		{
+			name:         "in order of version",
+			migrations:   []Migration{{Version: 1, Up: succeed}, {Version: 2, Up: succeed}, {Version: 5, Up: succeed}},
+			wantVersions: []int{1, 2, 5},
+			wantRan:      []int{1, 2, 5},
+			wantRecorded: []int{1, 2, 5},
+			wantIndexed:  true,
+		},
+		{
+			name:         "out of order",
+			migrations:   []Migration{{Version: 2, Up: succeed}, {Version: 1, Up: succeed}},
+			wantVersions: []int{2},
+			wantRan:      []int{2},
+			wantRecorded: []int{2},
+		},
+		{
+			name:         "duplicate version",
+			migrations:   []Migration{{Version: 1, Up: succeed}, {Version: 1, Up: succeed}},
+			wantVersions: []int{1},
+			wantRan:      []int{1},
+			wantRecorded: []int{1},
+		},
+		{
+			name:         "skips recorded migrations",
+			applied:      []int{1, 3},
+			migrations:   []Migration{{Version: 1, Up: fail}, {Version: 2, Up: succeed}, {Version: 3, Up: fail}, {Version: 4, Up: succeed}},
+			wantVersions: []int{2, 4},
+			wantRan:      []int{2, 4},
+			wantRecorded: []int{2, 4},
+			wantIndexed:  true,
+		},
+		{
+			name:        "everything recorded",
+			applied:     []int{1, 2},
+			migrations:  []Migration{{Version: 1, Up: fail}, {Version: 2, Up: fail}},
+			wantIndexed: true,
+		},
+		{
+			name:         "failure isn't recorded and stops the rest",
+			migrations:   []Migration{{Version: 1, Up: succeed}, {Version: 2, Up: fail}, {Version: 3, Up: succeed}},
+			wantVersions: []int{1},
+			wantRan:      []int{1, 2},
+			wantRecorded: []int{1},
+			wantErr:      errMigration,
+		},
+		{
+			name:       "timeout",
+			migrations: []Migration{{Version: 1, Up: hang}, {Version: 2, Up: succeed}},
+			timeout:    10 * time.Millisecond,
+			wantRan:    []int{1},
+			wantErr:    context.DeadlineExceeded,
+		},
+	}
+
+	logger := log.New(io.Discard, "", 0)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeMigrationStore{applied: make(map[int]bool)}
+			for _, version := range tt.applied {
+				store.applied[version] = true
+			}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			versions, err := runMigrations(ctx, store, tt.migrations, logger)
+
+			// Migrating fails unless it gets as far as the indexes
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantIndexed:
+				if err != nil {
+					t.Errorf("error = %v", err)
+				}
+			default:
+				if err == nil {
+					t.Error("no error, want one")
+				}
+			}
+
+			if !slices.Equal(versions, tt.wantVersions) {
+				t.Errorf("versions = %v, want %v", versions, tt.wantVersions)
+			}
+			if !slices.Equal(store.ran, tt.wantRan) {
+				t.Errorf("ran %v, want %v", store.ran, tt.wantRan)
+			}
+			if !slices.Equal(store.recorded, tt.wantRecorded) {
+				t.Errorf("recorded %v, want %v", store.recorded, tt.wantRecorded)
+			}
+
+			// Indexes are only ensured once every migration has been applied
+			if store.indexed != tt.wantIndexed {
+				t.Errorf("indexed = %v, want %v", store.indexed, tt.wantIndexed)
+			}
+		})
+	}
+}
+
+func TestRunMigrationsAfterDeadline(t *testing.T) {
+	// A migration isn't started once the time is up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	store := &fakeMigrationStore{}
+	_, err := runMigrations(ctx, store, []Migration{{Version: 1, Up: succeed}}, log.New(io.Discard, "", 0))
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+	if len(store.ran) != 0 || len(store.recorded) != 0 {
+		t.Errorf("ran %v and recorded %v, want neither", store.ran, store.recorded)
+	}
+}
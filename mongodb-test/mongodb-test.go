@@ -2,6 +2,8 @@ package mongodb_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -13,24 +15,83 @@ import (
 	"mongodb-test/models"
 )
 
+// ErrNotFound is returned when a requested match does not exist
+var ErrNotFound = errors.New("match not found")
+
+// MatchFilter selects matches, fields with their zero value are ignored
+type MatchFilter struct {
+	// Team is the short name of a team playing at home or away
+	Team string
+	// From is the earliest kick off time, inclusive
+	From time.Time
+	// To is the latest kick off time, exclusive
+	To time.Time
+	// Status is the set of statuses to include
+	Status []models.MatchStatus
+	// Matchday is the matchday to include
+	Matchday int
+}
+
+// Query will return the query that selects the same matches as the filter
+func (f MatchFilter) Query() Query {
+	q := NewQuery().Team(f.Team).Between(f.From, f.To).Status(f.Status...)
+
+	if f.Matchday != 0 {
+		q = q.Matchdays(f.Matchday, f.Matchday)
+	}
+
+	return q
+}
+
+// Matches will return true if the match is selected by the filter
+// This applies the same rules as the query GetMatches sends to MongoDB
+func (f MatchFilter) Matches(m models.Match) bool {
+	return f.Query().Matches(m)
+}
+
 type MongoTest struct {
 	client *mongo.Client
 	database *mongo.Database
 	collection *mongo.Collection
+	// aggregator runs the statistics pipelines, the collection unless a test replaces it
+	aggregator aggregator
 	logger *log.Logger
+	queryTimeout time.Duration
+	connectTimeout time.Duration
+	readPref *readpref.ReadPref
+	location *time.Location
+	now func() time.Time
 }
 
-func NewMongoTest() (*MongoTest, error) {
+// NewMongoTest will connect to MongoDB, using the defaults for any options that aren't given
+// ctx bounds connecting along with the connect timeout
+func NewMongoTest(ctx context.Context, opts ...Option) (*MongoTest, error) {
+	// Apply the options over the defaults
+	cfg := config{
+		uri: DefaultURI,
+		database: DefaultDatabase,
+		collection: DefaultCollection,
+		connectTimeout: DefaultConnectTimeout,
+		queryTimeout: DefaultQueryTimeout,
+		client: options.Client(),
+		readPref: readpref.Primary(),
+		location: time.Local,
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	// Set up logging
 	logger := log.New(log.Writer(), "mongodb-test: ", log.Ldate|log.Ltime|log.Lshortfile)
 
 	// Set up logging
 	logger.Println("Starting MongoDB test")
 
-	// Connect to MongoDB
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	// Connect to MongoDB, the options override the settings in the URI
+	ctx, cancel := context.WithTimeout(ctx, cfg.connectTimeout)
 	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://macmini2:27017"))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.uri), cfg.client)
 
 	// Check for errors
 	if err != nil {
@@ -42,13 +103,16 @@ func NewMongoTest() (*MongoTest, error) {
 	}
 
 	// Ping the MongoDB server
-	err = client.Ping(ctx, readpref.Primary())
+	err = client.Ping(ctx, cfg.readPref)
 
 	// Check for errors
 	if err != nil {
 		// Log the error
 		logger.Printf("Error pinging MongoDB: %v", err)
 
+		// Disconnect the client so that it doesn't keep trying in the background
+		client.Disconnect(context.Background())
+
 		// Return an error
 		return nil, err
 	}
@@ -56,27 +120,34 @@ func NewMongoTest() (*MongoTest, error) {
 	// Log success
 	logger.Println("Connected to MongoDB")
 
-	// Get the web_database
-	database := client.Database("web_database")
+	// Get the database
+	database := client.Database(cfg.database)
 
-	// Get the pl_matches_2023_2024 collection
-	collection := database.Collection("pl_matches_2023_2024")
+	// Get the collection
+	collection := database.Collection(cfg.collection)
 
 	// Create a new MongoTest struct
 	m := &MongoTest{
 		client: client,
 		database: database,
 		collection: collection,
+		aggregator: collection,
 		logger: logger,
+		queryTimeout: cfg.queryTimeout,
+		connectTimeout: cfg.connectTimeout,
+		readPref: cfg.readPref,
+		location: cfg.location,
+		now: cfg.now,
 	}
 
 	// Return the MongoTest struct
 	return m, nil
 }
 
-func (m *MongoTest) Close() {
+// Close will disconnect from MongoDB, waiting up to the connect timeout for queries in progress to finish
+func (m *MongoTest) Close() error {
 	// Create a context
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), m.connectTimeout)
 	defer cancel()
 
 	// Disconnect from MongoDB
@@ -84,69 +155,53 @@ func (m *MongoTest) Close() {
 
 	// Check for errors
 	if err != nil {
-		m.logger.Fatalf("Error disconnecting from MongoDB: %v", err)
+		m.logger.Printf("Error disconnecting from MongoDB: %v", err)
+		return err
 	}
 
 	// Log success
 	m.logger.Println("Disconnected from MongoDB")
-}
-
-func (m *MongoTest) GetOneMatch(homeTeam, awayTeam string) (models.Match, error) {
-	// Create a context
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
 
-	// Get the document that has homeTeam as the home team and awayTeam as the away team
-	var result models.Match
-	err := m.collection.FindOne(
-		ctx,
-		bson.D{
-			{Key: "home_team.short_name", Value: homeTeam},
-			{Key: "away_team.short_name", Value: awayTeam},
-		},
-	).Decode(&result)
+	return nil
+}
 
-	// Check for errors
-	if err != nil {
-		m.logger.Printf("Error getting first document: %v", err)
-		return models.Match{}, err
+// queryContext will return the context for a query, limited by the query timeout if there is one
+func (m *MongoTest) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
 
-	// Return the result
-	return result, nil
+	return context.WithTimeout(ctx, m.queryTimeout)
 }
 
-func (m *MongoTest) GetAllTeamMatches(team string) (models.MatchList, error) {
-	// Create a context
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+// Ping will check that the MongoDB server can be reached
+func (m *MongoTest) Ping(ctx context.Context) error {
+	return m.client.Ping(ctx, m.readPref)
+}
+
+// FindMatches will call fn with each match selected by the query, in the query's order
+// The matches are decoded one at a time as they arrive, so large results aren't held in memory
+// Returning an error from fn stops the query and FindMatches returns that error
+func (m *MongoTest) FindMatches(ctx context.Context, q Query, fn func(models.Match) error) error {
+	// Limit the query to the query timeout
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	// Get all documents that have `team` as the home team or away team
-	cursor, err := m.collection.Find(
-		ctx,
-		bson.D{
-			{Key: "$or", Value: bson.A{
-				bson.D{{Key: "home_team.short_name", Value: team}},
-				bson.D{{Key: "away_team.short_name", Value: team}},
-			}},
-		},
-	)
+	// Run the query
+	cursor, err := m.collection.Find(ctx, q.Filter(), q.findOptions())
 
 	// Check for errors
 	if err != nil {
 		// Log the error
-		m.logger.Printf("Error getting all documents: %v", err)
+		m.logger.Printf("Error finding matches: %v", err)
 
-		// Return an empty MatchList and the error
-		return models.MatchList{}, err
+		// Return the error
+		return err
 	}
 
 	// Close the cursor when the function returns
 	defer cursor.Close(ctx)
 
-	// Create a MatchList
-	var matchList models.MatchList
-
 	// Iterate through the cursor
 	for cursor.Next(ctx) {
 		// Decode the document
@@ -158,74 +213,195 @@ func (m *MongoTest) GetAllTeamMatches(team string) (models.MatchList, error) {
 			// Log the error
 			m.logger.Printf("Error decoding document: %v", err)
 
-			// Return an empty MatchList and the error
-			return models.MatchList{}, err
+			// Return the error
+			return err
 		}
 
-		// Append the result to the matchList
-		matchList.Matches = append(matchList.Matches, result)
+		// Pass the match on, stopping if asked to
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+
+	// Check the cursor didn't stop early because of an error
+	if err := cursor.Err(); err != nil {
+		m.logger.Printf("Error reading matches: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// CountMatches will return the number of matches selected by the query, ignoring its skip and limit
+func (m *MongoTest) CountMatches(ctx context.Context, q Query) (int, error) {
+	// Limit the query to the query timeout
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	// Count the documents matching the filter
+	count, err := m.collection.CountDocuments(ctx, q.Filter())
+
+	// Check for errors
+	if err != nil {
+		// Log the error
+		m.logger.Printf("Error counting matches: %v", err)
+
+		// Return the error
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+// findAll will return every match selected by the query
+func (m *MongoTest) findAll(ctx context.Context, q Query) (models.MatchList, error) {
+	// Create a MatchList
+	var matchList models.MatchList
+
+	// Append each match to the matchList
+	err := m.FindMatches(ctx, q, func(match models.Match) error {
+		matchList.Matches = append(matchList.Matches, match)
+		return nil
+	})
+
+	// Check for errors
+	if err != nil {
+		return models.MatchList{}, err
 	}
 
 	// Return the matchList
 	return matchList, nil
 }
 
-func (m *MongoTest) GetTodaysMatches() (models.MatchList, error) {
-	// Create a context
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
+// findOne will return the first match selected by the query, or ErrNotFound
+func (m *MongoTest) findOne(ctx context.Context, q Query) (models.Match, error) {
+	matchList, err := m.findAll(ctx, q.Limit(1))
+
+	// Check for errors
+	if err != nil {
+		return models.Match{}, err
+	}
+
+	// Return ErrNotFound if there is no such match
+	if len(matchList.Matches) == 0 {
+		return models.Match{}, ErrNotFound
+	}
+
+	// Return the result
+	return matchList.Matches[0], nil
+}
+
+func (m *MongoTest) GetOneMatch(ctx context.Context, homeTeam, awayTeam string) (models.Match, error) {
+	// Get the match that has homeTeam as the home team and awayTeam as the away team
+	return m.findOne(ctx, NewQuery().HomeTeam(homeTeam).AwayTeam(awayTeam))
+}
+
+func (m *MongoTest) GetAllTeamMatches(ctx context.Context, team string) (models.MatchList, error) {
+	// Get all matches that have `team` as the home team or away team
+	return m.findAll(ctx, NewQuery().Team(team))
+}
 
-	// Get the start of today
-	start_date := time.Now().Truncate(24 * time.Hour)
+// Location will return the time zone days and weeks are counted in when no other is given
+func (m *MongoTest) Location() *time.Location {
+	return m.location
+}
+
+// locationOr will return loc, or the configured time zone if loc is nil
+func (m *MongoTest) locationOr(loc *time.Location) *time.Location {
+	if loc == nil {
+		return m.location
+	}
+
+	return loc
+}
+
+// GetTodaysMatches will return the matches kicking off today in loc, or in the configured time zone if loc is nil
+func (m *MongoTest) GetTodaysMatches(ctx context.Context, loc *time.Location) (models.MatchList, error) {
+	// Get all matches between the start of today and the start of tomorrow
+	return m.findAll(ctx, NewQuery().Day(m.now(), m.locationOr(loc)))
+}
+
+// GetThisWeeksMatches will return the matches kicking off from Monday to Sunday this week in loc, or in the configured time zone if loc is nil
+func (m *MongoTest) GetThisWeeksMatches(ctx context.Context, loc *time.Location) (models.MatchList, error) {
+	// Get all matches between the start of Monday and the start of next Monday
+	return m.findAll(ctx, NewQuery().Week(m.now(), m.locationOr(loc)))
+}
+
+// GetThisMatchday will return the matches of the current matchday, counting days in loc, or in the configured time zone if loc is nil
+func (m *MongoTest) GetThisMatchday(ctx context.Context, loc *time.Location) (models.MatchList, error) {
+	return FindThisMatchday(ctx, m, m.now(), m.locationOr(loc))
+}
+
+// GetNextFixtures will return the next n matches the team plays in that are yet to kick off, n must be greater than zero
+func (m *MongoTest) GetNextFixtures(ctx context.Context, team string, n int) (models.MatchList, error) {
+	// A limit of zero would return every match rather than none
+	if n <= 0 {
+		return models.MatchList{}, fmt.Errorf("next fixtures: n must be greater than zero, got %d", n)
+	}
+
+	return m.findAll(ctx, NewQuery().Team(team).Upcoming(m.now()).Limit(n))
+}
 
-	// Get the end of today
-	end_date := start_date.Add(24 * time.Hour)
-	
-	// Get all documents between start_date and end_date
-	cursor, err := m.collection.Find(
+func (m *MongoTest) GetMatch(ctx context.Context, id int) (models.Match, error) {
+	// Get the match with the given id
+	return m.findOne(ctx, NewQuery().IDs(id))
+}
+
+func (m *MongoTest) GetMatches(ctx context.Context, filter MatchFilter) (models.MatchList, error) {
+	// Get the matches selected by the filter in kick off order
+	return m.findAll(ctx, filter.Query())
+}
+
+func (m *MongoTest) GetTeams(ctx context.Context) ([]models.Team, error) {
+	// Limit the query to the query timeout
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	// Every team plays at home so group the home teams by id, sorted by name
+	cursor, err := m.collection.Aggregate(
 		ctx,
-		bson.D{
-			{Key: "utc_date", Value: bson.D{
-				{Key: "$gte", Value: start_date},
-				{Key: "$lt", Value: end_date},
-			}},
+		mongo.Pipeline{
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$home_team.id"},
+				{Key: "team", Value: bson.D{{Key: "$first", Value: "$home_team"}}},
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "team.name", Value: 1}}}},
 		},
 	)
 
 	// Check for errors
 	if err != nil {
 		// Log the error
-		m.logger.Printf("Error getting all documents: %v", err)
+		m.logger.Printf("Error getting teams: %v", err)
 
-		// Return an empty MatchList and the error
-		return models.MatchList{}, err
+		// Return no teams and the error
+		return nil, err
 	}
 
 	// Close the cursor when the function returns
 	defer cursor.Close(ctx)
 
-	// Create a MatchList
-	var matchList models.MatchList
-
-	// Iterate through the cursor
-	for cursor.Next(ctx) {
-		// Decode the document
-		var result models.Match
-		err := cursor.Decode(&result)
+	// Decode the teams
+	var results []struct {
+		Team models.Team `bson:"team"`
+	}
+	err = cursor.All(ctx, &results)
 
-		// Check for errors
-		if err != nil {
-			// Log the error
-			m.logger.Printf("Error decoding document: %v", err)
+	// Check for errors
+	if err != nil {
+		// Log the error
+		m.logger.Printf("Error decoding teams: %v", err)
 
-			// Return an empty MatchList and the error
-			return models.MatchList{}, err
-		}
+		// Return no teams and the error
+		return nil, err
+	}
 
-		// Append the result to the matchList
-		matchList.Matches = append(matchList.Matches, result)
+	// Pull the teams out of the results
+	teams := make([]models.Team, 0, len(results))
+	for _, result := range results {
+		teams = append(teams, result.Team)
 	}
 
-	// Return the matchList
-	return matchList, nil
+	// Return the teams
+	return teams, nil
 }
@@ -0,0 +1,131 @@
+package mongodb_test
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Defaults used by NewMongoTest for the options that aren't given
+const (
+	DefaultURI            = "mongodb://macmini2:27017"
+	DefaultDatabase       = "web_database"
+	DefaultCollection     = "pl_matches_2023_2024"
+	DefaultConnectTimeout = 2 * time.Second
+	DefaultQueryTimeout   = 2 * time.Second
+)
+
+// config holds the settings NewMongoTest connects with
+type config struct {
+	uri            string
+	database       string
+	collection     string
+	connectTimeout time.Duration
+	queryTimeout   time.Duration
+	// client holds the driver options, such as the pool size and credentials, applied after the URI
+	client *options.ClientOptions
+	// readPref is used by queries and by Ping
+	readPref *readpref.ReadPref
+	// location is the time zone days and weeks are counted in
+	location *time.Location
+	// now returns the current time
+	now func() time.Time
+}
+
+// Option changes a setting of NewMongoTest
+type Option func(*config)
+
+// WithURI sets the connection string of the MongoDB server
+func WithURI(uri string) Option {
+	return func(c *config) {
+		c.uri = uri
+	}
+}
+
+// WithDatabase sets the database holding the matches
+func WithDatabase(name string) Option {
+	return func(c *config) {
+		c.database = name
+	}
+}
+
+// WithCollection sets the collection holding the matches, such as the one for a different season
+func WithCollection(name string) Option {
+	return func(c *config) {
+		c.collection = name
+	}
+}
+
+// WithConnectTimeout sets how long to wait to connect to and ping the server
+func WithConnectTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.connectTimeout = d
+	}
+}
+
+// WithQueryTimeout sets the longest each query can take, on top of any deadline of the caller's context
+// A timeout of zero leaves it to the caller's context
+func WithQueryTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.queryTimeout = d
+	}
+}
+
+// WithServerSelectionTimeout sets how long a query waits for a suitable server to become available
+func WithServerSelectionTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.client.SetServerSelectionTimeout(d)
+	}
+}
+
+// WithMaxPoolSize sets the largest number of connections kept to each server
+func WithMaxPoolSize(n uint64) Option {
+	return func(c *config) {
+		c.client.SetMaxPoolSize(n)
+	}
+}
+
+// WithMinPoolSize sets the number of connections kept open to each server when idle
+func WithMinPoolSize(n uint64) Option {
+	return func(c *config) {
+		c.client.SetMinPoolSize(n)
+	}
+}
+
+// WithReadPreference sets which members of a replica set queries are sent to
+func WithReadPreference(rp *readpref.ReadPref) Option {
+	return func(c *config) {
+		c.readPref = rp
+		c.client.SetReadPreference(rp)
+	}
+}
+
+// WithCredentials sets the user to authenticate as, checked against the authSource database
+// An empty authSource uses the admin database
+func WithCredentials(username, password, authSource string) Option {
+	return func(c *config) {
+		c.client.SetAuth(options.Credential{
+			Username:   username,
+			Password:   password,
+			AuthSource: authSource,
+		})
+	}
+}
+
+// WithLocation sets the time zone that today and this week are counted in, the local time zone if not set
+// A nil location keeps the local time zone
+func WithLocation(loc *time.Location) Option {
+	return func(c *config) {
+		if loc != nil {
+			c.location = loc
+		}
+	}
+}
+
+// WithClock sets the function used to get the current time, so that queries relative to today can be run at a fixed time
+func WithClock(now func() time.Time) Option {
+	return func(c *config) {
+		c.now = now
+	}
+}
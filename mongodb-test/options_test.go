@@ -0,0 +1,143 @@
+package mongodb_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// applyOptions will apply the options over a fresh driver config, the way NewMongoTest does
+func applyOptions(opts ...Option) config {
+	cfg := config{client: options.Client()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func TestOptions(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	fixed := time.Date(2023, 9, 2, 12, 0, 0, 0, time.UTC)
+
+	cfg := applyOptions(
+		WithURI("mongodb://example:27017"),
+		WithDatabase("football"),
+		WithCollection("pl_matches_2024_2025"),
+		WithConnectTimeout(3*time.Second),
+		WithQueryTimeout(4*time.Second),
+		WithServerSelectionTimeout(5*time.Second),
+		WithMaxPoolSize(20),
+		WithMinPoolSize(2),
+		WithReadPreference(readpref.SecondaryPreferred()),
+		WithCredentials("reader", "secret", "football"),
+		WithLocation(london),
+		WithClock(func() time.Time { return fixed }),
+	)
+
+	if cfg.uri != "mongodb://example:27017" || cfg.database != "football" || cfg.collection != "pl_matches_2024_2025" {
+		t.Errorf("uri, database, collection = %q, %q, %q", cfg.uri, cfg.database, cfg.collection)
+	}
+	if cfg.connectTimeout != 3*time.Second || cfg.queryTimeout != 4*time.Second {
+		t.Errorf("connect timeout, query timeout = %v, %v", cfg.connectTimeout, cfg.queryTimeout)
+	}
+	if cfg.location != london || !cfg.now().Equal(fixed) {
+		t.Errorf("location, now = %v, %v", cfg.location, cfg.now())
+	}
+
+	// The read preference is used by Ping as well as by the driver
+	if cfg.readPref.Mode() != readpref.SecondaryPreferredMode {
+		t.Errorf("read preference = %v, want secondaryPreferred", cfg.readPref.Mode())
+	}
+
+	// The driver options are set on the client options, applied after the URI
+	client := cfg.client
+	if client.ServerSelectionTimeout == nil || *client.ServerSelectionTimeout != 5*time.Second {
+		t.Errorf("server selection timeout = %v, want 5s", client.ServerSelectionTimeout)
+	}
+	if client.MaxPoolSize == nil || *client.MaxPoolSize != 20 || client.MinPoolSize == nil || *client.MinPoolSize != 2 {
+		t.Errorf("pool size = %v to %v, want 2 to 20", client.MinPoolSize, client.MaxPoolSize)
+	}
+	if client.ReadPreference == nil || client.ReadPreference.Mode() != readpref.SecondaryPreferredMode {
+		t.Errorf("client read preference = %v, want secondaryPreferred", client.ReadPreference)
+	}
+	if client.Auth == nil || client.Auth.Username != "reader" || client.Auth.Password != "secret" || client.Auth.AuthSource != "football" {
+		t.Errorf("credentials = %+v", client.Auth)
+	}
+}
+
+func TestWithLocationNil(t *testing.T) {
+	// A nil location keeps the local time zone rather than leaving days to be counted in no time zone
+	cfg := config{location: time.Local}
+	WithLocation(nil)(&cfg)
+
+	if cfg.location != time.Local {
+		t.Errorf("location = %v, want %v", cfg.location, time.Local)
+	}
+}
+
+func TestQueryContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		queryTimeout time.Duration
+		// callerTimeout is the deadline of the caller's context, if it has one
+		callerTimeout time.Duration
+		// wantTimeout is how far away the query's deadline should be, zero for no deadline
+		wantTimeout time.Duration
+	}{
+		{name: "no timeout", queryTimeout: 0, wantTimeout: 0},
+		{name: "query timeout", queryTimeout: time.Minute, wantTimeout: time.Minute},
+		{name: "caller's deadline without a query timeout", queryTimeout: 0, callerTimeout: time.Hour, wantTimeout: time.Hour},
+		{name: "caller's deadline is sooner", queryTimeout: time.Hour, callerTimeout: time.Minute, wantTimeout: time.Minute},
+		{name: "query timeout is sooner", queryTimeout: time.Minute, callerTimeout: time.Hour, wantTimeout: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MongoTest{queryTimeout: tt.queryTimeout}
+
+			parent := context.Background()
+			if tt.callerTimeout > 0 {
+				var cancel context.CancelFunc
+				parent, cancel = context.WithTimeout(parent, tt.callerTimeout)
+				defer cancel()
+			}
+
+			ctx, cancel := m.queryContext(parent)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if tt.wantTimeout == 0 {
+				if ok {
+					t.Fatalf("deadline = %v, want none", deadline)
+				}
+			} else if !ok {
+				t.Fatalf("no deadline, want one in %v", tt.wantTimeout)
+			} else if left := time.Until(deadline); left > tt.wantTimeout || left < tt.wantTimeout-time.Second {
+				t.Errorf("deadline in %v, want %v", left, tt.wantTimeout)
+			}
+
+			// Cancelling the query ends it whether or not it has a deadline
+			cancel()
+			if ctx.Err() == nil {
+				t.Error("query context not cancelled")
+			}
+		})
+	}
+
+	// Cancelling the caller's context cancels the query too
+	m := &MongoTest{queryTimeout: time.Minute}
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := m.queryContext(parent)
+	defer cancel()
+
+	cancelParent()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("query context error after the caller cancelled = %v, want %v", ctx.Err(), context.Canceled)
+	}
+}
@@ -0,0 +1,388 @@
+package mongodb_test
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mongodb-test/models"
+)
+
+// SortField is a field matches can be sorted by
+type SortField string
+
+// Create an enumeration of the sort fields, named by their field in the collection
+const (
+	ByKickoff     SortField = "utc_date"
+	ByMatchday    SortField = "matchday"
+	ByLastUpdated SortField = "last_updated"
+	ById          SortField = "id"
+)
+
+// sortKey is one field of a sort order
+type sortKey struct {
+	field      SortField
+	descending bool
+}
+
+// Query selects, orders and limits matches
+// Its methods return a copy with the condition added, so a query can be shared and extended:
+//
+//	q := NewQuery().Team("Liverpool").Status(models.Finished).OrderBy(ByKickoff, true).Limit(5)
+//
+// The zero Query selects every match in kick off order
+type Query struct {
+	ids          []int
+	team         string
+	homeTeam     string
+	awayTeam     string
+	from         time.Time
+	to           time.Time
+	statuses     []models.MatchStatus
+	matchdayFrom int
+	matchdayTo   int
+	competition  string
+	season       int
+	sort         []sortKey
+	skip         int
+	limit        int
+	fields       []string
+}
+
+// NewQuery will return a query selecting every match
+func NewQuery() Query {
+	return Query{}
+}
+
+// IDs will select the matches with any of the ids
+func (q Query) IDs(ids ...int) Query {
+	q.ids = slices.Clone(ids)
+	return q
+}
+
+// Team will select matches the team plays in, home or away, by its short name
+func (q Query) Team(name string) Query {
+	q.team = name
+	return q
+}
+
+// HomeTeam will select matches the team plays at home, by its short name
+func (q Query) HomeTeam(name string) Query {
+	q.homeTeam = name
+	return q
+}
+
+// AwayTeam will select matches the team plays away, by its short name
+func (q Query) AwayTeam(name string) Query {
+	q.awayTeam = name
+	return q
+}
+
+// Between will select matches kicking off from from, inclusive, to to, exclusive
+// A zero time leaves that end of the range open
+func (q Query) Between(from, to time.Time) Query {
+	q.from = from
+	q.to = to
+	return q
+}
+
+// Status will select matches with any of the statuses
+func (q Query) Status(statuses ...models.MatchStatus) Query {
+	q.statuses = slices.Clone(statuses)
+	return q
+}
+
+// Matchdays will select matches on the matchdays from from to to, both inclusive
+// Zero leaves that end of the range open
+func (q Query) Matchdays(from, to int) Query {
+	q.matchdayFrom = from
+	q.matchdayTo = to
+	return q
+}
+
+// Competition will select matches in the competition with the code, such as PL
+func (q Query) Competition(code string) Query {
+	q.competition = code
+	return q
+}
+
+// Season will select matches in the season with the id
+func (q Query) Season(id int) Query {
+	q.season = id
+	return q
+}
+
+// SeasonID will return the id of the season the query is limited to, zero if it isn't limited to one
+func (q Query) SeasonID() int {
+	return q.season
+}
+
+// OrderBy will add a field to sort by, after any already added
+// Matches are always sorted by id last so that the order is stable
+func (q Query) OrderBy(field SortField, descending bool) Query {
+	q.sort = append(slices.Clone(q.sort), sortKey{field: field, descending: descending})
+	return q
+}
+
+// Skip will leave out the first n matches in the query's order, such as to page through them
+func (q Query) Skip(n int) Query {
+	q.skip = n
+	return q
+}
+
+// Limit will return at most n matches, zero returns them all
+func (q Query) Limit(n int) Query {
+	q.limit = n
+	return q
+}
+
+// Fields will only load the fields with the names used in the collection, such as score or home_team.name
+// The other fields of the matches are left with their zero value, id is always loaded
+func (q Query) Fields(fields ...string) Query {
+	q.fields = slices.Clone(fields)
+	return q
+}
+
+// String will describe the query so that equal queries have equal descriptions, such as for a cache key
+// Times are the same whatever their location, so the description doesn't use the formatting of time.Time
+func (q Query) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "ids=%v team=%q home=%q away=%q", q.ids, q.team, q.homeTeam, q.awayTeam)
+	fmt.Fprintf(&b, " from=%s to=%s", queryTime(q.from), queryTime(q.to))
+	fmt.Fprintf(&b, " status=%v matchdays=%d-%d", q.statuses, q.matchdayFrom, q.matchdayTo)
+	fmt.Fprintf(&b, " competition=%q season=%d", q.competition, q.season)
+
+	b.WriteString(" sort=[")
+	for i, key := range q.sort {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(string(key.field))
+		if key.descending {
+			b.WriteString(" desc")
+		}
+	}
+	b.WriteString("]")
+
+	fmt.Fprintf(&b, " skip=%d limit=%d fields=%q", q.skip, q.limit, q.fields)
+
+	return b.String()
+}
+
+// queryTime will format a time in a query's description, as UTC so that equal instants are formatted the same
+func queryTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Filter will return the query document sent to MongoDB
+func (q Query) Filter() bson.D {
+	filter := bson.D{}
+
+	if len(q.ids) > 0 {
+		filter = append(filter, bson.E{Key: "id", Value: bson.D{{Key: "$in", Value: q.ids}}})
+	}
+
+	if q.team != "" {
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "home_team.short_name", Value: q.team}},
+			bson.D{{Key: "away_team.short_name", Value: q.team}},
+		}})
+	}
+
+	if q.homeTeam != "" {
+		filter = append(filter, bson.E{Key: "home_team.short_name", Value: q.homeTeam})
+	}
+
+	if q.awayTeam != "" {
+		filter = append(filter, bson.E{Key: "away_team.short_name", Value: q.awayTeam})
+	}
+
+	if !q.from.IsZero() || !q.to.IsZero() {
+		dates := bson.D{}
+		if !q.from.IsZero() {
+			dates = append(dates, bson.E{Key: "$gte", Value: q.from})
+		}
+		if !q.to.IsZero() {
+			dates = append(dates, bson.E{Key: "$lt", Value: q.to})
+		}
+		filter = append(filter, bson.E{Key: "utc_date", Value: dates})
+	}
+
+	if len(q.statuses) > 0 {
+		filter = append(filter, bson.E{Key: "status", Value: bson.D{{Key: "$in", Value: q.statuses}}})
+	}
+
+	if q.matchdayFrom != 0 || q.matchdayTo != 0 {
+		matchdays := bson.D{}
+		if q.matchdayFrom != 0 {
+			matchdays = append(matchdays, bson.E{Key: "$gte", Value: q.matchdayFrom})
+		}
+		if q.matchdayTo != 0 {
+			matchdays = append(matchdays, bson.E{Key: "$lte", Value: q.matchdayTo})
+		}
+		filter = append(filter, bson.E{Key: "matchday", Value: matchdays})
+	}
+
+	if q.competition != "" {
+		filter = append(filter, bson.E{Key: "competition.code", Value: q.competition})
+	}
+
+	if q.season != 0 {
+		filter = append(filter, bson.E{Key: "season.id", Value: q.season})
+	}
+
+	return filter
+}
+
+// sortKeys will return the fields to sort by, ending with id
+func (q Query) sortKeys() []sortKey {
+	keys := q.sort
+	if len(keys) == 0 {
+		keys = []sortKey{{field: ByKickoff}}
+	}
+
+	return append(slices.Clone(keys), sortKey{field: ById})
+}
+
+// findOptions will return the sort, skip, limit and projection of the query
+func (q Query) findOptions() *options.FindOptions {
+	sort := bson.D{}
+	for _, key := range q.sortKeys() {
+		direction := 1
+		if key.descending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: string(key.field), Value: direction})
+	}
+
+	opts := options.Find().SetSort(sort)
+
+	if q.skip > 0 {
+		opts.SetSkip(int64(q.skip))
+	}
+
+	if q.limit > 0 {
+		opts.SetLimit(int64(q.limit))
+	}
+
+	if len(q.fields) > 0 {
+		projection := bson.D{{Key: "id", Value: 1}}
+		for _, field := range q.fields {
+			projection = append(projection, bson.E{Key: field, Value: 1})
+		}
+		opts.SetProjection(projection)
+	}
+
+	return opts
+}
+
+// Matches will return true if the match is selected by the query
+// This applies the same rules as the filter sent to MongoDB, so the query can be run against matches held in memory
+func (q Query) Matches(m models.Match) bool {
+	if len(q.ids) > 0 && !slices.Contains(q.ids, m.Id) {
+		return false
+	}
+
+	if q.team != "" && m.HomeTeam.ShortName != q.team && m.AwayTeam.ShortName != q.team {
+		return false
+	}
+
+	if q.homeTeam != "" && m.HomeTeam.ShortName != q.homeTeam {
+		return false
+	}
+
+	if q.awayTeam != "" && m.AwayTeam.ShortName != q.awayTeam {
+		return false
+	}
+
+	if !q.from.IsZero() && m.UtcDate.Before(q.from) {
+		return false
+	}
+
+	if !q.to.IsZero() && !m.UtcDate.Before(q.to) {
+		return false
+	}
+
+	if len(q.statuses) > 0 && !slices.Contains(q.statuses, m.Status) {
+		return false
+	}
+
+	if q.matchdayFrom != 0 && m.Matchday < q.matchdayFrom {
+		return false
+	}
+
+	if q.matchdayTo != 0 && m.Matchday > q.matchdayTo {
+		return false
+	}
+
+	if q.competition != "" && m.Competition.Code != q.competition {
+		return false
+	}
+
+	if q.season != 0 && m.Season.Id != q.season {
+		return false
+	}
+
+	return true
+}
+
+// compare will order two matches by the sort order of the query
+func (q Query) compare(a, b models.Match) int {
+	for _, key := range q.sortKeys() {
+		var c int
+		switch key.field {
+		case ByKickoff:
+			c = a.UtcDate.Compare(b.UtcDate)
+		case ByMatchday:
+			c = cmp.Compare(a.Matchday, b.Matchday)
+		case ByLastUpdated:
+			c = a.LastUpdated.Compare(b.LastUpdated)
+		case ById:
+			c = cmp.Compare(a.Id, b.Id)
+		}
+
+		if key.descending {
+			c = -c
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// Apply will run the query against matches held in memory, returning the selected matches in order
+// Fields is ignored, the matches are returned whole
+func (q Query) Apply(matches []models.Match) []models.Match {
+	var selected []models.Match
+
+	for _, match := range matches {
+		if q.Matches(match) {
+			selected = append(selected, match)
+		}
+	}
+
+	slices.SortFunc(selected, q.compare)
+
+	if q.skip > 0 {
+		selected = selected[min(q.skip, len(selected)):]
+	}
+
+	if q.limit > 0 && len(selected) > q.limit {
+		selected = selected[:q.limit]
+	}
+
+	return selected
+}
@@ -0,0 +1,93 @@
+package mongodb_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"mongodb-test/models"
+)
+
+func TestQueryString(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	from := time.Date(2023, 8, 12, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+	now := time.Now()
+
+	base := NewQuery().Team("Liverpool").Status(models.Finished).OrderBy(ByKickoff, true).Limit(5)
+
+	// The same instants in other locations, or read from a clock with a monotonic reading, are the same query
+	same := []Query{
+		base.Between(from, to),
+		base.Between(from.In(london), to.In(london)),
+		base.Between(now.Add(from.Sub(now)), to),
+	}
+	for _, q := range same[1:] {
+		if q.String() != same[0].String() {
+			t.Errorf("equal queries differ:\n%s\n%s", q, same[0])
+		}
+	}
+
+	// Changing any part of the query changes its description
+	different := []Query{
+		base,
+		base.Between(from.Add(time.Nanosecond), to),
+		base.Between(from, time.Time{}),
+		base.IDs(1, 2),
+		base.Team("Arsenal"),
+		base.HomeTeam("Liverpool"),
+		base.AwayTeam("Liverpool"),
+		base.Status(models.Finished, models.Timed),
+		base.Matchdays(1, 3),
+		base.Competition("PL"),
+		base.Season(1564),
+		base.OrderBy(ById, false),
+		NewQuery().Team("Liverpool").Status(models.Finished).OrderBy(ByKickoff, false).Limit(5),
+		base.Skip(5),
+		base.Limit(6),
+		base.Fields("score"),
+	}
+
+	seen := map[string]int{same[0].String(): -1}
+	for i, q := range different {
+		if j, ok := seen[q.String()]; ok {
+			t.Errorf("queries %d and %d have the same description: %s", i, j, q)
+		}
+		seen[q.String()] = i
+	}
+}
+
+func TestQueryApplySkipLimit(t *testing.T) {
+	kickoff := time.Date(2023, 8, 12, 15, 0, 0, 0, time.UTC)
+
+	var matches matchFinder
+	for id := 1; id <= 5; id++ {
+		matches = append(matches, models.Match{Id: id, UtcDate: kickoff.Add(time.Duration(id) * time.Hour)})
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		want []int
+	}{
+		{"everything", NewQuery(), []int{1, 2, 3, 4, 5}},
+		{"first page", NewQuery().Limit(2), []int{1, 2}},
+		{"second page", NewQuery().Skip(2).Limit(2), []int{3, 4}},
+		{"last page", NewQuery().Skip(4).Limit(2), []int{5}},
+		{"past the end", NewQuery().Skip(6).Limit(2), nil},
+		{"skip without a limit", NewQuery().Skip(3), []int{4, 5}},
+		{"skip in the query's order", NewQuery().OrderBy(ByKickoff, true).Skip(1).Limit(2), []int{4, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(tt.q.Apply(matches)); !slices.Equal(got, tt.want) {
+				t.Errorf("Apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
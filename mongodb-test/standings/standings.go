@@ -0,0 +1,244 @@
+// Package standings computes league tables from match results
+package standings
+
+import (
+	"cmp"
+	"slices"
+
+	"mongodb-test/models"
+)
+
+// Venue selects which of a team's matches count towards the table
+type Venue int
+
+// Create an enumeration of the venues
+const (
+	// All counts every match
+	All Venue = iota
+	// Home counts only the matches a team plays at home
+	Home
+	// Away counts only the matches a team plays away
+	Away
+)
+
+// String will return the name of the venue
+func (v Venue) String() string {
+	switch v {
+	case Home:
+		return "home"
+	case Away:
+		return "away"
+	default:
+		return "all"
+	}
+}
+
+// Points awarded for each result
+const (
+	pointsForWin  = 3
+	pointsForDraw = 1
+)
+
+// Options control which matches are included in a table, fields with their zero value are ignored
+type Options struct {
+	// Season is the id of the season to compute the table for
+	Season int
+	// Matchday computes the table as it stood after this matchday
+	Matchday int
+	// Venue restricts the table to home or away matches
+	Venue Venue
+}
+
+// Row is a team's line in the table
+type Row struct {
+	Position       int         `json:"position"`
+	Team           models.Team `json:"team"`
+	Played         int         `json:"played"`
+	Won            int         `json:"won"`
+	Drawn          int         `json:"drawn"`
+	Lost           int         `json:"lost"`
+	GoalsFor       int         `json:"goalsFor"`
+	GoalsAgainst   int         `json:"goalsAgainst"`
+	GoalDifference int         `json:"goalDifference"`
+	Points         int         `json:"points"`
+}
+
+// record will add the result of a match to the row
+func (r *Row) record(goalsFor, goalsAgainst int) {
+	r.Played++
+	r.GoalsFor += goalsFor
+	r.GoalsAgainst += goalsAgainst
+	r.GoalDifference = r.GoalsFor - r.GoalsAgainst
+
+	switch {
+	case goalsFor > goalsAgainst:
+		r.Won++
+		r.Points += pointsForWin
+	case goalsFor == goalsAgainst:
+		r.Drawn++
+		r.Points += pointsForDraw
+	default:
+		r.Lost++
+	}
+}
+
+// Table is a league table in position order
+type Table []Row
+
+// Completed will return true if the match has a final result that counts towards the table
+func Completed(m models.Match) bool {
+	return m.Status == models.Finished || m.Status == models.Awarded
+}
+
+// LatestSeason will return the id of the season of the most recent match, or zero if there are none
+func LatestSeason(matches []models.Match) int {
+	var season int
+	var latest models.Match
+
+	for _, match := range matches {
+		if season == 0 || match.UtcDate.After(latest.UtcDate) {
+			season = match.Season.Id
+			latest = match
+		}
+	}
+
+	return season
+}
+
+// Compute will create the table from the matches
+// Every team playing in the season is listed, including those yet to complete a match
+// Teams are ordered by the Premier League rules: points, goal difference, goals scored,
+// then points and away goals in the matches between the teams still level
+// Teams that can't be separated are listed by name
+func Compute(matches []models.Match, opts Options) Table {
+	rows := make(map[int]*Row)
+	var counted []models.Match
+
+	for _, match := range matches {
+		if opts.Season != 0 && match.Season.Id != opts.Season {
+			continue
+		}
+
+		// List both teams even if the match doesn't count yet
+		for _, team := range []models.Team{match.HomeTeam, match.AwayTeam} {
+			if _, ok := rows[team.Id]; !ok {
+				rows[team.Id] = &Row{Team: team}
+			}
+		}
+
+		if !Completed(match) || (opts.Matchday != 0 && match.Matchday > opts.Matchday) {
+			continue
+		}
+		counted = append(counted, match)
+
+		home, away := match.Score.FullTime.Home, match.Score.FullTime.Away
+
+		if opts.Venue != Away {
+			rows[match.HomeTeam.Id].record(home, away)
+		}
+
+		if opts.Venue != Home {
+			rows[match.AwayTeam.Id].record(away, home)
+		}
+	}
+
+	// Create the table in order of the overall record
+	table := make(Table, 0, len(rows))
+	for _, row := range rows {
+		table = append(table, *row)
+	}
+
+	slices.SortFunc(table, compareRecord)
+
+	// Separate teams that are level using the matches between them
+	for start := 0; start < len(table); {
+		end := start + 1
+		for end < len(table) && compareRecord(table[start], table[end]) == 0 {
+			end++
+		}
+
+		if end-start > 1 {
+			breakTie(table[start:end], counted, opts.Venue)
+		}
+
+		start = end
+	}
+
+	for i := range table {
+		table[i].Position = i + 1
+	}
+
+	return table
+}
+
+// compareRecord will order rows by points, goal difference then goals scored, best first
+func compareRecord(a, b Row) int {
+	return cmp.Or(
+		cmp.Compare(b.Points, a.Points),
+		cmp.Compare(b.GoalDifference, a.GoalDifference),
+		cmp.Compare(b.GoalsFor, a.GoalsFor),
+	)
+}
+
+// breakTie will order rows that are level on their overall record by the matches between them
+// Teams still level are separated again using only the matches between them, as the rules require
+func breakTie(tied Table, matches []models.Match, venue Venue) {
+	points, awayGoals := headToHead(tied, matches, venue)
+
+	compare := func(a, b Row) int {
+		return cmp.Or(
+			cmp.Compare(points[b.Team.Id], points[a.Team.Id]),
+			cmp.Compare(awayGoals[b.Team.Id], awayGoals[a.Team.Id]),
+		)
+	}
+
+	slices.SortFunc(tied, func(a, b Row) int {
+		return cmp.Or(compare(a, b), cmp.Compare(a.Team.Name, b.Team.Name))
+	})
+
+	// Separate any smaller group that is still level, teams that can't be separated stay in name order
+	for start := 0; start < len(tied); {
+		end := start + 1
+		for end < len(tied) && compare(tied[start], tied[end]) == 0 {
+			end++
+		}
+
+		if end-start > 1 && end-start < len(tied) {
+			breakTie(tied[start:end], matches, venue)
+		}
+
+		start = end
+	}
+}
+
+// headToHead will return the points and away goals each team earned in the matches between the teams
+func headToHead(tied Table, matches []models.Match, venue Venue) (map[int]int, map[int]int) {
+	ids := make(map[int]bool)
+	for _, row := range tied {
+		ids[row.Team.Id] = true
+	}
+
+	points := make(map[int]int)
+	awayGoals := make(map[int]int)
+
+	for _, match := range matches {
+		if !ids[match.HomeTeam.Id] || !ids[match.AwayTeam.Id] {
+			continue
+		}
+
+		var home, away Row
+		home.record(match.Score.FullTime.Home, match.Score.FullTime.Away)
+		away.record(match.Score.FullTime.Away, match.Score.FullTime.Home)
+
+		if venue != Away {
+			points[match.HomeTeam.Id] += home.Points
+		}
+
+		if venue != Home {
+			points[match.AwayTeam.Id] += away.Points
+			awayGoals[match.AwayTeam.Id] += away.GoalsFor
+		}
+	}
+
+	return points, awayGoals
+}
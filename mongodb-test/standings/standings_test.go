@@ -0,0 +1,209 @@
+package standings
+
+import (
+	"slices"
+	"testing"
+
+	"mongodb-test/models"
+)
+
+// team will create a team whose id, name and short name all come from the name
+func team(id int, name string) models.Team {
+	return models.Team{Id: id, Name: name, ShortName: name}
+}
+
+// result will create a finished match of the first season
+func result(home models.Team, homeGoals, awayGoals int, away models.Team) models.Match {
+	return models.Match{
+		Season:   models.Season{Id: 1},
+		Status:   models.Finished,
+		Matchday: 1,
+		HomeTeam: home,
+		AwayTeam: away,
+		Score:    models.Score{FullTime: models.FullTime{Home: homeGoals, Away: awayGoals}},
+	}
+}
+
+// order will return the names of the teams in the table that are in names, in table order
+func order(table Table, names ...string) []string {
+	var got []string
+	for _, row := range table {
+		if slices.Contains(names, row.Team.Name) {
+			got = append(got, row.Team.Name)
+		}
+	}
+	return got
+}
+
+func TestComputeRecord(t *testing.T) {
+	a, b := team(1, "A"), team(2, "B")
+
+	table := Compute([]models.Match{
+		result(a, 3, 1, b),
+		result(b, 2, 2, a),
+		// Matches without a result only list the teams
+		{Season: models.Season{Id: 1}, Status: models.Timed, HomeTeam: a, AwayTeam: team(3, "C")},
+	}, Options{})
+
+	want := Table{
+		{Position: 1, Team: a, Played: 2, Won: 1, Drawn: 1, GoalsFor: 5, GoalsAgainst: 3, GoalDifference: 2, Points: 4},
+		{Position: 2, Team: b, Played: 2, Drawn: 1, Lost: 1, GoalsFor: 3, GoalsAgainst: 5, GoalDifference: -2, Points: 1},
+		{Position: 3, Team: team(3, "C")},
+	}
+
+	if !slices.Equal(table, want) {
+		t.Errorf("Compute =\n%+v\nwant\n%+v", table, want)
+	}
+}
+
+func TestComputeTieBreakers(t *testing.T) {
+	alpha, beta, gamma := team(1, "Alpha"), team(2, "Beta"), team(3, "Gamma")
+	others := []models.Team{team(10, "P"), team(11, "Q"), team(12, "R"), team(13, "S"), team(14, "T"), team(15, "U"), team(16, "V")}
+
+	tests := []struct {
+		name    string
+		matches []models.Match
+		teams   []string
+		want    []string
+	}{
+		{
+			name: "goal difference",
+			matches: []models.Match{
+				result(alpha, 1, 0, others[0]),
+				result(beta, 3, 0, others[1]),
+			},
+			teams: []string{"Alpha", "Beta"},
+			want:  []string{"Beta", "Alpha"},
+		},
+		{
+			name: "goals scored",
+			matches: []models.Match{
+				result(alpha, 1, 0, others[0]),
+				result(beta, 2, 1, others[1]),
+			},
+			teams: []string{"Alpha", "Beta"},
+			want:  []string{"Beta", "Alpha"},
+		},
+		{
+			// Beta beat Alpha, and both have 3 points, no goal difference and 1 goal overall
+			name: "two way head to head points",
+			matches: []models.Match{
+				result(alpha, 0, 1, beta),
+				result(others[0], 1, 0, beta),
+				result(alpha, 1, 0, others[1]),
+			},
+			teams: []string{"Alpha", "Beta"},
+			want:  []string{"Beta", "Alpha"},
+		},
+		{
+			// Level on head to head points from two draws, Beta scored more away from home
+			name: "two way head to head away goals",
+			matches: []models.Match{
+				result(alpha, 2, 2, beta),
+				result(beta, 1, 1, alpha),
+			},
+			teams: []string{"Alpha", "Beta"},
+			want:  []string{"Beta", "Alpha"},
+		},
+		{
+			// Teams that can't be separated are listed by name
+			name: "two way unbreakable",
+			matches: []models.Match{
+				result(beta, 1, 0, others[0]),
+				result(alpha, 1, 0, others[1]),
+			},
+			teams: []string{"Alpha", "Beta"},
+			want:  []string{"Alpha", "Beta"},
+		},
+		{
+			// Gamma beat both, and Alpha and Beta drew, so Gamma is first and Alpha and Beta are still level on head to head
+			// Applying head to head again to Alpha and Beta can't separate them, so they are listed by name
+			name: "three way head to head points",
+			matches: []models.Match{
+				result(alpha, 0, 1, gamma),
+				result(beta, 0, 1, gamma),
+				result(alpha, 0, 0, beta),
+				// Make the overall records level at 6 points, 3 scored and 3 conceded
+				result(alpha, 1, 0, others[0]),
+				result(alpha, 1, 1, others[1]),
+				result(alpha, 1, 1, others[2]),
+				result(beta, 1, 0, others[3]),
+				result(beta, 1, 1, others[4]),
+				result(beta, 1, 1, others[5]),
+				result(gamma, 1, 3, others[6]),
+			},
+			teams: []string{"Alpha", "Beta", "Gamma"},
+			want:  []string{"Gamma", "Alpha", "Beta"},
+		},
+		{
+			// Alpha and Beta have 4 head to head points and 1 away goal each against all three
+			// Only their own draw, where Beta scored away, separates them when head to head is applied again
+			name: "three way head to head applied again to the teams still level",
+			matches: []models.Match{
+				result(alpha, 1, 1, beta),
+				result(gamma, 0, 1, alpha),
+				result(beta, 1, 0, gamma),
+				// Make the overall records level at 7 points, 3 scored and 3 conceded
+				result(alpha, 1, 0, others[0]),
+				result(others[1], 2, 0, alpha),
+				result(beta, 1, 0, others[2]),
+				result(others[3], 2, 0, beta),
+				result(gamma, 1, 0, others[4]),
+				result(gamma, 1, 0, others[5]),
+				result(gamma, 1, 1, others[6]),
+			},
+			teams: []string{"Alpha", "Beta", "Gamma"},
+			want:  []string{"Beta", "Alpha", "Gamma"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := Compute(tt.matches, Options{})
+
+			// Show the rows of the teams if they are out of order
+			var rows []Row
+			for _, row := range table {
+				if slices.Contains(tt.teams, row.Team.Name) {
+					rows = append(rows, row)
+				}
+			}
+
+			if got := order(table, tt.teams...); !slices.Equal(got, tt.want) {
+				t.Errorf("order = %v, want %v\n%+v", got, tt.want, rows)
+			}
+		})
+	}
+}
+
+func TestComputeOptions(t *testing.T) {
+	a, b := team(1, "A"), team(2, "B")
+
+	second := result(b, 2, 0, a)
+	second.Matchday = 2
+
+	otherSeason := result(b, 5, 0, a)
+	otherSeason.Season.Id = 2
+
+	matches := []models.Match{result(a, 1, 0, b), second, otherSeason}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"season", Options{Season: 1}, []string{"B", "A"}},
+		{"after matchday", Options{Season: 1, Matchday: 1}, []string{"A", "B"}},
+		{"home", Options{Season: 1, Venue: Home}, []string{"B", "A"}},
+		{"away", Options{Season: 1, Venue: Away}, []string{"B", "A"}},
+		{"other season", Options{Season: 2}, []string{"B", "A"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := order(Compute(matches, tt.opts), "A", "B"); !slices.Equal(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
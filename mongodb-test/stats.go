@@ -0,0 +1,365 @@
+package mongodb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mongodb-test/models"
+)
+
+// ErrStatsQuery is returned when a statistic is given a query with an order, skip, limit or fields, which statistics don't use
+var ErrStatsQuery = errors.New("statistics can't order, skip, limit or choose the fields of the matches")
+
+// Fields of the full time score used by the statistics pipelines
+const (
+	homeGoals = "$score.full_time.home"
+	awayGoals = "$score.full_time.away"
+)
+
+// MatchdayGoals is the number of goals scored on a matchday
+type MatchdayGoals struct {
+	Matchday      int     `bson:"matchday" json:"matchday"`
+	Matches       int     `bson:"matches" json:"matches"`
+	Goals         int     `bson:"goals" json:"goals"`
+	HomeGoals     int     `bson:"home_goals" json:"homeGoals"`
+	AwayGoals     int     `bson:"away_goals" json:"awayGoals"`
+	GoalsPerMatch float64 `bson:"-" json:"goalsPerMatch"`
+}
+
+// WinRates are how often the home team, the away team or neither wins
+type WinRates struct {
+	Matches     int     `bson:"matches" json:"matches"`
+	HomeWins    int     `bson:"home_wins" json:"homeWins"`
+	AwayWins    int     `bson:"away_wins" json:"awayWins"`
+	Draws       int     `bson:"draws" json:"draws"`
+	HomeWinRate float64 `bson:"-" json:"homeWinRate"`
+	AwayWinRate float64 `bson:"-" json:"awayWinRate"`
+	DrawRate    float64 `bson:"-" json:"drawRate"`
+}
+
+// BigWin is a match and the margin it was won by
+type BigWin struct {
+	Match  models.Match `bson:",inline" json:"match"`
+	Margin int          `bson:"margin" json:"margin"`
+}
+
+// TeamCleanSheets is the number of matches a team didn't concede in
+type TeamCleanSheets struct {
+	Team        models.Team `bson:"team" json:"team"`
+	Played      int         `bson:"played" json:"played"`
+	CleanSheets int         `bson:"clean_sheets" json:"cleanSheets"`
+}
+
+// RefereeAppearances is the number of matches an official took part in
+type RefereeAppearances struct {
+	Referee models.Referee `bson:"referee" json:"referee"`
+	Matches int            `bson:"matches" json:"matches"`
+}
+
+// HeadToHead is a team's record against an opponent
+type HeadToHead struct {
+	Team         string `bson:"-" json:"team"`
+	Opponent     string `bson:"-" json:"opponent"`
+	Played       int    `bson:"played" json:"played"`
+	Won          int    `bson:"won" json:"won"`
+	Drawn        int    `bson:"drawn" json:"drawn"`
+	Lost         int    `bson:"lost" json:"lost"`
+	GoalsFor     int    `bson:"goals_for" json:"goalsFor"`
+	GoalsAgainst int    `bson:"goals_against" json:"goalsAgainst"`
+}
+
+// aggregator runs aggregation pipelines, *mongo.Collection implements it
+type aggregator interface {
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+}
+
+// rate will return count as a fraction of total, or zero if there is no total
+func rate(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(count) / float64(total)
+}
+
+// countIf will return an accumulator that counts the documents where the condition holds
+func countIf(condition bson.D) bson.D {
+	return bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{condition, 1, 0}}}}}
+}
+
+// statsPipeline will return the pipeline running the stages over the completed matches selected by the query
+// Statistics only count matches with a final result, so the query's statuses can only narrow that further
+// The stages decide the order and number of the results, so a query that orders, skips, limits or chooses fields is rejected
+func statsPipeline(q Query, stages mongo.Pipeline) (mongo.Pipeline, error) {
+	if len(q.sort) > 0 || q.skip > 0 || q.limit > 0 || len(q.fields) > 0 {
+		return nil, ErrStatsQuery
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: q.Filter()}},
+		{{Key: "$match", Value: bson.D{{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{models.Finished, models.Awarded}}}}}}},
+	}
+
+	return append(pipeline, stages...), nil
+}
+
+// aggregate will run the stages over the completed matches selected by the query and decode the results
+func (m *MongoTest) aggregate(ctx context.Context, q Query, stages mongo.Pipeline, results any) error {
+	pipeline, err := statsPipeline(q, stages)
+	if err != nil {
+		return err
+	}
+
+	// Limit the query to the query timeout
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	// Run the pipeline
+	cursor, err := m.aggregator.Aggregate(ctx, pipeline)
+
+	// Check for errors
+	if err != nil {
+		m.logger.Printf("Error aggregating matches: %v", err)
+		return err
+	}
+
+	// Close the cursor when the function returns
+	defer cursor.Close(ctx)
+
+	// Decode the results
+	err = cursor.All(ctx, results)
+
+	// Check for errors
+	if err != nil {
+		m.logger.Printf("Error decoding statistics: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// goalsPerMatchdayStages will total the goals of each matchday
+func goalsPerMatchdayStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$matchday"},
+			{Key: "matches", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "home_goals", Value: bson.D{{Key: "$sum", Value: homeGoals}}},
+			{Key: "away_goals", Value: bson.D{{Key: "$sum", Value: awayGoals}}},
+		}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "matchday", Value: "$_id"},
+			{Key: "matches", Value: 1},
+			{Key: "home_goals", Value: 1},
+			{Key: "away_goals", Value: 1},
+			{Key: "goals", Value: bson.D{{Key: "$add", Value: bson.A{"$home_goals", "$away_goals"}}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "matchday", Value: 1}}}},
+	}
+}
+
+// GoalsPerMatchday will return the goals scored on each matchday of the matches selected by the query, in matchday order
+// Matchdays of different seasons are counted together, so select a season with the query
+func (m *MongoTest) GoalsPerMatchday(ctx context.Context, q Query) ([]MatchdayGoals, error) {
+	var results []MatchdayGoals
+
+	err := m.aggregate(ctx, q, goalsPerMatchdayStages(), &results)
+
+	// Check for errors
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].GoalsPerMatch = rate(results[i].Goals, results[i].Matches)
+	}
+
+	return results, nil
+}
+
+// winRateStages will count the home wins, away wins and draws
+func winRateStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "matches", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "home_wins", Value: countIf(bson.D{{Key: "$gt", Value: bson.A{homeGoals, awayGoals}}})},
+			{Key: "away_wins", Value: countIf(bson.D{{Key: "$lt", Value: bson.A{homeGoals, awayGoals}}})},
+			{Key: "draws", Value: countIf(bson.D{{Key: "$eq", Value: bson.A{homeGoals, awayGoals}}})},
+		}}},
+	}
+}
+
+// HomeAwayWinRates will return how often the home and away teams won the matches selected by the query
+func (m *MongoTest) HomeAwayWinRates(ctx context.Context, q Query) (WinRates, error) {
+	var results []WinRates
+
+	err := m.aggregate(ctx, q, winRateStages(), &results)
+
+	// Check for errors, or no matches
+	if err != nil || len(results) == 0 {
+		return WinRates{}, err
+	}
+
+	rates := results[0]
+	rates.HomeWinRate = rate(rates.HomeWins, rates.Matches)
+	rates.AwayWinRate = rate(rates.AwayWins, rates.Matches)
+	rates.DrawRate = rate(rates.Draws, rates.Matches)
+
+	return rates, nil
+}
+
+// biggestWinsStages will order the won matches by margin, keeping the first n
+func biggestWinsStages(n int) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.D{{Key: "$ne", Value: bson.A{homeGoals, awayGoals}}}}}}},
+		{{Key: "$addFields", Value: bson.D{
+			{Key: "margin", Value: bson.D{{Key: "$abs", Value: bson.D{{Key: "$subtract", Value: bson.A{homeGoals, awayGoals}}}}}},
+			{Key: "total_goals", Value: bson.D{{Key: "$add", Value: bson.A{homeGoals, awayGoals}}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "margin", Value: -1},
+			{Key: "total_goals", Value: -1},
+			{Key: "utc_date", Value: 1},
+			{Key: "id", Value: 1},
+		}}},
+		{{Key: "$limit", Value: n}},
+	}
+}
+
+// BiggestWins will return the n matches selected by the query with the largest winning margins, n must be greater than zero
+// Matches with the same margin are ordered by the most goals, then by kick off
+func (m *MongoTest) BiggestWins(ctx context.Context, q Query, n int) ([]BigWin, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("biggest wins: n must be greater than zero, got %d", n)
+	}
+
+	var results []BigWin
+
+	err := m.aggregate(ctx, q, biggestWinsStages(n), &results)
+
+	// Check for errors
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// cleanSheetStages will count the matches each team played and didn't concede in
+func cleanSheetStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		// Look at each match from both sides
+		{{Key: "$project", Value: bson.D{
+			{Key: "sides", Value: bson.A{
+				bson.D{{Key: "team", Value: "$home_team"}, {Key: "conceded", Value: awayGoals}},
+				bson.D{{Key: "team", Value: "$away_team"}, {Key: "conceded", Value: homeGoals}},
+			}},
+		}}},
+		{{Key: "$unwind", Value: "$sides"}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$sides.team.id"},
+			{Key: "team", Value: bson.D{{Key: "$first", Value: "$sides.team"}}},
+			{Key: "played", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "clean_sheets", Value: countIf(bson.D{{Key: "$eq", Value: bson.A{"$sides.conceded", 0}}})},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "clean_sheets", Value: -1}, {Key: "team.name", Value: 1}}}},
+	}
+}
+
+// CleanSheets will return the number of the matches selected by the query each team didn't concede in
+// Teams are ordered by the most clean sheets, then by name
+func (m *MongoTest) CleanSheets(ctx context.Context, q Query) ([]TeamCleanSheets, error) {
+	var results []TeamCleanSheets
+
+	err := m.aggregate(ctx, q, cleanSheetStages(), &results)
+
+	// Check for errors
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// refereeStages will count the matches each official appears in
+func refereeStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$unwind", Value: "$referees"}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$referees.id"},
+			{Key: "referee", Value: bson.D{{Key: "$first", Value: "$referees"}}},
+			{Key: "matches", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "matches", Value: -1}, {Key: "referee.name", Value: 1}}}},
+	}
+}
+
+// RefereeCounts will return the number of the matches selected by the query each official appears in
+// Officials are ordered by the most matches, then by name
+func (m *MongoTest) RefereeCounts(ctx context.Context, q Query) ([]RefereeAppearances, error) {
+	var results []RefereeAppearances
+
+	err := m.aggregate(ctx, q, refereeStages(), &results)
+
+	// Check for errors
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// headToHeadStages will total the team's results in the matches between the team and the opponent
+func headToHeadStages(team, opponent string) mongo.Pipeline {
+	// The team's home field, used to tell which side of the score is theirs
+	isHome := bson.D{{Key: "$eq", Value: bson.A{"$home_team.short_name", team}}}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "home_team.short_name", Value: team}, {Key: "away_team.short_name", Value: opponent}},
+			bson.D{{Key: "home_team.short_name", Value: opponent}, {Key: "away_team.short_name", Value: team}},
+		}}}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "goals_for", Value: bson.D{{Key: "$cond", Value: bson.A{isHome, homeGoals, awayGoals}}}},
+			{Key: "goals_against", Value: bson.D{{Key: "$cond", Value: bson.A{isHome, awayGoals, homeGoals}}}},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "played", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "won", Value: countIf(bson.D{{Key: "$gt", Value: bson.A{"$goals_for", "$goals_against"}}})},
+			{Key: "drawn", Value: countIf(bson.D{{Key: "$eq", Value: bson.A{"$goals_for", "$goals_against"}}})},
+			{Key: "lost", Value: countIf(bson.D{{Key: "$lt", Value: bson.A{"$goals_for", "$goals_against"}}})},
+			{Key: "goals_for", Value: bson.D{{Key: "$sum", Value: "$goals_for"}}},
+			{Key: "goals_against", Value: bson.D{{Key: "$sum", Value: "$goals_against"}}},
+		}}},
+	}
+}
+
+// HeadToHead will return the team's record against the opponent, by their short names, in the matches selected by the query
+func (m *MongoTest) HeadToHead(ctx context.Context, q Query, team, opponent string) (HeadToHead, error) {
+	var results []HeadToHead
+
+	err := m.aggregate(ctx, q, headToHeadStages(team, opponent), &results)
+
+	// Check for errors
+	if err != nil {
+		return HeadToHead{}, err
+	}
+
+	// The teams haven't played each other if there are no results
+	record := HeadToHead{}
+	if len(results) > 0 {
+		record = results[0]
+	}
+	record.Team = team
+	record.Opponent = opponent
+
+	return record, nil
+}
@@ -0,0 +1,766 @@
+package mongodb_test
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mongodb-test/models"
+)
+
+// pipelineRunner runs aggregation pipelines over documents held in memory
+// It evaluates the stages and operators the statistics use, and fails the test on any others rather than ignoring them
+type pipelineRunner struct {
+	t    testing.TB
+	docs []bson.D
+}
+
+// newPipelineRunner will hold the matches as the documents MongoDB would store
+func newPipelineRunner(t testing.TB, matches []models.Match) *pipelineRunner {
+	t.Helper()
+
+	r := &pipelineRunner{t: t}
+	for _, match := range matches {
+		r.docs = append(r.docs, r.roundTrip(match))
+	}
+
+	return r
+}
+
+// newStatsMongoTest will return a MongoTest that runs its statistics over the matches in memory
+func newStatsMongoTest(t testing.TB, matches []models.Match) *MongoTest {
+	return &MongoTest{
+		aggregator: newPipelineRunner(t, matches),
+		logger:     log.New(io.Discard, "", 0),
+	}
+}
+
+// roundTrip will marshal v to BSON and back, so that values have the types they would have in MongoDB
+func (r *pipelineRunner) roundTrip(v any) bson.D {
+	r.t.Helper()
+
+	data, err := bson.Marshal(v)
+	if err != nil {
+		r.t.Fatalf("marshalling %v: %v", v, err)
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		r.t.Fatalf("unmarshalling %v: %v", v, err)
+	}
+
+	return doc
+}
+
+func (r *pipelineRunner) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	r.t.Helper()
+
+	stages := r.roundTrip(bson.D{{Key: "pipeline", Value: pipeline}})[0].Value.(primitive.A)
+
+	docs := slices.Clone(r.docs)
+	for _, stage := range stages {
+		docs = r.stage(docs, stage.(bson.D))
+	}
+
+	results := make([]any, len(docs))
+	for i, doc := range docs {
+		results[i] = doc
+	}
+
+	return mongo.NewCursorFromDocuments(results, nil, nil)
+}
+
+// stage will run a single stage over the documents
+func (r *pipelineRunner) stage(docs []bson.D, stage bson.D) []bson.D {
+	r.t.Helper()
+
+	op, arg := stage[0].Key, stage[0].Value
+	var out []bson.D
+
+	switch op {
+	case "$match":
+		for _, doc := range docs {
+			if r.matches(doc, arg.(bson.D)) {
+				out = append(out, doc)
+			}
+		}
+
+	case "$addFields":
+		for _, doc := range docs {
+			doc = slices.Clone(doc)
+			for _, field := range arg.(bson.D) {
+				doc = setField(doc, field.Key, r.expr(doc, field.Value))
+			}
+			out = append(out, doc)
+		}
+
+	case "$project":
+		for _, doc := range docs {
+			id, _ := lookup(doc, "_id")
+			projected := bson.D{{Key: "_id", Value: id}}
+			for _, field := range arg.(bson.D) {
+				switch {
+				case field.Key == "_id" && !truthy(field.Value):
+					projected = projected[1:]
+				case isNumber(field.Value) || isBool(field.Value):
+					value, _ := lookup(doc, field.Key)
+					projected = setField(projected, field.Key, value)
+				default:
+					projected = setField(projected, field.Key, r.expr(doc, field.Value))
+				}
+			}
+			out = append(out, projected)
+		}
+
+	case "$unwind":
+		path := strings.TrimPrefix(arg.(string), "$")
+		for _, doc := range docs {
+			values, _ := lookup(doc, path)
+			array, _ := values.(primitive.A)
+			for _, value := range array {
+				out = append(out, setField(slices.Clone(doc), path, value))
+			}
+		}
+
+	case "$group":
+		out = r.group(docs, arg.(bson.D))
+
+	case "$sort":
+		out = slices.Clone(docs)
+		sort.SliceStable(out, func(i, j int) bool {
+			for _, key := range arg.(bson.D) {
+				a, _ := lookup(out[i], key.Key)
+				b, _ := lookup(out[j], key.Key)
+				if c := compare(a, b); c != 0 {
+					return c*int(number(key.Value)) < 0
+				}
+			}
+			return false
+		})
+
+	case "$limit":
+		out = docs[:min(len(docs), int(number(arg)))]
+
+	default:
+		r.t.Fatalf("unsupported stage %s", op)
+	}
+
+	return out
+}
+
+// group will run a $group stage, keeping the groups in the order they are first seen
+func (r *pipelineRunner) group(docs []bson.D, spec bson.D) []bson.D {
+	r.t.Helper()
+
+	var groups []bson.D
+	index := make(map[string]int)
+
+	for _, doc := range docs {
+		id := r.expr(doc, spec[0].Value)
+		key := fmt.Sprint(id)
+
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, bson.D{{Key: "_id", Value: id}})
+		}
+
+		for _, field := range spec[1:] {
+			acc := field.Value.(bson.D)[0]
+			value := r.expr(doc, acc.Value)
+			current, seen := lookup(groups[i], field.Key)
+
+			switch acc.Key {
+			case "$sum":
+				if !seen {
+					current = int64(0)
+				}
+				if isNumber(value) {
+					current = add(current, value)
+				}
+			case "$first":
+				if !seen {
+					current = value
+				}
+			default:
+				r.t.Fatalf("unsupported accumulator %s", acc.Key)
+			}
+
+			groups[i] = setField(groups[i], field.Key, current)
+		}
+	}
+
+	return groups
+}
+
+// matches will return true if the document is selected by the query filter
+func (r *pipelineRunner) matches(doc bson.D, filter bson.D) bool {
+	r.t.Helper()
+
+	for _, elem := range filter {
+		switch elem.Key {
+		case "$or":
+			found := false
+			for _, clause := range elem.Value.(primitive.A) {
+				found = found || r.matches(doc, clause.(bson.D))
+			}
+			if !found {
+				return false
+			}
+
+		case "$and":
+			for _, clause := range elem.Value.(primitive.A) {
+				if !r.matches(doc, clause.(bson.D)) {
+					return false
+				}
+			}
+
+		case "$expr":
+			if !truthy(r.expr(doc, elem.Value)) {
+				return false
+			}
+
+		default:
+			value, _ := lookup(doc, elem.Key)
+			ops, isOps := elem.Value.(bson.D)
+			if !isOps || len(ops) == 0 || !strings.HasPrefix(ops[0].Key, "$") {
+				if compare(value, elem.Value) != 0 {
+					return false
+				}
+				continue
+			}
+
+			for _, op := range ops {
+				if !r.compareOp(op.Key, value, op.Value) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// compareOp will apply a comparison operator such as $gte, or $in, to a field's value
+func (r *pipelineRunner) compareOp(op string, value, arg any) bool {
+	r.t.Helper()
+
+	switch op {
+	case "$in":
+		for _, candidate := range arg.(primitive.A) {
+			if compare(value, candidate) == 0 {
+				return true
+			}
+		}
+		return false
+	case "$eq":
+		return compare(value, arg) == 0
+	case "$ne":
+		return compare(value, arg) != 0
+	case "$gt":
+		return compare(value, arg) > 0
+	case "$gte":
+		return compare(value, arg) >= 0
+	case "$lt":
+		return compare(value, arg) < 0
+	case "$lte":
+		return compare(value, arg) <= 0
+	}
+
+	r.t.Fatalf("unsupported operator %s", op)
+	return false
+}
+
+// expr will evaluate an aggregation expression against the document
+func (r *pipelineRunner) expr(doc bson.D, e any) any {
+	r.t.Helper()
+
+	switch e := e.(type) {
+	case string:
+		if strings.HasPrefix(e, "$") {
+			value, _ := lookup(doc, strings.TrimPrefix(e, "$"))
+			return value
+		}
+		return e
+
+	case primitive.A:
+		values := make(primitive.A, len(e))
+		for i, elem := range e {
+			values[i] = r.expr(doc, elem)
+		}
+		return values
+
+	case bson.D:
+		if len(e) != 1 || !strings.HasPrefix(e[0].Key, "$") {
+			// A document of expressions
+			values := make(bson.D, len(e))
+			for i, field := range e {
+				values[i] = bson.E{Key: field.Key, Value: r.expr(doc, field.Value)}
+			}
+			return values
+		}
+
+		op := e[0].Key
+		args, _ := r.expr(doc, e[0].Value).(primitive.A)
+		switch op {
+		case "$add":
+			var sum any = int64(0)
+			for _, arg := range args {
+				sum = add(sum, arg)
+			}
+			return sum
+		case "$subtract":
+			return add(args[0], negate(args[1]))
+		case "$abs":
+			value := r.expr(doc, e[0].Value)
+			if number(value) < 0 {
+				return negate(value)
+			}
+			return value
+		case "$cond":
+			if truthy(args[0]) {
+				return args[1]
+			}
+			return args[2]
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+			return r.compareOp(op, args[0], args[1])
+		}
+
+		r.t.Fatalf("unsupported operator %s", op)
+	}
+
+	return e
+}
+
+// lookup will return the value at the dotted path in the document
+func lookup(doc bson.D, path string) (any, bool) {
+	var value any = doc
+	for _, key := range strings.Split(path, ".") {
+		d, ok := value.(bson.D)
+		if !ok {
+			return nil, false
+		}
+
+		found := false
+		for _, elem := range d {
+			if elem.Key == key {
+				value, found = elem.Value, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// setField will set a top level field of the document, adding it if it is missing
+func setField(doc bson.D, key string, value any) bson.D {
+	for i := range doc {
+		if doc[i].Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int32, int64, float64:
+		return true
+	}
+	return false
+}
+
+func isBool(v any) bool {
+	_, ok := v.(bool)
+	return ok
+}
+
+// number will return v as a float64, or zero if it isn't a number
+func number(v any) float64 {
+	switch v := v.(type) {
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// add will add two numbers, keeping whole numbers as integers
+func add(a, b any) any {
+	sum := number(a) + number(b)
+	if _, ok := a.(float64); ok {
+		return sum
+	}
+	if _, ok := b.(float64); ok {
+		return sum
+	}
+	return int64(sum)
+}
+
+func negate(v any) any {
+	return add(int64(0), -number(v))
+}
+
+// truthy will return false for false, null, missing and zero, like MongoDB
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int32, int64, float64:
+		return number(v) != 0
+	}
+	return true
+}
+
+// typeOrder is the order MongoDB compares values of different types in
+func typeOrder(v any) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int32, int64, float64:
+		return 1
+	case string:
+		return 2
+	case bson.D:
+		return 3
+	case primitive.A:
+		return 4
+	case bool:
+		return 5
+	case primitive.DateTime:
+		return 6
+	}
+	return 7
+}
+
+// compare will order two values the way MongoDB sorts them
+func compare(a, b any) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return ta - tb
+	}
+
+	switch a := a.(type) {
+	case int32, int64, float64:
+		return cmp.Compare(number(a), number(b))
+	case string:
+		return strings.Compare(a, b.(string))
+	case primitive.DateTime:
+		return cmp.Compare(a, b.(primitive.DateTime))
+	case bool:
+		if a == b.(bool) {
+			return 0
+		}
+		if a {
+			return 1
+		}
+		return -1
+	}
+
+	return 0
+}
+
+// names will return the short names of the teams
+func names(teams []TeamCleanSheets) []string {
+	var got []string
+	for _, team := range teams {
+		got = append(got, team.Team.ShortName)
+	}
+	return got
+}
+
+func TestGoalsPerMatchday(t *testing.T) {
+	m := newStatsMongoTest(t, readTestMatches(t))
+
+	tests := []struct {
+		name string
+		q    Query
+		want []MatchdayGoals
+	}{
+		{
+			// Matchday 4 is yet to be played so isn't counted
+			name: "season",
+			q:    NewQuery().Season(1564),
+			want: []MatchdayGoals{
+				{Matchday: 1, Matches: 5, Goals: 13, HomeGoals: 6, AwayGoals: 7, GoalsPerMatch: 2.6},
+				{Matchday: 2, Matches: 5, Goals: 12, HomeGoals: 8, AwayGoals: 4, GoalsPerMatch: 2.4},
+				{Matchday: 3, Matches: 5, Goals: 14, HomeGoals: 6, AwayGoals: 8, GoalsPerMatch: 2.8},
+			},
+		},
+		{
+			name: "team",
+			q:    NewQuery().Team("Liverpool"),
+			want: []MatchdayGoals{
+				{Matchday: 1, Matches: 1, Goals: 2, HomeGoals: 1, AwayGoals: 1, GoalsPerMatch: 2},
+				{Matchday: 2, Matches: 1, Goals: 4, HomeGoals: 3, AwayGoals: 1, GoalsPerMatch: 4},
+				{Matchday: 3, Matches: 1, Goals: 2, HomeGoals: 0, AwayGoals: 2, GoalsPerMatch: 2},
+			},
+		},
+		{
+			name: "no completed matches",
+			q:    NewQuery().Matchdays(4, 4),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.GoalsPerMatchday(context.Background(), tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("GoalsPerMatchday = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHomeAwayWinRates(t *testing.T) {
+	m := newStatsMongoTest(t, readTestMatches(t))
+
+	tests := []struct {
+		name string
+		q    Query
+		want WinRates
+	}{
+		{
+			name: "season",
+			q:    NewQuery(),
+			want: WinRates{Matches: 15, HomeWins: 6, AwayWins: 5, Draws: 4, HomeWinRate: 6.0 / 15, AwayWinRate: 5.0 / 15, DrawRate: 4.0 / 15},
+		},
+		{
+			name: "matchday",
+			q:    NewQuery().Matchdays(2, 2),
+			want: WinRates{Matches: 5, HomeWins: 3, AwayWins: 1, Draws: 1, HomeWinRate: 0.6, AwayWinRate: 0.2, DrawRate: 0.2},
+		},
+		{
+			// The statuses can only narrow the completed matches
+			name: "no completed matches",
+			q:    NewQuery().Status(models.Timed, models.Scheduled),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.HomeAwayWinRates(context.Background(), tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("HomeAwayWinRates = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBiggestWins(t *testing.T) {
+	m := newStatsMongoTest(t, readTestMatches(t))
+
+	tests := []struct {
+		name        string
+		q           Query
+		n           int
+		wantIDs     []int
+		wantMargins []int
+	}{
+		{
+			// Brighton's 4-1 has more goals than Manchester City's 0-3, then the 2 goal wins in kick off order
+			name:        "season",
+			q:           NewQuery(),
+			n:           5,
+			wantIDs:     []int{435956, 435945, 435948, 435949, 435954},
+			wantMargins: []int{3, 3, 2, 2, 2},
+		},
+		{
+			// The draw with Brighton has no winner
+			name:        "team",
+			q:           NewQuery().Team("Liverpool"),
+			n:           5,
+			wantIDs:     []int{435948, 435954},
+			wantMargins: []int{2, 2},
+		},
+		{
+			name:        "fewer than the matches",
+			q:           NewQuery().Matchdays(1, 1),
+			n:           1,
+			wantIDs:     []int{435945},
+			wantMargins: []int{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wins, err := m.BiggestWins(context.Background(), tt.q, tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotIDs, gotMargins []int
+			for _, win := range wins {
+				gotIDs = append(gotIDs, win.Match.Id)
+				gotMargins = append(gotMargins, win.Margin)
+			}
+
+			if !slices.Equal(gotIDs, tt.wantIDs) || !slices.Equal(gotMargins, tt.wantMargins) {
+				t.Errorf("BiggestWins = %v by %v, want %v by %v", gotIDs, gotMargins, tt.wantIDs, tt.wantMargins)
+			}
+		})
+	}
+}
+
+func TestCleanSheets(t *testing.T) {
+	m := newStatsMongoTest(t, readTestMatches(t))
+
+	teams, err := m.CleanSheets(context.Background(), NewQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The most clean sheets first, then by full name, so Manchester City comes before Tottenham Hotspur
+	wantNames := []string{"Man City", "Tottenham", "Chelsea", "Liverpool", "Man United", "Arsenal", "Aston Villa", "Brighton Hove", "Newcastle", "Wolverhampton"}
+	wantCleanSheets := []int{2, 2, 1, 1, 1, 0, 0, 0, 0, 0}
+
+	if got := names(teams); !slices.Equal(got, wantNames) {
+		t.Fatalf("CleanSheets teams = %v, want %v", got, wantNames)
+	}
+	for i, team := range teams {
+		if team.CleanSheets != wantCleanSheets[i] || team.Played != 3 {
+			t.Errorf("%s kept %d clean sheets in %d matches, want %d in 3", team.Team.ShortName, team.CleanSheets, team.Played, wantCleanSheets[i])
+		}
+	}
+}
+
+func TestRefereeCounts(t *testing.T) {
+	m := newStatsMongoTest(t, readTestMatches(t))
+
+	tests := []struct {
+		name        string
+		q           Query
+		wantNames   []string
+		wantMatches []int
+	}{
+		{
+			// Every referee has three matches, so they are in name order
+			name:        "season",
+			q:           NewQuery(),
+			wantNames:   []string{"Anthony Taylor", "Michael Oliver", "Paul Tierney", "Simon Hooper", "Stuart Attwell"},
+			wantMatches: []int{3, 3, 3, 3, 3},
+		},
+		{
+			name:        "team",
+			q:           NewQuery().Team("Liverpool"),
+			wantNames:   []string{"Anthony Taylor", "Michael Oliver"},
+			wantMatches: []int{2, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			referees, err := m.RefereeCounts(context.Background(), tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotNames []string
+			var gotMatches []int
+			for _, referee := range referees {
+				gotNames = append(gotNames, referee.Referee.Name)
+				gotMatches = append(gotMatches, referee.Matches)
+			}
+
+			if !slices.Equal(gotNames, tt.wantNames) || !slices.Equal(gotMatches, tt.wantMatches) {
+				t.Errorf("RefereeCounts = %v with %v, want %v with %v", gotNames, gotMatches, tt.wantNames, tt.wantMatches)
+			}
+		})
+	}
+}
+
+func TestHeadToHead(t *testing.T) {
+	m := newStatsMongoTest(t, readTestMatches(t))
+
+	tests := []struct {
+		team, opponent string
+		want           HeadToHead
+	}{
+		// Liverpool beat Arsenal 3-1 at home
+		{"Liverpool", "Arsenal", HeadToHead{Played: 1, Won: 1, GoalsFor: 3, GoalsAgainst: 1}},
+		// The same match from Arsenal's side, as the away team
+		{"Arsenal", "Liverpool", HeadToHead{Played: 1, Lost: 1, GoalsFor: 1, GoalsAgainst: 3}},
+		{"Tottenham", "Chelsea", HeadToHead{Played: 1, Drawn: 1, GoalsFor: 2, GoalsAgainst: 2}},
+		// Their match hasn't been played yet
+		{"Liverpool", "Man United", HeadToHead{}},
+	}
+
+	for _, tt := range tests {
+		got, err := m.HeadToHead(context.Background(), NewQuery(), tt.team, tt.opponent)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tt.want.Team, tt.want.Opponent = tt.team, tt.opponent
+		if got != tt.want {
+			t.Errorf("HeadToHead(%s, %s) = %+v, want %+v", tt.team, tt.opponent, got, tt.want)
+		}
+	}
+}
+
+func TestStatsValidation(t *testing.T) {
+	// The arguments are checked before the collection is used, so an unconnected client will do
+	var m MongoTest
+	ctx := context.Background()
+
+	for _, n := range []int{0, -1} {
+		if _, err := m.BiggestWins(ctx, NewQuery(), n); err == nil || !strings.Contains(err.Error(), "n must be greater than zero") {
+			t.Errorf("BiggestWins with n = %d error = %v", n, err)
+		}
+	}
+
+	// The stages decide the order and number of the results, so queries can't change them
+	for name, q := range map[string]Query{
+		"order":  NewQuery().OrderBy(ByKickoff, true),
+		"skip":   NewQuery().Skip(5),
+		"limit":  NewQuery().Limit(5),
+		"fields": NewQuery().Fields("score"),
+	} {
+		if _, err := m.GoalsPerMatchday(ctx, q); !errors.Is(err, ErrStatsQuery) {
+			t.Errorf("GoalsPerMatchday with the query's %s error = %v, want %v", name, err, ErrStatsQuery)
+		}
+	}
+	if _, err := m.BiggestWins(ctx, NewQuery().OrderBy(ByKickoff, false), 5); !errors.Is(err, ErrStatsQuery) {
+		t.Errorf("BiggestWins with an order error = %v, want %v", err, ErrStatsQuery)
+	}
+}
+
+func TestRate(t *testing.T) {
+	if got := rate(1, 4); got != 0.25 {
+		t.Errorf("rate(1, 4) = %v, want 0.25", got)
+	}
+	if got := rate(3, 0); got != 0 {
+		t.Errorf("rate(3, 0) = %v, want 0", got)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mongodb-test"
+	"mongodb-test/models"
+)
+
+const (
+	// defaultPageSize is the number of matches returned per page if page_size is not given
+	defaultPageSize = 50
+	// maxPageSize is the largest page_size that can be requested
+	maxPageSize = 200
+	// defaultNextFixtures is the number of fixtures returned by /api/teams/{team}/next if n is not given
+	defaultNextFixtures = 5
+)
+
+// errorResponse is the body returned with every error status
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// matchPage is a page of matches returned by /api/matches
+type matchPage struct {
+	Matches    []models.Match `json:"matches"`
+	Page       int            `json:"page"`
+	PageSize   int            `json:"pageSize"`
+	Total      int            `json:"total"`
+	TotalPages int            `json:"totalPages"`
+}
+
+// teamList is the body returned by /api/teams
+type teamList struct {
+	Teams []models.Team `json:"teams"`
+}
+
+// writeJSON will write v to the response as JSON with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("Error encoding response:", err)
+	}
+}
+
+// writeError will write an error body with the given status
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, errorResponse{Error: fmt.Sprintf(format, args...)})
+}
+
+// matchesETag will return a weak ETag identifying the versions of the matches
+func matchesETag(matches []models.Match) string {
+	return pageETag(matches, len(matches))
+}
+
+// pageETag will return a weak ETag identifying the versions of the matches on a page and the total they are a page of
+func pageETag(matches []models.Match, total int) string {
+	h := fnv.New64a()
+
+	fmt.Fprintf(h, "%d;", total)
+	for _, match := range matches {
+		fmt.Fprintf(h, "%d:%d;", match.Id, match.LastUpdated.UnixNano())
+	}
+
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// lastModified will return the most recent LastUpdated of the matches
+func lastModified(matches []models.Match) time.Time {
+	var latest time.Time
+
+	for _, match := range matches {
+		if match.LastUpdated.After(latest) {
+			latest = match.LastUpdated
+		}
+	}
+
+	return latest
+}
+
+// etagMatches will return true if the If-None-Match header lists the ETag, using the weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified will set the ETag and Last-Modified headers for a response built from the matches
+// If the client's copy is still current it writes 304 Not Modified and returns true
+func notModified(w http.ResponseWriter, req *http.Request, matches ...models.Match) bool {
+	return notModifiedSince(w, req, matchesETag(matches), lastModified(matches))
+}
+
+// notModifiedSince will set the ETag and Last-Modified headers, leaving out Last-Modified if modified is zero
+// If the client's copy is still current it writes 304 Not Modified and returns true
+func notModifiedSince(w http.ResponseWriter, req *http.Request, etag string, modified time.Time) bool {
+	modified = modified.Truncate(time.Second)
+
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if !etagMatches(ifNoneMatch, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+		if err != nil || modified.IsZero() || modified.After(since) {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// matchesHandler handles GET /api/matches?team=&from=&to=&status=&matchday=&page=&page_size=
+func (s *server) matchesHandler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	// Parse the filter from the query string
+	filter, err := parseMatchFilter(query.Get("team"), query.Get("from"), query.Get("to"), query.Get("status"), query.Get("matchday"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// Parse the page from the query string
+	page, err := parsePositiveInt(query.Get("page"), 1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid page: %v", err)
+		return
+	}
+
+	pageSize, err := parsePositiveInt(query.Get("page_size"), defaultPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid page_size: %v", err)
+		return
+	}
+	pageSize = min(pageSize, maxPageSize)
+
+	// Count every match selected so the number of pages is known
+	q := filter.Query()
+	total, err := s.store.CountMatches(req.Context(), q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting matches")
+		return
+	}
+
+	// Only get the matches on the requested page, which is empty past the last page
+	skip := total
+	if page-1 <= total/pageSize {
+		skip = (page - 1) * pageSize
+	}
+	matches, err := collectMatches(req.Context(), s.store, q.Skip(skip).Limit(pageSize))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting matches")
+		return
+	}
+
+	// A match changing on another page can change the total without changing this page's last update, so only the ETag is used
+	if notModifiedSince(w, req, pageETag(matches, total), time.Time{}) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, matchPage{
+		Matches:    append([]models.Match{}, matches...),
+		Page:       page,
+		PageSize:   pageSize,
+		Total:      total,
+		TotalPages: (total + pageSize - 1) / pageSize,
+	})
+}
+
+// matchHandler handles GET /api/matches/{id}
+func (s *server) matchHandler(w http.ResponseWriter, req *http.Request) {
+	// Parse the id from the path
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid match id: %q", req.PathValue("id"))
+		return
+	}
+
+	// Get the match
+	match, err := s.store.GetMatch(req.Context(), id)
+	if errors.Is(err, mongodb_test.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "match %d not found", id)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting match")
+		return
+	}
+
+	if notModified(w, req, match) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, match)
+}
+
+// teamsHandler handles GET /api/teams
+func (s *server) teamsHandler(w http.ResponseWriter, req *http.Request) {
+	// Get the teams
+	teams, err := s.store.GetTeams(req.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting teams")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, teamList{Teams: teams})
+}
+
+// todayHandler handles GET /api/today, counting the day in the time zone named by tz if given
+func (s *server) todayHandler(w http.ResponseWriter, req *http.Request) {
+	loc, err := parseLocation(req.URL.Query().Get("tz"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// Get today's matches
+	matches, err := s.store.GetTodaysMatches(req.Context(), loc)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting today's matches")
+		return
+	}
+
+	writeMatchList(w, req, matches)
+}
+
+// weekHandler handles GET /api/week, counting the week in the time zone named by tz if given
+func (s *server) weekHandler(w http.ResponseWriter, req *http.Request) {
+	loc, err := parseLocation(req.URL.Query().Get("tz"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// Get this week's matches
+	matches, err := s.store.GetThisWeeksMatches(req.Context(), loc)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting this week's matches")
+		return
+	}
+
+	writeMatchList(w, req, matches)
+}
+
+// matchdayHandler handles GET /api/matchday, counting days in the time zone named by tz if given
+func (s *server) matchdayHandler(w http.ResponseWriter, req *http.Request) {
+	loc, err := parseLocation(req.URL.Query().Get("tz"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// Get this matchday's matches
+	matches, err := s.store.GetThisMatchday(req.Context(), loc)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting this matchday's matches")
+		return
+	}
+
+	writeMatchList(w, req, matches)
+}
+
+// nextFixturesHandler handles GET /api/teams/{team}/next, returning the team's next n matches
+func (s *server) nextFixturesHandler(w http.ResponseWriter, req *http.Request) {
+	n, err := parsePositiveInt(req.URL.Query().Get("n"), defaultNextFixtures)
+	if err != nil || n > maxPageSize {
+		writeError(w, http.StatusBadRequest, "invalid n: must be between 1 and %d", maxPageSize)
+		return
+	}
+
+	// Get the team's next matches
+	matches, err := s.store.GetNextFixtures(req.Context(), req.PathValue("team"), n)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting fixtures")
+		return
+	}
+
+	writeMatchList(w, req, matches)
+}
+
+// writeMatchList will write the matches, or that they haven't changed since the client last got them
+func writeMatchList(w http.ResponseWriter, req *http.Request, matches models.MatchList) {
+	if notModified(w, req, matches.Matches...) {
+		return
+	}
+
+	// Return an empty list rather than null if there are no matches
+	if matches.Matches == nil {
+		matches.Matches = []models.Match{}
+	}
+
+	writeJSON(w, http.StatusOK, matches)
+}
+
+// parseLocation will load the IANA time zone with the name, such as Europe/London
+// An empty name returns nil, so the store's time zone is used
+func parseLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tz: %q", name)
+	}
+
+	return loc, nil
+}
+
+// parseMatchFilter will create a filter from the query string values, returning an error for invalid values
+func parseMatchFilter(team, from, to, status, matchday string) (mongodb_test.MatchFilter, error) {
+	filter := mongodb_test.MatchFilter{Team: team}
+
+	var err error
+
+	if from != "" {
+		filter.From, _, err = parseDate(from)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from date: %q", from)
+		}
+	}
+
+	if to != "" {
+		var dateOnly bool
+		filter.To, dateOnly, err = parseDate(to)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to date: %q", to)
+		}
+
+		// A date on its own includes the whole of that day
+		if dateOnly {
+			filter.To = filter.To.AddDate(0, 0, 1)
+		}
+	}
+
+	if status != "" {
+		for _, s := range strings.Split(status, ",") {
+			matchStatus := models.MatchStatus(strings.ToUpper(strings.TrimSpace(s)))
+			if !validStatus(matchStatus) {
+				return filter, fmt.Errorf("invalid status: %q", s)
+			}
+			filter.Status = append(filter.Status, matchStatus)
+		}
+	}
+
+	if matchday != "" {
+		filter.Matchday, err = parsePositiveInt(matchday, 0)
+		if err != nil {
+			return filter, fmt.Errorf("invalid matchday: %q", matchday)
+		}
+	}
+
+	return filter, nil
+}
+
+// parseDate will parse either a YYYY-MM-DD date or an RFC 3339 time, reporting which it was
+func parseDate(s string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	return t, false, err
+}
+
+// parsePositiveInt will parse s as an integer greater than zero, returning def if s is empty
+func parsePositiveInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 1 {
+		return 0, errors.New("must be at least 1")
+	}
+
+	return n, nil
+}
+
+// validStatus will return true if s is one of the known match statuses
+func validStatus(s models.MatchStatus) bool {
+	switch s {
+	case models.Scheduled, models.Timed, models.InPlay, models.Paused, models.Finished,
+		models.Suspended, models.Postponed, models.Cancelled, models.Awarded:
+		return true
+	default:
+		return false
+	}
+}
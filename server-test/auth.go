@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// sessionCookieName is the name of the cookie holding the signed session
+	sessionCookieName = "session"
+	// sessionTTL is how long a session lasts after logging in
+	sessionTTL = 24 * time.Hour
+)
+
+// dummyHash is compared against when a username doesn't exist so that unknown users take as long to reject as known ones
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// User is a user that can log in to the server
+type User struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"password_hash"`
+	Roles        []string `json:"roles"`
+}
+
+// HasRole will return true if the user has been given the role
+func (u *User) HasRole(role string) bool {
+	return slices.Contains(u.Roles, role)
+}
+
+// userFromContext will return the authenticated user stored in the context, or nil if the request is anonymous
+func userFromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(user).(*User)
+	return u
+}
+
+// usernameFromContext will return the name of the authenticated user, or anonymous
+func usernameFromContext(ctx context.Context) string {
+	if u := userFromContext(ctx); u != nil {
+		return u.Username
+	}
+
+	return "anonymous"
+}
+
+// authenticator checks credentials against a set of users and manages signed session cookies
+type authenticator struct {
+	// users are the known users by username
+	users map[string]*User
+	// sessionKey is the key used to sign session cookies
+	sessionKey []byte
+}
+
+// newAuthenticator will create an authenticator for the users
+// If sessionKey is empty a random key is used, so sessions won't survive a restart
+func newAuthenticator(users []User, sessionKey []byte) *authenticator {
+	if len(sessionKey) == 0 {
+		sessionKey = make([]byte, 32)
+		rand.Read(sessionKey)
+	}
+
+	a := &authenticator{
+		users:      make(map[string]*User),
+		sessionKey: sessionKey,
+	}
+
+	for _, u := range users {
+		a.users[u.Username] = &u
+	}
+
+	return a
+}
+
+// loadUsers will read the users from a JSON file containing a list of users with bcrypt password hashes
+func loadUsers(path string) ([]User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	err = json.Unmarshal(data, &users)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the file doesn't contain anything that would never match
+	for _, u := range users {
+		if u.Username == "" {
+			return nil, errors.New("user with no username")
+		}
+
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return nil, errors.New("invalid password hash for user " + u.Username)
+		}
+	}
+
+	return users, nil
+}
+
+// checkPassword will return the user if the password is correct
+func (a *authenticator) checkPassword(username, password string) (*User, bool) {
+	u, ok := a.users[username]
+	if !ok {
+		// Do the same work as for a known user
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, false
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return nil, false
+	}
+
+	return u, true
+}
+
+// sign will return the HMAC of the payload keyed by the session key
+func (a *authenticator) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
+
+// newSessionCookie will create a signed session cookie for the user
+func (a *authenticator) newSessionCookie(u *User, secure bool) *http.Cookie {
+	expires := time.Now().Add(sessionTTL)
+
+	// The payload is the username and expiry, followed by its signature
+	payload := u.Username + "|" + strconv.FormatInt(expires.Unix(), 10)
+	value := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(a.sign(payload))
+
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// sessionUser will return the user from a valid session cookie
+func (a *authenticator) sessionUser(req *http.Request) (*User, bool) {
+	cookie, err := req.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, false
+	}
+
+	// Split the payload from the signature
+	encodedPayload, encodedSignature, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return nil, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, false
+	}
+
+	// Check the signature before trusting anything in the payload
+	if !hmac.Equal(signature, a.sign(string(payload))) {
+		return nil, false
+	}
+
+	// Split on the last separator as the expiry never contains one
+	i := strings.LastIndex(string(payload), "|")
+	if i < 0 {
+		return nil, false
+	}
+	username, expiry := string(payload[:i]), string(payload[i+1:])
+
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, false
+	}
+
+	// Look the user up so that removed users lose access and role changes apply straight away
+	u, ok := a.users[username]
+	return u, ok
+}
+
+// authenticate will return the user for the request from a session cookie or basic auth
+func (a *authenticator) authenticate(req *http.Request) (*User, bool) {
+	if u, ok := a.sessionUser(req); ok {
+		return u, true
+	}
+
+	if username, password, ok := req.BasicAuth(); ok {
+		return a.checkPassword(username, password)
+	}
+
+	return nil, false
+}
+
+// handler will add the authenticated user to the request context
+// Requests without valid credentials carry on anonymously, requireRole decides which routes need a user
+func (a *authenticator) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if u, ok := a.authenticate(req); ok {
+			req = req.WithContext(context.WithValue(req.Context(), user, u))
+		}
+
+		// Call the next handler
+		next.ServeHTTP(w, req)
+	})
+}
+
+// loginHandler handles POST /login with username and password form values, setting a session cookie
+func (a *authenticator) loginHandler(w http.ResponseWriter, req *http.Request) {
+	// Read the form, which fails if the body is over the server's limit
+	if err := req.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+
+		writeError(w, http.StatusBadRequest, "invalid form")
+		return
+	}
+
+	u, ok := a.checkPassword(req.PostFormValue("username"), req.PostFormValue("password"))
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid username or password")
+		return
+	}
+
+	http.SetCookie(w, a.newSessionCookie(u, req.TLS != nil))
+
+	writeJSON(w, http.StatusOK, struct {
+		Username string   `json:"username"`
+		Roles    []string `json:"roles"`
+	}{u.Username, u.Roles})
+}
+
+// logoutHandler handles POST /logout, clearing the session cookie
+func (a *authenticator) logoutHandler(w http.ResponseWriter, req *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireRole will only let through requests from users with the role
+// Anonymous requests get 401 with a basic auth challenge, users without the role get 403
+func requireRole(role string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			u := userFromContext(req.Context())
+
+			if u == nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="server-test", charset="UTF-8"`)
+				writeError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+
+			if !u.HasRole(role) {
+				writeError(w, http.StatusForbidden, "role %q required", role)
+				return
+			}
+
+			// Call the next handler
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// hashPassword will return the bcrypt hash of a password for the users file
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionRequest will create a request carrying the session cookie value
+func sessionRequest(value string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: value})
+	return req
+}
+
+// signedValue will create a session cookie value for the payload, signed by the authenticator
+func signedValue(a *authenticator, payload string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(a.sign(payload))
+}
+
+func TestSessionCookie(t *testing.T) {
+	users := []User{
+		{Username: "fan"},
+		{Username: "admin", Roles: []string{"admin"}},
+		{Username: "a|b"},
+	}
+	a := newAuthenticator(users, []byte("test key"))
+
+	valid := a.newSessionCookie(a.users["fan"], false).Value
+	payload, signature, _ := strings.Cut(valid, ".")
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name  string
+		value string
+		// want is the username the cookie is for, empty if it should be rejected
+		want string
+	}{
+		{"valid", valid, "fan"},
+		{"username with a separator", a.newSessionCookie(a.users["a|b"], false).Value, "a|b"},
+		{
+			name:  "tampered payload",
+			value: base64.RawURLEncoding.EncodeToString([]byte("admin|"+future)) + "." + signature,
+		},
+		{
+			name:  "tampered signature",
+			value: payload + "." + base64.RawURLEncoding.EncodeToString(a.sign("admin|"+future)),
+		},
+		{
+			name:  "signature truncated",
+			value: valid[:len(valid)-2],
+		},
+		{
+			name:  "signed with another key",
+			value: newAuthenticator(users, []byte("other key")).newSessionCookie(a.users["fan"], false).Value,
+		},
+		{name: "expired", value: signedValue(a, "fan|"+past)},
+		{name: "no expiry", value: signedValue(a, "fan")},
+		{name: "invalid expiry", value: signedValue(a, "fan|soon")},
+		// A separator in the username can't be used to move the expiry
+		{name: "expiry hidden in the username", value: signedValue(a, "fan|"+future+"|"+past)},
+		{name: "unknown user", value: signedValue(a, "nobody|"+future)},
+		{name: "no signature", value: payload},
+		{name: "invalid base64", value: "!!!." + signature},
+		{name: "empty", value: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, ok := a.sessionUser(sessionRequest(tt.value))
+
+			if tt.want == "" {
+				if ok || u != nil {
+					t.Errorf("sessionUser accepted %q as %v, want rejected", tt.value, u)
+				}
+				return
+			}
+
+			if !ok || u == nil || u.Username != tt.want {
+				t.Errorf("sessionUser(%q) = %v, %v, want %s", tt.value, u, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionCookieAttributes(t *testing.T) {
+	a := newAuthenticator([]User{{Username: "fan"}}, []byte("test key"))
+
+	before := time.Now()
+	cookie := a.newSessionCookie(a.users["fan"], true)
+
+	if cookie.Name != sessionCookieName || cookie.Path != "/" || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie = %+v, want an HttpOnly, Secure, SameSite=Lax session cookie for /", cookie)
+	}
+
+	if expires := cookie.Expires.Sub(before); expires < sessionTTL-time.Second || expires > sessionTTL+time.Second {
+		t.Errorf("cookie expires in %v, want %v", expires, sessionTTL)
+	}
+
+	if a.newSessionCookie(a.users["fan"], false).Secure {
+		t.Error("cookie for a plain HTTP request is Secure")
+	}
+}
+
+func TestSessionUserChanges(t *testing.T) {
+	a := newAuthenticator([]User{{Username: "fan"}}, []byte("test key"))
+	value := a.newSessionCookie(a.users["fan"], false).Value
+
+	// Role changes apply to existing sessions
+	a.users["fan"].Roles = []string{"admin"}
+	if u, ok := a.sessionUser(sessionRequest(value)); !ok || !u.HasRole("admin") {
+		t.Errorf("sessionUser = %v, %v, want fan with the new role", u, ok)
+	}
+
+	// Deleted users lose access straight away
+	delete(a.users, "fan")
+	if u, ok := a.sessionUser(sessionRequest(value)); ok {
+		t.Errorf("sessionUser = %v, want a deleted user rejected", u)
+	}
+}
+
+func TestRandomSessionKey(t *testing.T) {
+	users := []User{{Username: "fan"}}
+
+	a := newAuthenticator(users, nil)
+	if len(a.sessionKey) == 0 {
+		t.Fatal("no session key generated")
+	}
+
+	// Sessions signed before a restart aren't valid after it
+	value := a.newSessionCookie(a.users["fan"], false).Value
+	if _, ok := a.sessionUser(sessionRequest(value)); !ok {
+		t.Error("sessionUser rejected its own cookie")
+	}
+	if _, ok := newAuthenticator(users, nil).sessionUser(sessionRequest(value)); ok {
+		t.Error("two random session keys accepted the same cookie")
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"mongodb-test"
+	"mongodb-test/models"
+)
+
+const (
+	// liveTTL is how long results holding matches that are in play or kick off soon are cached
+	liveTTL = 15 * time.Second
+	// defaultTTL is how long results holding matches further in the future are cached
+	defaultTTL = 5 * time.Minute
+	// finishedTTL is how long results that no match can join, holding only completed matches, are cached
+	finishedTTL = time.Hour
+	// liveWindow is how close to kick off a match has to be for its results to use liveTTL
+	liveWindow = 24 * time.Hour
+	// maxCacheEntries is the number of entries above which expired entries are swept out
+	maxCacheEntries = 1000
+)
+
+// cacheEntry is a cached result and when it expires
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+// cachingStore wraps a MatchStore, caching each result for a time that depends on how fresh its matches need to be
+// Errors are never cached
+type cachingStore struct {
+	// store is where results are read from on a miss
+	store MatchStore
+	// now returns the current time, it can be replaced to test expiry
+	now func() time.Time
+	// location is the time zone the store counts days and weeks in when no other is given
+	location *time.Location
+
+	// mu guards entries
+	mu sync.Mutex
+	// entries are the cached results by method and arguments
+	entries map[string]cacheEntry
+}
+
+// newCachingStore will create a cache in front of the store
+func newCachingStore(store MatchStore) *cachingStore {
+	return &cachingStore{
+		store:    store,
+		now:      time.Now,
+		location: time.Local,
+		entries:  make(map[string]cacheEntry),
+	}
+}
+
+// ttlFor will return how long a result holding exactly the matches can be cached
+// It is only used for results no other match can join, such as a lookup by id, as it gives completed matches finishedTTL
+func (c *cachingStore) ttlFor(matches ...models.Match) time.Duration {
+	now := c.now()
+	ttl := finishedTTL
+
+	for _, match := range matches {
+		switch match.Status {
+		case models.Finished, models.Awarded, models.Cancelled:
+			// The result won't change
+		case models.InPlay, models.Paused:
+			return liveTTL
+		default:
+			if match.UtcDate.Sub(now) < liveWindow {
+				return liveTTL
+			}
+			ttl = defaultTTL
+		}
+	}
+
+	return ttl
+}
+
+// get will return the cached result for the key if it hasn't expired
+func (c *cachingStore) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+// set will cache the result for the key
+func (c *cachingStore) set(key string, value any, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	// Sweep out expired entries so the cache doesn't grow with every distinct query
+	if len(c.entries) >= maxCacheEntries {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(ttl)}
+}
+
+// cached will return the cached result for the key, or call load and cache its result
+func cached[T any](c *cachingStore, key string, load func() (T, error), ttl func(T) time.Duration) (T, error) {
+	if value, ok := c.get(key); ok {
+		return value.(T), nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+
+	c.set(key, value, ttl(value))
+
+	return value, nil
+}
+
+// listTTL will return how long a result that new or changed matches could join can be cached
+// Empty results use liveTTL so that new matches appear quickly, and other results never use more than defaultTTL
+func (c *cachingStore) listTTL(matches ...models.Match) time.Duration {
+	if len(matches) == 0 {
+		return liveTTL
+	}
+
+	return min(c.ttlFor(matches...), defaultTTL)
+}
+
+// seasonOver will return true if the matches are all from the season with the id, and the season has a winner
+// A finished season's results can't gain matches, so it can be cached like a lookup by id
+func seasonOver(season int, matches []models.Match) bool {
+	if season == 0 || len(matches) == 0 {
+		return false
+	}
+
+	for _, match := range matches {
+		if match.Season.Id != season || match.Season.Winner.Id == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchTTL will return the TTL for a match looked up by id
+func (c *cachingStore) matchTTL(match models.Match) time.Duration {
+	return c.ttlFor(match)
+}
+
+// matchListTTL will return the TTL for a list of matches
+func (c *cachingStore) matchListTTL(matchList models.MatchList) time.Duration {
+	return c.listTTL(matchList.Matches...)
+}
+
+// keyTime will format a time for a cache key
+// Equal instants give the same key whatever their location or monotonic clock reading
+func keyTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// filterKey will return the cache key of a filter, equal filters give equal keys
+func filterKey(filter mongodb_test.MatchFilter) string {
+	return fmt.Sprintf("team=%q from=%s to=%s status=%v matchday=%d",
+		filter.Team, keyTime(filter.From), keyTime(filter.To), filter.Status, filter.Matchday)
+}
+
+// Ping will check the wrapped store can be reached, if it can be unreachable
+func (c *cachingStore) Ping(ctx context.Context) error {
+	if p, ok := c.store.(pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+// GetOneMatch will return the match between the home and away team
+func (c *cachingStore) GetOneMatch(ctx context.Context, homeTeam, awayTeam string) (models.Match, error) {
+	// The teams meet again each season, so the match found can change
+	return cached(c, fmt.Sprintf("GetOneMatch %q %q", homeTeam, awayTeam), func() (models.Match, error) {
+		return c.store.GetOneMatch(ctx, homeTeam, awayTeam)
+	}, func(match models.Match) time.Duration {
+		return c.listTTL(match)
+	})
+}
+
+// GetAllTeamMatches will return every match the team plays in
+func (c *cachingStore) GetAllTeamMatches(ctx context.Context, team string) (models.MatchList, error) {
+	return cached(c, fmt.Sprintf("GetAllTeamMatches %q", team), func() (models.MatchList, error) {
+		return c.store.GetAllTeamMatches(ctx, team)
+	}, c.matchListTTL)
+}
+
+// locationOr will return loc, or the store's time zone if loc is nil
+// Keys use the resolved zone, so nil shares entries with requests naming the store's zone rather than formatting as UTC
+func (c *cachingStore) locationOr(loc *time.Location) *time.Location {
+	if loc == nil {
+		return c.location
+	}
+
+	return loc
+}
+
+// liveListTTL will return liveTTL, for results that depend on the current time and so change as it passes
+func liveListTTL(models.MatchList) time.Duration {
+	return liveTTL
+}
+
+// GetTodaysMatches will return the matches kicking off today
+// They are always cached for liveTTL, as the day they belong to changes at midnight
+func (c *cachingStore) GetTodaysMatches(ctx context.Context, loc *time.Location) (models.MatchList, error) {
+	loc = c.locationOr(loc)
+
+	return cached(c, fmt.Sprintf("GetTodaysMatches %s", loc), func() (models.MatchList, error) {
+		return c.store.GetTodaysMatches(ctx, loc)
+	}, liveListTTL)
+}
+
+// GetThisWeeksMatches will return the matches kicking off this week
+func (c *cachingStore) GetThisWeeksMatches(ctx context.Context, loc *time.Location) (models.MatchList, error) {
+	loc = c.locationOr(loc)
+
+	return cached(c, fmt.Sprintf("GetThisWeeksMatches %s", loc), func() (models.MatchList, error) {
+		return c.store.GetThisWeeksMatches(ctx, loc)
+	}, liveListTTL)
+}
+
+// GetThisMatchday will return the matches of the current matchday
+func (c *cachingStore) GetThisMatchday(ctx context.Context, loc *time.Location) (models.MatchList, error) {
+	loc = c.locationOr(loc)
+
+	return cached(c, fmt.Sprintf("GetThisMatchday %s", loc), func() (models.MatchList, error) {
+		return c.store.GetThisMatchday(ctx, loc)
+	}, liveListTTL)
+}
+
+// GetNextFixtures will return the team's next n matches
+func (c *cachingStore) GetNextFixtures(ctx context.Context, team string, n int) (models.MatchList, error) {
+	return cached(c, fmt.Sprintf("GetNextFixtures %q %d", team, n), func() (models.MatchList, error) {
+		return c.store.GetNextFixtures(ctx, team, n)
+	}, liveListTTL)
+}
+
+// GetMatch will return the match with the given id
+func (c *cachingStore) GetMatch(ctx context.Context, id int) (models.Match, error) {
+	return cached(c, fmt.Sprintf("GetMatch %d", id), func() (models.Match, error) {
+		return c.store.GetMatch(ctx, id)
+	}, c.matchTTL)
+}
+
+// GetMatches will return the matches selected by the filter in kick off order
+func (c *cachingStore) GetMatches(ctx context.Context, filter mongodb_test.MatchFilter) (models.MatchList, error) {
+	return cached(c, "GetMatches "+filterKey(filter), func() (models.MatchList, error) {
+		return c.store.GetMatches(ctx, filter)
+	}, c.matchListTTL)
+}
+
+// FindMatches will call fn with each match selected by the query, in the query's order
+// The selected matches are cached as a whole, so they are collected before fn is called
+func (c *cachingStore) FindMatches(ctx context.Context, q mongodb_test.Query, fn func(models.Match) error) error {
+	matches, err := cached(c, "FindMatches "+q.String(), func() ([]models.Match, error) {
+		return collectMatches(ctx, c.store, q)
+	}, func(matches []models.Match) time.Duration {
+		if seasonOver(q.SeasonID(), matches) {
+			return c.ttlFor(matches...)
+		}
+		return c.listTTL(matches...)
+	})
+
+	// Check for errors
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		if err := fn(match); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CountMatches will return the number of matches selected by the query, ignoring its skip and limit
+// New matches can join any query, so counts are cached for defaultTTL, or liveTTL while there are none
+func (c *cachingStore) CountMatches(ctx context.Context, q mongodb_test.Query) (int, error) {
+	return cached(c, "CountMatches "+q.Skip(0).Limit(0).String(), func() (int, error) {
+		return c.store.CountMatches(ctx, q)
+	}, func(n int) time.Duration {
+		if n == 0 {
+			return liveTTL
+		}
+		return defaultTTL
+	})
+}
+
+// GetTeams will return every team
+func (c *cachingStore) GetTeams(ctx context.Context) ([]models.Team, error) {
+	return cached(c, "GetTeams", func() ([]models.Team, error) {
+		return c.store.GetTeams(ctx)
+	}, func(teams []models.Team) time.Duration {
+		if len(teams) == 0 {
+			return liveTTL
+		}
+		return defaultTTL
+	})
+}
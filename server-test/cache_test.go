@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"mongodb-test"
+	"mongodb-test/models"
+)
+
+// countingStore counts the reads that reach the store it wraps
+type countingStore struct {
+	MatchStore
+	calls map[string]int
+}
+
+// newCountingStore will wrap the store, counting its reads
+func newCountingStore(store MatchStore) *countingStore {
+	return &countingStore{MatchStore: store, calls: make(map[string]int)}
+}
+
+func (s *countingStore) GetMatches(ctx context.Context, filter mongodb_test.MatchFilter) (models.MatchList, error) {
+	s.calls["GetMatches"]++
+	return s.MatchStore.GetMatches(ctx, filter)
+}
+
+func (s *countingStore) FindMatches(ctx context.Context, q mongodb_test.Query, fn func(models.Match) error) error {
+	s.calls["FindMatches"]++
+	return s.MatchStore.FindMatches(ctx, q, fn)
+}
+
+func (s *countingStore) CountMatches(ctx context.Context, q mongodb_test.Query) (int, error) {
+	s.calls["CountMatches"]++
+	return s.MatchStore.CountMatches(ctx, q)
+}
+
+func (s *countingStore) GetTodaysMatches(ctx context.Context, loc *time.Location) (models.MatchList, error) {
+	s.calls["GetTodaysMatches"]++
+	return s.MatchStore.GetTodaysMatches(ctx, loc)
+}
+
+func TestCachingStoreGetMatchesKey(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	counting := newCountingStore(newTestStore(t))
+	cache := newCachingStore(counting)
+	cache.now = func() time.Time { return testNow }
+
+	from := time.Date(2023, 8, 12, 0, 0, 0, 0, time.UTC)
+	now := time.Now()
+
+	// The same instants in other locations, and read from a clock with a monotonic reading, are the same filter
+	filters := []mongodb_test.MatchFilter{
+		{Team: "Liverpool", From: from, To: from.AddDate(0, 0, 7)},
+		{Team: "Liverpool", From: from.In(london), To: from.AddDate(0, 0, 7).In(london)},
+		{Team: "Liverpool", From: now.Add(from.Sub(now)), To: from.AddDate(0, 0, 7)},
+	}
+
+	for _, filter := range filters {
+		if _, err := cache.GetMatches(context.Background(), filter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := counting.calls["GetMatches"]; got != 1 {
+		t.Errorf("store read %d times for equal filters, want 1", got)
+	}
+
+	// A different filter is a different entry
+	if _, err := cache.GetMatches(context.Background(), mongodb_test.MatchFilter{Team: "Liverpool", From: from}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := counting.calls["GetMatches"]; got != 2 {
+		t.Errorf("store read %d times after a different filter, want 2", got)
+	}
+
+	if got := len(cache.entries); got != 2 {
+		t.Errorf("cache holds %d entries, want 2", got)
+	}
+}
+
+func TestCachingStoreFindMatchesKey(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	counting := newCountingStore(newTestStore(t))
+	cache := newCachingStore(counting)
+	cache.now = func() time.Time { return testNow }
+
+	from := time.Date(2023, 8, 12, 0, 0, 0, 0, time.UTC)
+	q := mongodb_test.NewQuery().Team("Liverpool").OrderBy(mongodb_test.ByKickoff, false)
+
+	find := func(q mongodb_test.Query) int {
+		n := 0
+		if err := cache.FindMatches(context.Background(), q, func(models.Match) error {
+			n++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// The same range in another location reads the store once and finds the same matches
+	first := find(q.Between(from, from.AddDate(0, 1, 0)))
+	second := find(q.Between(from.In(tokyo), from.AddDate(0, 1, 0).In(tokyo)))
+
+	if first == 0 || first != second {
+		t.Errorf("found %d then %d matches", first, second)
+	}
+
+	if got := counting.calls["FindMatches"]; got != 1 {
+		t.Errorf("store read %d times for equal queries, want 1", got)
+	}
+	if got := len(cache.entries); got != 1 {
+		t.Errorf("cache holds %d entries, want 1", got)
+	}
+}
+
+func TestCachingStoreLocationKey(t *testing.T) {
+	tokyo := loadLocation(t, "Asia/Tokyo")
+
+	// It is Monday in Tokyo but still Sunday in UTC
+	store := newTestStore(t)
+	store.location = tokyo
+	store.now = func() time.Time { return time.Date(2023, 8, 13, 15, 0, 0, 0, time.UTC) }
+
+	counting := newCountingStore(store)
+	cache := newCachingStore(counting)
+	cache.location = tokyo
+	cache.now = store.now
+
+	today := func(loc *time.Location) []int {
+		matchList, err := cache.GetTodaysMatches(context.Background(), loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return matchIDs(matchList)
+	}
+
+	// The store's zone isn't mistaken for UTC
+	if got, want := today(nil), []int{435947}; !slices.Equal(got, want) {
+		t.Errorf("today in the store's zone = %v, want %v", got, want)
+	}
+	if got, want := today(time.UTC), []int{435946, 435947}; !slices.Equal(got, want) {
+		t.Errorf("today in UTC = %v, want %v", got, want)
+	}
+
+	// Naming the store's zone shares its entry
+	if got, want := today(tokyo), []int{435947}; !slices.Equal(got, want) {
+		t.Errorf("today in Tokyo = %v, want %v", got, want)
+	}
+
+	if got := counting.calls["GetTodaysMatches"]; got != 2 {
+		t.Errorf("store read %d times, want 2", got)
+	}
+}
+
+func TestCachingStoreCountMatchesKey(t *testing.T) {
+	counting := newCountingStore(newTestStore(t))
+	c := newCachingStore(counting)
+	ctx := context.Background()
+
+	q := mongodb_test.NewQuery().Team("Liverpool")
+
+	// Every page of a query has the same count
+	for _, page := range []mongodb_test.Query{q.Limit(2), q.Skip(2).Limit(2), q} {
+		n, err := c.CountMatches(ctx, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 4 {
+			t.Errorf("CountMatches(%s) = %d, want 4", page, n)
+		}
+	}
+
+	if got := counting.calls["CountMatches"]; got != 1 {
+		t.Errorf("store counted %d times, want once for every page", got)
+	}
+
+	// A different selection is counted again
+	if _, err := c.CountMatches(ctx, q.Status(models.Finished)); err != nil {
+		t.Fatal(err)
+	}
+	if got := counting.calls["CountMatches"]; got != 2 {
+		t.Errorf("store counted %d times, want 2", got)
+	}
+}
+
+func TestCachingStoreTTL(t *testing.T) {
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	// A season that is still being played and one that is over
+	current := models.Season{Id: 1564}
+	over := models.Season{Id: 1490, Winner: models.Team{Id: 65, Name: "Manchester City FC"}}
+
+	team := func(name string) models.Team { return models.Team{Name: name + " FC", ShortName: name} }
+	match := func(id int, season models.Season, status models.MatchStatus, kickoff time.Time) models.Match {
+		return models.Match{Id: id, Season: season, Status: status, UtcDate: kickoff, HomeTeam: team("Liverpool"), AwayTeam: team("Arsenal")}
+	}
+
+	finished := match(1, current, models.Finished, now.AddDate(0, 0, -7))
+	inPlay := match(2, current, models.InPlay, now.Add(-time.Hour))
+	soon := match(3, current, models.Timed, now.Add(3*time.Hour))
+	later := match(4, current, models.Scheduled, now.AddDate(0, 0, 7))
+	lastSeason := match(5, over, models.Finished, now.AddDate(-1, 0, 0))
+
+	tests := []struct {
+		name    string
+		matches []models.Match
+		read    func(c *cachingStore) error
+		want    time.Duration
+	}{
+		{
+			name:    "finished match by id",
+			matches: []models.Match{finished},
+			read:    func(c *cachingStore) error { _, err := c.GetMatch(context.Background(), 1); return err },
+			want:    finishedTTL,
+		},
+		{
+			name:    "live match by id",
+			matches: []models.Match{inPlay},
+			read:    func(c *cachingStore) error { _, err := c.GetMatch(context.Background(), 2); return err },
+			want:    liveTTL,
+		},
+		{
+			name:    "upcoming match by id",
+			matches: []models.Match{later},
+			read:    func(c *cachingStore) error { _, err := c.GetMatch(context.Background(), 4); return err },
+			want:    defaultTTL,
+		},
+		{
+			name:    "finished match by teams",
+			matches: []models.Match{finished},
+			read: func(c *cachingStore) error {
+				_, err := c.GetOneMatch(context.Background(), "Liverpool", "Arsenal")
+				return err
+			},
+			want: defaultTTL,
+		},
+		{
+			name:    "empty list",
+			matches: []models.Match{finished},
+			read: func(c *cachingStore) error {
+				_, err := c.GetMatches(context.Background(), mongodb_test.MatchFilter{Team: "Chelsea"})
+				return err
+			},
+			want: liveTTL,
+		},
+		{
+			name:    "list of finished matches",
+			matches: []models.Match{finished},
+			read: func(c *cachingStore) error {
+				_, err := c.GetMatches(context.Background(), mongodb_test.MatchFilter{Status: []models.MatchStatus{models.Finished}})
+				return err
+			},
+			want: defaultTTL,
+		},
+		{
+			name:    "list with a live match",
+			matches: []models.Match{finished, inPlay, later},
+			read: func(c *cachingStore) error {
+				_, err := c.GetAllTeamMatches(context.Background(), "Liverpool")
+				return err
+			},
+			want: liveTTL,
+		},
+		{
+			name:    "list with a match kicking off soon",
+			matches: []models.Match{finished, soon},
+			read: func(c *cachingStore) error {
+				_, err := c.GetAllTeamMatches(context.Background(), "Liverpool")
+				return err
+			},
+			want: liveTTL,
+		},
+		{
+			name:    "list with upcoming matches",
+			matches: []models.Match{finished, later},
+			read: func(c *cachingStore) error {
+				_, err := c.GetAllTeamMatches(context.Background(), "Liverpool")
+				return err
+			},
+			want: defaultTTL,
+		},
+		{
+			name:    "finished season",
+			matches: []models.Match{lastSeason, finished},
+			read: func(c *cachingStore) error {
+				return c.FindMatches(context.Background(), mongodb_test.NewQuery().Season(over.Id), func(models.Match) error { return nil })
+			},
+			want: finishedTTL,
+		},
+		{
+			name:    "finished matches of a season still being played",
+			matches: []models.Match{lastSeason, finished, later},
+			read: func(c *cachingStore) error {
+				q := mongodb_test.NewQuery().Season(current.Id).Status(models.Finished)
+				return c.FindMatches(context.Background(), q, func(models.Match) error { return nil })
+			},
+			want: defaultTTL,
+		},
+		{
+			name:    "finished matches not limited to a season",
+			matches: []models.Match{lastSeason},
+			read: func(c *cachingStore) error {
+				return c.FindMatches(context.Background(), mongodb_test.NewQuery(), func(models.Match) error { return nil })
+			},
+			want: defaultTTL,
+		},
+		{
+			name:    "empty season",
+			matches: []models.Match{lastSeason},
+			read: func(c *cachingStore) error {
+				return c.FindMatches(context.Background(), mongodb_test.NewQuery().Season(1), func(models.Match) error { return nil })
+			},
+			want: liveTTL,
+		},
+		{
+			name:    "no matches to count",
+			matches: []models.Match{finished},
+			read: func(c *cachingStore) error {
+				_, err := c.CountMatches(context.Background(), mongodb_test.NewQuery().Team("Chelsea"))
+				return err
+			},
+			want: liveTTL,
+		},
+		{
+			name:    "count of finished matches",
+			matches: []models.Match{finished},
+			read: func(c *cachingStore) error {
+				_, err := c.CountMatches(context.Background(), mongodb_test.NewQuery().Status(models.Finished))
+				return err
+			},
+			want: defaultTTL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore(tt.matches)
+			store.now = func() time.Time { return now }
+
+			c := newCachingStore(store)
+			c.now = store.now
+
+			if err := tt.read(c); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(c.entries) != 1 {
+				t.Fatalf("cached %d entries, want 1", len(c.entries))
+			}
+			for key, entry := range c.entries {
+				if got := entry.expires.Sub(now); got != tt.want {
+					t.Errorf("%s cached for %v, want %v", key, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"mongodb-test"
+)
+
+// envPrefix is the prefix of the environment variables that override settings
+const envPrefix = "SERVER_"
+
+// commandLineOnly are the flags that can't be set from the config file or environment
+var commandLineOnly = map[string]bool{
+	"config":        true,
+	"hash-password": true,
+}
+
+// mongoConfig is where the matches are read from when they aren't served from a file
+type mongoConfig struct {
+	URI            string
+	Database       string
+	Collection     string
+	ConnectTimeout time.Duration
+	QueryTimeout   time.Duration
+	MaxPoolSize    uint64
+	ReadPreference string
+	Username       string
+	Password       string
+	AuthSource     string
+	Migrate        bool
+	MigrateTimeout time.Duration
+}
+
+// options will return the options to connect to MongoDB with
+func (m mongoConfig) options() []mongodb_test.Option {
+	opts := []mongodb_test.Option{
+		mongodb_test.WithURI(m.URI),
+		mongodb_test.WithDatabase(m.Database),
+		mongodb_test.WithCollection(m.Collection),
+		mongodb_test.WithConnectTimeout(m.ConnectTimeout),
+		mongodb_test.WithQueryTimeout(m.QueryTimeout),
+	}
+
+	// Leave the driver's defaults, or the settings in the URI, alone unless these are set
+	if m.MaxPoolSize != 0 {
+		opts = append(opts, mongodb_test.WithMaxPoolSize(m.MaxPoolSize))
+	}
+
+	if m.ReadPreference != "" {
+		// The mode has already been validated
+		mode, _ := readpref.ModeFromString(m.ReadPreference)
+		rp, _ := readpref.New(mode)
+		opts = append(opts, mongodb_test.WithReadPreference(rp))
+	}
+
+	if m.Username != "" {
+		opts = append(opts, mongodb_test.WithCredentials(m.Username, m.Password, m.AuthSource))
+	}
+
+	return opts
+}
+
+// config is the server's configuration
+// Every setting is a flag, and can also be set in the config file or by an environment variable
+// Flags take precedence over the environment, which takes precedence over the file
+type config struct {
+	// ConfigFile is the JSON file to read settings from
+	ConfigFile string
+	// HashPassword reads a password from stdin and prints its hash instead of starting the server
+	HashPassword bool
+
+	Addr         string
+	DataFile     string
+	Cache        bool
+	UsersFile    string
+	SessionKey   string
+	Dev          bool
+	LiveInterval time.Duration
+	Limits       routeLimits
+	MaxBody      int64
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownDelay is how long readiness fails before the server stops listening
+	ShutdownDelay time.Duration
+	// Timezone is the IANA time zone days and weeks are counted in, the local time zone if empty
+	Timezone string
+	Mongo    mongoConfig
+}
+
+// register will create a flag for every setting, with its default value
+func (c *config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.ConfigFile, "config", "", "JSON file of settings, keyed by flag name")
+	fs.BoolVar(&c.HashPassword, "hash-password", false, "Read a password from stdin, print its bcrypt hash for the users file and exit")
+
+	fs.StringVar(&c.Addr, "addr", ":8080", "Address to listen on")
+	fs.StringVar(&c.Timezone, "timezone", "", "IANA time zone that today and this week are counted in, such as Europe/London, or the local time zone if not set")
+
+	// Where the matches come from
+	fs.StringVar(&c.DataFile, "data", "", "Serve matches from this JSON file instead of MongoDB")
+	fs.BoolVar(&c.Cache, "cache", true, "Cache results read from the store")
+	fs.StringVar(&c.Mongo.URI, "mongo-uri", mongodb_test.DefaultURI, "MongoDB connection string")
+	fs.StringVar(&c.Mongo.Database, "mongo-database", mongodb_test.DefaultDatabase, "MongoDB database holding the matches")
+	fs.StringVar(&c.Mongo.Collection, "mongo-collection", mongodb_test.DefaultCollection, "MongoDB collection holding the matches")
+	fs.DurationVar(&c.Mongo.ConnectTimeout, "mongo-connect-timeout", mongodb_test.DefaultConnectTimeout, "Longest time to connect to MongoDB")
+	fs.DurationVar(&c.Mongo.QueryTimeout, "mongo-query-timeout", mongodb_test.DefaultQueryTimeout, "Longest time a MongoDB query can take, or 0 to only stop when the request is cancelled")
+	fs.Uint64Var(&c.Mongo.MaxPoolSize, "mongo-max-pool-size", 0, "Largest number of connections to each MongoDB server, or 0 for the driver's default")
+	fs.StringVar(&c.Mongo.ReadPreference, "mongo-read-preference", "", "MongoDB read preference, such as primary or secondaryPreferred")
+	fs.StringVar(&c.Mongo.Username, "mongo-username", "", "User to authenticate to MongoDB as")
+	fs.StringVar(&c.Mongo.Password, "mongo-password", "", "Password to authenticate to MongoDB with, best set with "+envName("mongo-password"))
+	fs.StringVar(&c.Mongo.AuthSource, "mongo-auth-source", "", "Database the MongoDB user is defined in, admin if not set")
+	fs.BoolVar(&c.Mongo.Migrate, "mongo-migrate", false, "Apply migrations and create missing indexes on startup, best left to one instance or to the ingest command")
+	fs.DurationVar(&c.Mongo.MigrateTimeout, "mongo-migrate-timeout", defaultMigrateTimeout, "Longest time applying migrations on startup can take")
+
+	// Where the users come from
+	fs.StringVar(&c.UsersFile, "users", "", "JSON file of users with bcrypt password hashes")
+	fs.StringVar(&c.SessionKey, "session-key", "", "Key to sign session cookies with so that sessions survive a restart, best set with "+envName("session-key")+" (random if not set)")
+
+	// Whether to reload the templates from disk
+	fs.BoolVar(&c.Dev, "dev", false, "Reload the templates from the templates directory on every request")
+
+	// How often to check for live scores
+	fs.DurationVar(&c.LiveInterval, "live-interval", defaultLiveInterval, "How often to poll the store for live score changes")
+
+	// How to protect the server from abusive clients
+	c.Limits = defaultRouteLimits
+	fs.Var(&c.Limits.API, "limit-api", "Rate limit per client for the JSON API, as count/period[,burst] or 0 for none")
+	fs.Var(&c.Limits.Pages, "limit-pages", "Rate limit per client for the HTML pages")
+	fs.Var(&c.Limits.Login, "limit-login", "Rate limit per client for logging in")
+	fs.Var(&c.Limits.Live, "limit-live", "Rate limit per client for opening live score streams")
+	fs.Int64Var(&c.MaxBody, "max-body", defaultMaxBody, "Largest request body accepted in bytes")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", defaultReadTimeout, "Longest time to read a request")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", defaultWriteTimeout, "Longest time to write a response")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", defaultIdleTimeout, "Longest time to keep an idle connection open")
+
+	// How long to keep serving once asked to stop
+	fs.DurationVar(&c.ShutdownDelay, "shutdown-delay", defaultShutdownDelay, "How long readiness checks fail before the server stops listening")
+}
+
+// envName will return the environment variable that overrides a flag, such as SERVER_MONGO_URI for -mongo-uri
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfig will parse the command line, then apply the config file and environment underneath it
+// getenv is os.Getenv outside of tests
+func loadConfig(fs *flag.FlagSet, args []string, getenv func(string) string) (*config, error) {
+	c := &config{}
+	c.register(fs)
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remember the flags given on the command line so they can be put back over the file and environment
+	given := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	// The config file can come from the environment too
+	if c.ConfigFile == "" {
+		c.ConfigFile = getenv(envName("config"))
+	}
+
+	if c.ConfigFile != "" {
+		err = applyConfigFile(fs, c.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %w", c.ConfigFile, err)
+		}
+	}
+
+	// Apply the environment
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if commandLineOnly[f.Name] {
+			return
+		}
+
+		if value := getenv(envName(f.Name)); value != "" {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", envName(f.Name), err))
+			}
+		}
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	// Put back the command line
+	for name, value := range given {
+		fs.Set(name, value)
+	}
+
+	return c, c.validate()
+}
+
+// applyConfigFile will set the flags named by the keys of the JSON object in the file
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Keep numbers as they were written so that they can be parsed as flags
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var settings map[string]any
+	err = decoder.Decode(&settings)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range settings {
+		if fs.Lookup(name) == nil || commandLineOnly[name] {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+
+		// Settings must be strings, numbers or booleans
+		switch value.(type) {
+		case string, json.Number, bool:
+		default:
+			return fmt.Errorf("setting %q must be a string, number or boolean", name)
+		}
+
+		if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("setting %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// location will return the time zone days and weeks are counted in
+func (c *config) location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+
+	// The time zone has already been validated
+	loc, _ := time.LoadLocation(c.Timezone)
+	return loc
+}
+
+// validate will check that the settings can be used, returning every problem found
+func (c *config) validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("invalid addr %q: %w", c.Addr, err))
+	}
+
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("invalid timezone %q: %w", c.Timezone, err))
+		}
+	}
+
+	// Only check the MongoDB settings if they will be used
+	if c.DataFile == "" {
+		if !strings.HasPrefix(c.Mongo.URI, "mongodb://") && !strings.HasPrefix(c.Mongo.URI, "mongodb+srv://") {
+			errs = append(errs, fmt.Errorf("invalid mongo-uri %q: must start with mongodb:// or mongodb+srv://", c.Mongo.URI))
+		}
+
+		if c.Mongo.Database == "" {
+			errs = append(errs, errors.New("mongo-database must be set"))
+		}
+
+		if c.Mongo.Collection == "" {
+			errs = append(errs, errors.New("mongo-collection must be set"))
+		}
+
+		if c.Mongo.ReadPreference != "" {
+			if _, err := readpref.ModeFromString(c.Mongo.ReadPreference); err != nil {
+				errs = append(errs, fmt.Errorf("invalid mongo-read-preference: %w", err))
+			}
+		}
+
+		if c.Mongo.QueryTimeout < 0 {
+			errs = append(errs, errors.New("mongo-query-timeout must not be negative"))
+		}
+	}
+
+	// Every duration and size must be positive
+	positive := []struct {
+		name  string
+		value int64
+	}{
+		{"mongo-connect-timeout", int64(c.Mongo.ConnectTimeout)},
+		{"mongo-migrate-timeout", int64(c.Mongo.MigrateTimeout)},
+		{"live-interval", int64(c.LiveInterval)},
+		{"max-body", c.MaxBody},
+		{"read-timeout", int64(c.ReadTimeout)},
+		{"write-timeout", int64(c.WriteTimeout)},
+		{"idle-timeout", int64(c.IdleTimeout)},
+	}
+
+	if c.ShutdownDelay < 0 {
+		errs = append(errs, errors.New("shutdown-delay must not be negative"))
+	}
+
+	for _, p := range positive {
+		if p.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be greater than zero", p.name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
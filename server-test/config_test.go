@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mongodb-test"
+)
+
+// testConfig will load the config from the arguments and environment
+func testConfig(t *testing.T, args []string, env map[string]string) (*config, error) {
+	t.Helper()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	return loadConfig(fs, append([]string{"-data", testDataFile}, args...), func(name string) string {
+		return env[name]
+	})
+}
+
+func TestSessionKeyConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"not set", nil, nil, ""},
+		{"flag", []string{"-session-key", "from flag"}, nil, "from flag"},
+		{"environment", nil, map[string]string{"SERVER_SESSION_KEY": "from env"}, "from env"},
+		{"flag over environment", []string{"-session-key", "from flag"}, map[string]string{"SERVER_SESSION_KEY": "from env"}, "from flag"},
+		// The old name isn't read any more
+		{"old environment variable", nil, map[string]string{"SESSION_KEY": "old"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := testConfig(t, tt.args, tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.SessionKey != tt.want {
+				t.Errorf("SessionKey = %q, want %q", cfg.SessionKey, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	cfg, err := loadConfig(fs, nil, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("loadConfig with no settings error: %v", err)
+	}
+
+	if cfg.Addr != ":8080" || cfg.Mongo.URI != mongodb_test.DefaultURI || !cfg.Cache || cfg.Mongo.Migrate {
+		t.Errorf("config = %+v, want the defaults", cfg)
+	}
+	if cfg.Limits != defaultRouteLimits {
+		t.Errorf("limits = %+v, want %+v", cfg.Limits, defaultRouteLimits)
+	}
+	if cfg.location() != time.Local {
+		t.Errorf("location = %v, want the local time zone", cfg.location())
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(file, []byte(`{
+		"addr": ":9000",
+		"live-interval": "10s",
+		"max-body": 2048,
+		"cache": false,
+		"limit-api": "20/s,40",
+		"mongo-database": "from_file"
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"SERVER_CONFIG":         file,
+		"SERVER_LIVE_INTERVAL":  "20s",
+		"SERVER_MONGO_DATABASE": "from_env",
+		"SERVER_TIMEZONE":       "UTC",
+	}
+
+	cfg, err := testConfig(t, []string{"-mongo-database", "from_flag"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		setting string
+		got     any
+		want    any
+	}{
+		{"addr from the file", cfg.Addr, ":9000"},
+		{"number from the file", cfg.MaxBody, int64(2048)},
+		{"boolean from the file", cfg.Cache, false},
+		{"limit from the file", cfg.Limits.API, rateLimit{Rate: 20, Burst: 40}},
+		{"environment over the file", cfg.LiveInterval, 20 * time.Second},
+		{"flag over the environment", cfg.Mongo.Database, "from_flag"},
+		{"environment only", cfg.Timezone, "UTC"},
+		{"default", cfg.ReadTimeout, defaultReadTimeout},
+		{"config file from the environment", cfg.ConfigFile, file},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.setting, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(content string) string {
+		path := filepath.Join(dir, strings.ReplaceAll(t.Name(), "/", "_")+".json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		// file is the content of a config file, if there is one
+		file string
+		want string
+	}{
+		{name: "unknown flag", args: []string{"-nope"}, want: "not defined"},
+		{name: "invalid flag value", args: []string{"-read-timeout", "soon"}, want: "invalid value"},
+		{name: "invalid environment value", env: map[string]string{"SERVER_MAX_BODY": "big"}, want: "SERVER_MAX_BODY"},
+		{name: "missing config file", args: []string{"-config", filepath.Join(dir, "missing.json")}, want: "no such file"},
+		{name: "unknown setting in the file", file: `{"nope": 1}`, want: `unknown setting "nope"`},
+		{name: "command line only setting in the file", file: `{"hash-password": true}`, want: `unknown setting "hash-password"`},
+		{name: "nested setting in the file", file: `{"addr": {"port": 80}}`, want: "must be a string, number or boolean"},
+		{name: "invalid value in the file", file: `{"live-interval": "often"}`, want: `setting "live-interval"`},
+		{name: "invalid json", file: `{"addr": `, want: "config file"},
+		{name: "invalid addr", args: []string{"-addr", "8080"}, want: "invalid addr"},
+		{name: "invalid timezone", args: []string{"-timezone", "Nowhere/Special"}, want: "invalid timezone"},
+		{name: "zero duration", args: []string{"-read-timeout", "0s"}, want: "read-timeout must be greater than zero"},
+		{name: "negative shutdown delay", args: []string{"-shutdown-delay", "-1s"}, want: "shutdown-delay must not be negative"},
+		{name: "zero migrate timeout", args: []string{"-mongo-migrate-timeout", "0s"}, want: "mongo-migrate-timeout must be greater than zero"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append(args, "-config", writeFile(tt.file))
+			}
+
+			_, err := testConfig(t, args, tt.env)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMongoSettings(t *testing.T) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	// MongoDB settings are only checked when the matches come from MongoDB
+	args := []string{"-mongo-uri", "http://localhost", "-mongo-database", "", "-mongo-read-preference", "sometimes", "-mongo-query-timeout", "-1s"}
+
+	_, err := loadConfig(fs, args, func(string) string { return "" })
+	if err == nil {
+		t.Fatal("loadConfig with invalid MongoDB settings succeeded")
+	}
+
+	// Every problem is reported at once
+	for _, want := range []string{"invalid mongo-uri", "mongo-database must be set", "invalid mongo-read-preference", "mongo-query-timeout must not be negative"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("loadConfig error doesn't contain %q: %v", want, err)
+		}
+	}
+
+	if _, err := testConfig(t, args, nil); err != nil {
+		t.Errorf("loadConfig serving from a file error = %v, want nil", err)
+	}
+}
+
+func TestMongoOptions(t *testing.T) {
+	cfg, err := testConfig(t, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the settings that are always set are passed on by default
+	if got := len(cfg.Mongo.options()); got != 5 {
+		t.Errorf("default options = %d, want 5", got)
+	}
+
+	cfg.Mongo.MaxPoolSize = 10
+	cfg.Mongo.ReadPreference = "secondaryPreferred"
+	cfg.Mongo.Username = "reader"
+	if got := len(cfg.Mongo.options()); got != 8 {
+		t.Errorf("options with a pool size, read preference and user = %d, want 8", got)
+	}
+}
@@ -4,7 +4,11 @@ go 1.22.1
 
 replace mongodb-test => ../mongodb-test
 
-require mongodb-test v0.0.0-00010101000000-000000000000
+require (
+	go.mongodb.org/mongo-driver v1.14.0
+	golang.org/x/crypto v0.17.0
+	mongodb-test v0.0.0-00010101000000-000000000000
+)
 
 require (
 	github.com/golang/snappy v0.0.1 // indirect
@@ -14,8 +18,6 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
-	go.mongodb.org/mongo-driver v1.14.0 // indirect
-	golang.org/x/crypto v0.17.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
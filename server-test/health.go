@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// readyTimeout is how long the store has to answer a readiness check
+const readyTimeout = time.Second
+
+// pinger is implemented by stores that depend on a server that can be unreachable
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// healthResponse is the body returned by /healthz and /readyz
+type healthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// versionResponse is the body returned by /version
+type versionResponse struct {
+	GoVersion string            `json:"goVersion"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings"`
+	Deps      map[string]string `json:"deps"`
+}
+
+// healthzHandler handles GET /healthz, which succeeds as long as the process is serving requests
+func (s *server) healthzHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// readyzHandler handles GET /readyz, which succeeds if the store can be reached and the server isn't shutting down
+func (s *server) readyzHandler(w http.ResponseWriter, req *http.Request) {
+	// Fail while shutting down so that no new traffic is sent here
+	if s.shuttingDown.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "shutting down"})
+		return
+	}
+
+	// Check the store can be reached, stores held in memory always can
+	if p, ok := s.store.(pinger); ok {
+		ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+		defer cancel()
+
+		if err := p.Ping(ctx); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "not ready", Error: err.Error()})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// versionHandler handles GET /version, returning the build information of the binary
+func versionHandler(w http.ResponseWriter, req *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		writeError(w, http.StatusNotFound, "build information not available")
+		return
+	}
+
+	version := versionResponse{
+		GoVersion: info.GoVersion,
+		Path:      info.Main.Path,
+		Version:   info.Main.Version,
+		Settings:  make(map[string]string),
+		Deps:      make(map[string]string),
+	}
+
+	// Include the VCS and build settings, such as vcs.revision
+	for _, setting := range info.Settings {
+		version.Settings[setting.Key] = setting.Value
+	}
+
+	for _, dep := range info.Deps {
+		version.Deps[dep.Path] = dep.Version
+	}
+
+	writeJSON(w, http.StatusOK, version)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// pingingStore is a store that depends on a server that can be unreachable
+type pingingStore struct {
+	MatchStore
+	err error
+	// deadline is how long the last ping was given
+	deadline time.Duration
+	pings    int
+}
+
+func (s *pingingStore) Ping(ctx context.Context) error {
+	s.pings++
+	if deadline, ok := ctx.Deadline(); ok {
+		s.deadline = time.Until(deadline)
+	}
+	return s.err
+}
+
+// getHealth will make a request to one of the health routes and decode the response
+func getHealth(t *testing.T, handler http.Handler, path string, body any) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s Content-Type = %q, want application/json", path, ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), body); err != nil {
+		t.Fatalf("GET %s: decoding %q: %v", path, rec.Body, err)
+	}
+
+	return rec.Code
+}
+
+func TestHealthz(t *testing.T) {
+	s := newTestServer(t, &pingingStore{MatchStore: newTestStore(t), err: errors.New("connection refused")}, routeLimits{})
+	s.shuttingDown.Store(true)
+
+	// Liveness doesn't depend on the store or on shutting down
+	var body healthResponse
+	if code := getHealth(t, s.routes(), "/healthz", &body); code != http.StatusOK || body.Status != "ok" {
+		t.Errorf("GET /healthz = %d %+v, want 200 ok", code, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name         string
+		store        func(t *testing.T) MatchStore
+		shuttingDown bool
+		wantStatus   int
+		want         healthResponse
+		// wantPings is the number of times the store should be pinged, -1 if it can't be
+		wantPings int
+	}{
+		{
+			name:       "store in memory",
+			store:      func(t *testing.T) MatchStore { return newTestStore(t) },
+			wantStatus: http.StatusOK,
+			want:       healthResponse{Status: "ok"},
+			wantPings:  -1,
+		},
+		{
+			name:       "store reachable",
+			store:      func(t *testing.T) MatchStore { return &pingingStore{MatchStore: newTestStore(t)} },
+			wantStatus: http.StatusOK,
+			want:       healthResponse{Status: "ok"},
+			wantPings:  1,
+		},
+		{
+			name: "store unreachable",
+			store: func(t *testing.T) MatchStore {
+				return &pingingStore{MatchStore: newTestStore(t), err: errors.New("connection refused")}
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			want:       healthResponse{Status: "not ready", Error: "connection refused"},
+			wantPings:  1,
+		},
+		{
+			name:         "shutting down",
+			store:        func(t *testing.T) MatchStore { return &pingingStore{MatchStore: newTestStore(t)} },
+			shuttingDown: true,
+			wantStatus:   http.StatusServiceUnavailable,
+			want:         healthResponse{Status: "shutting down"},
+			wantPings:    0,
+		},
+		{
+			name: "store behind the cache",
+			store: func(t *testing.T) MatchStore {
+				return newCachingStore(&pingingStore{MatchStore: newTestStore(t), err: errors.New("timed out")})
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			want:       healthResponse{Status: "not ready", Error: "timed out"},
+			wantPings:  -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store(t)
+			s := newTestServer(t, store, routeLimits{})
+			s.shuttingDown.Store(tt.shuttingDown)
+
+			var body healthResponse
+			code := getHealth(t, s.routes(), "/readyz", &body)
+
+			if code != tt.wantStatus || body != tt.want {
+				t.Errorf("GET /readyz = %d %+v, want %d %+v", code, body, tt.wantStatus, tt.want)
+			}
+
+			if p, ok := store.(*pingingStore); ok {
+				if p.pings != tt.wantPings {
+					t.Errorf("store pinged %d times, want %d", p.pings, tt.wantPings)
+				}
+				if p.pings > 0 && (p.deadline <= 0 || p.deadline > readyTimeout) {
+					t.Errorf("ping given %v, want at most %v", p.deadline, readyTimeout)
+				}
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	var body versionResponse
+	code := getHealth(t, http.HandlerFunc(versionHandler), "/version", &body)
+
+	if code != http.StatusOK {
+		t.Fatalf("GET /version status = %d, want 200", code)
+	}
+	if body.GoVersion != runtime.Version() {
+		t.Errorf("goVersion = %q, want %q", body.GoVersion, runtime.Version())
+	}
+	if body.Settings == nil || body.Deps == nil {
+		t.Errorf("settings and deps = %v and %v, want objects even if empty", body.Settings, body.Deps)
+	}
+	if _, ok := body.Deps["go.mongodb.org/mongo-driver"]; !ok {
+		t.Errorf("deps = %v, want the MongoDB driver listed", body.Deps)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"mongodb-test/models"
+	"mongodb-test/standings"
+)
+
+const (
+	// icalTimeFormat is the UTC date-time format used by iCalendar
+	icalTimeFormat = "20060102T150405Z"
+	// icalLineLength is the longest a line can be, in octets, before it has to be folded
+	icalLineLength = 75
+	// matchDuration is how long each match is shown as lasting in the calendar
+	matchDuration = 2 * time.Hour
+	// icalUIDDomain makes the UIDs of the events globally unique
+	icalUIDDomain = "server-test"
+)
+
+// icalEscaper escapes the characters that have a meaning in iCalendar text values
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// icalWriter writes the content lines of an iCalendar object
+type icalWriter struct {
+	b strings.Builder
+}
+
+// line will write a content line, folding it so that no line is longer than icalLineLength octets
+func (w *icalWriter) line(name, value string) {
+	line := name + ":" + value
+
+	// Fold before the limit without splitting a UTF-8 sequence, continuation lines start with a space
+	limit := icalLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+
+		w.b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+
+		// The leading space counts towards the length of the next line
+		limit = icalLineLength - 1
+	}
+
+	w.b.WriteString(line + "\r\n")
+}
+
+// text will write a content line with a text value, escaping it
+func (w *icalWriter) text(name, value string) {
+	w.line(name, icalEscaper.Replace(value))
+}
+
+// matchEvent will write a VEVENT for the match
+func (w *icalWriter) matchEvent(match models.Match, stamp time.Time) {
+	w.line("BEGIN", "VEVENT")
+
+	// The UID stays the same when the match is updated so calendars replace the event
+	w.line("UID", fmt.Sprintf("match-%d@%s", match.Id, icalUIDDomain))
+	w.line("DTSTAMP", stamp.UTC().Format(icalTimeFormat))
+	w.line("DTSTART", match.UtcDate.UTC().Format(icalTimeFormat))
+	w.line("DTEND", match.UtcDate.Add(matchDuration).UTC().Format(icalTimeFormat))
+	w.text("SUMMARY", fmt.Sprintf("%v v %v", match.HomeTeam, match.AwayTeam))
+
+	if !match.LastUpdated.IsZero() {
+		w.line("LAST-MODIFIED", match.LastUpdated.UTC().Format(icalTimeFormat))
+	}
+
+	// Show the score once it is final, and why a match won't go ahead
+	switch {
+	case standings.Completed(match):
+		w.text("DESCRIPTION", fmt.Sprintf("%s %d - %d %s (%v)",
+			match.HomeTeam.Name, match.Score.FullTime.Home, match.Score.FullTime.Away, match.AwayTeam.Name, match.Status))
+	case match.Status == models.Postponed || match.Status == models.Cancelled:
+		w.text("DESCRIPTION", match.Status.String())
+		w.line("STATUS", "CANCELLED")
+	default:
+		w.text("DESCRIPTION", fmt.Sprintf("%s v %s, matchday %d", match.HomeTeam.Name, match.AwayTeam.Name, match.Matchday))
+	}
+
+	w.line("END", "VEVENT")
+}
+
+// teamCalendar will create an iCalendar object with an event for each of the team's matches
+func teamCalendar(team string, matches []models.Match, stamp time.Time) string {
+	var w icalWriter
+
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//"+icalUIDDomain+"//Fixtures//EN")
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	w.text("X-WR-CALNAME", team+" fixtures")
+
+	for _, match := range matches {
+		w.matchEvent(match, stamp)
+	}
+
+	w.line("END", "VCALENDAR")
+
+	return w.b.String()
+}
+
+// icalHandler handles GET /teams/{team}/fixtures.ics, returning the team's matches as an iCalendar feed
+func (s *server) icalHandler(w http.ResponseWriter, req *http.Request) {
+	team := req.PathValue("team")
+
+	// Get the team's matches
+	matches, err := s.store.GetAllTeamMatches(req.Context(), team)
+	if err != nil {
+		http.Error(w, "error getting matches", http.StatusInternalServerError)
+		return
+	}
+
+	if len(matches.Matches) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	if notModified(w, req, matches.Matches...) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", team+".ics"))
+	fmt.Fprint(w, teamCalendar(team, matches.Matches, time.Now()))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// unfold will join the folded lines of an iCalendar object back into content lines
+func unfold(s string) []string {
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n ", ""), "\r\n"), "\r\n")
+}
+
+func TestICalLineFolding(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		// wantFirst is the length in octets of the first line, if it is checked
+		wantFirst int
+	}{
+		{name: "short", value: "VEVENT", wantFirst: 8},
+		// The name and colon are 2 octets, so this line is exactly the limit
+		{name: "exactly the limit", value: strings.Repeat("a", 73), wantFirst: 75},
+		{name: "one over the limit", value: strings.Repeat("a", 74), wantFirst: 75},
+		{name: "several folds", value: strings.Repeat("abcdefghij", 30), wantFirst: 75},
+		// The 2 octets of é are the 75th and 76th, so the fold moves before it
+		{name: "two octets at the fold", value: strings.Repeat("a", 72) + "é" + "bc", wantFirst: 74},
+		// The 4 octets of the trophy are the 73rd to 76th
+		{name: "four octets at the fold", value: strings.Repeat("a", 70) + "🏆" + "bc", wantFirst: 72},
+		// A sequence that ends at the limit stays on the first line
+		{name: "multi-byte ending at the limit", value: strings.Repeat("a", 71) + "é" + "bc", wantFirst: 75},
+		{name: "all multi-byte", value: strings.Repeat("Müller Ødegaard ⚽ ", 20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w icalWriter
+			w.line("X", tt.value)
+			out := w.b.String()
+
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Errorf("output doesn't end with CRLF: %q", out)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			for i, line := range lines {
+				if len(line) > icalLineLength {
+					t.Errorf("line %d is %d octets: %q", i, len(line), line)
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d splits a UTF-8 sequence: %q", i, line)
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation line %d doesn't start with a space: %q", i, line)
+				}
+			}
+
+			if tt.wantFirst != 0 && len(lines[0]) != tt.wantFirst {
+				t.Errorf("first line is %d octets, want %d: %q", len(lines[0]), tt.wantFirst, lines[0])
+			}
+
+			// Unfolding gives back the original line
+			if got := unfold(out); len(got) != 1 || got[0] != "X:"+tt.value {
+				t.Errorf("unfolded = %q, want %q", got, "X:"+tt.value)
+			}
+		})
+	}
+}
+
+func TestICalText(t *testing.T) {
+	var w icalWriter
+	w.text("SUMMARY", "Brighton & Hove Albion, Wolves; Spurs\\Hotspur\nLate")
+
+	want := `SUMMARY:Brighton & Hove Albion\, Wolves\; Spurs\\Hotspur\nLate` + "\r\n"
+	if got := w.b.String(); got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+}
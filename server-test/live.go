@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"mongodb-test/models"
+)
+
+const (
+	// defaultLiveInterval is how often the store is polled for score changes
+	defaultLiveInterval = 10 * time.Second
+	// liveKeepAlive is how often a comment is sent to idle clients so proxies don't close the stream
+	liveKeepAlive = 15 * time.Second
+	// liveWriteTimeout is how long a write to a stream can take, the server's write timeout would end the stream
+	liveWriteTimeout = 2 * liveKeepAlive
+	// liveBuffer is the number of updates queued for a client before it is dropped as too slow
+	liveBuffer = 32
+)
+
+// matchUpdate is the state of a match pushed to browsers when its score or status changes
+type matchUpdate struct {
+	Id         int                `json:"id"`
+	Status     models.MatchStatus `json:"status"`
+	StatusText string             `json:"statusText"`
+	Home       int                `json:"home"`
+	Away       int                `json:"away"`
+}
+
+// newMatchUpdate will create the update for a match
+func newMatchUpdate(match models.Match) matchUpdate {
+	return matchUpdate{
+		Id:         match.Id,
+		Status:     match.Status,
+		StatusText: match.Status.String(),
+		Home:       match.Score.FullTime.Home,
+		Away:       match.Score.FullTime.Away,
+	}
+}
+
+// liveScores polls the store for today's matches and pushes any that change to its subscribers
+type liveScores struct {
+	// store is polled for today's matches
+	store MatchStore
+	// interval is the time between polls
+	interval time.Duration
+	// logger is used to report errors polling the store
+	logger *slog.Logger
+
+	// mu guards the fields below
+	mu sync.Mutex
+	// last is the state of each of today's matches at the last poll
+	last map[int]matchUpdate
+	// subscribers are the channels of the connected clients
+	subscribers map[chan matchUpdate]struct{}
+	// stopped is set once run has returned, so no more clients are accepted
+	stopped bool
+}
+
+// newLiveScores will create a poller for the store
+func newLiveScores(store MatchStore, interval time.Duration, logger *slog.Logger) *liveScores {
+	return &liveScores{
+		store:       store,
+		interval:    interval,
+		logger:      logger,
+		last:        make(map[int]matchUpdate),
+		subscribers: make(map[chan matchUpdate]struct{}),
+	}
+}
+
+// run will poll the store until the context is cancelled, then disconnect every subscriber
+func (l *liveScores) run(ctx context.Context) {
+	// Create a ticker for the polls
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		l.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			l.stop()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll will read today's matches and publish those that have changed since the last poll
+func (l *liveScores) poll(ctx context.Context) {
+	matches, err := l.store.GetTodaysMatches(ctx, nil)
+	if err != nil {
+		l.logger.Error("polling live scores", slog.Any("error", err))
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget matches that are no longer today's, so the snapshot sent to new clients doesn't grow
+	today := make(map[int]bool, len(matches.Matches))
+	for _, match := range matches.Matches {
+		today[match.Id] = true
+	}
+
+	for id := range l.last {
+		if !today[id] {
+			delete(l.last, id)
+		}
+	}
+
+	for _, match := range matches.Matches {
+		update := newMatchUpdate(match)
+
+		// Only publish matches whose score or status has changed
+		if last, ok := l.last[update.Id]; ok && last == update {
+			continue
+		}
+		l.last[update.Id] = update
+
+		l.publish(update)
+	}
+}
+
+// publish will send the update to every subscriber, dropping any that have fallen behind
+// The caller must hold mu
+func (l *liveScores) publish(update matchUpdate) {
+	for ch := range l.subscribers {
+		select {
+		case ch <- update:
+		default:
+			// The client will reconnect and be sent the latest state
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe will return a channel of updates and the current state of today's matches
+// The channel is closed when the subscriber is dropped or the poller stops
+func (l *liveScores) subscribe() (chan matchUpdate, []matchUpdate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ch := make(chan matchUpdate, liveBuffer)
+
+	// Don't accept subscribers once the poller has stopped
+	if l.stopped {
+		close(ch)
+		return ch, nil
+	}
+
+	l.subscribers[ch] = struct{}{}
+
+	// Copy the current state so the client starts up to date
+	snapshot := make([]matchUpdate, 0, len(l.last))
+	for _, update := range l.last {
+		snapshot = append(snapshot, update)
+	}
+
+	return ch, snapshot
+}
+
+// unsubscribe will remove the subscriber if it hasn't already been dropped
+func (l *liveScores) unsubscribe(ch chan matchUpdate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.subscribers[ch]; ok {
+		delete(l.subscribers, ch)
+		close(ch)
+	}
+}
+
+// stop will disconnect every subscriber so that their requests finish
+func (l *liveScores) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stopped = true
+
+	for ch := range l.subscribers {
+		delete(l.subscribers, ch)
+		close(ch)
+	}
+}
+
+// writeEvent will write a server-sent event with the update as its data
+func writeEvent(w http.ResponseWriter, update matchUpdate) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: match\ndata: %s\n\n", data)
+	return err
+}
+
+// liveHandler handles GET /api/live, streaming match updates as server-sent events
+func (s *server) liveHandler(w http.ResponseWriter, req *http.Request) {
+	rc := http.NewResponseController(w)
+
+	// Subscribe before writing anything so no update is missed
+	updates, snapshot := s.live.subscribe()
+	defer s.live.unsubscribe(updates)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Replace the server's write timeout with one for each write
+	rc.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+
+	// Send the current state, covering anything that changed since the page was rendered
+	for _, update := range snapshot {
+		if err := writeEvent(w, update); err != nil {
+			return
+		}
+	}
+
+	if err := rc.Flush(); err != nil {
+		s.logger.Error("streaming live scores", slog.Any("error", err))
+		return
+	}
+
+	// Create a ticker for the keep alives
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		rc.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+
+		select {
+		case <-req.Context().Done():
+			return
+		case update, ok := <-updates:
+			// The channel is closed if the client fell behind or the server is shutting down
+			if !ok {
+				return
+			}
+
+			if err := writeEvent(w, update); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep alive\n\n"); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLiveScoresSnapshotOnlyHoldsToday(t *testing.T) {
+	store := newTestStore(t)
+	live := newLiveScores(store, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// snapshotIDs will return the ids of the matches a new subscriber is sent
+	snapshotIDs := func() []int {
+		ch, snapshot := live.subscribe()
+		defer live.unsubscribe(ch)
+
+		var ids []int
+		for _, update := range snapshot {
+			ids = append(ids, update.Id)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	// todayIDs will return the ids of the matches kicking off on the store's today
+	todayIDs := func() []int {
+		matches, err := store.GetTodaysMatches(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []int
+		for _, match := range matches.Matches {
+			ids = append(ids, match.Id)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	// Poll on consecutive days of matchday 4, then on a day with no matches
+	for _, day := range []time.Time{
+		time.Date(2023, 9, 2, 12, 0, 0, 0, time.UTC),
+		time.Date(2023, 9, 3, 12, 0, 0, 0, time.UTC),
+		time.Date(2023, 9, 10, 12, 0, 0, 0, time.UTC),
+	} {
+		store.now = func() time.Time { return day }
+		live.poll(context.Background())
+
+		if got, want := snapshotIDs(), todayIDs(); !slices.Equal(got, want) {
+			t.Errorf("snapshot on %s = %v, want today's matches %v", day.Format(time.DateOnly), got, want)
+		}
+	}
+}
+
+func TestLiveScoresPublishesChanges(t *testing.T) {
+	store := newTestStore(t)
+	live := newLiveScores(store, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ch, _ := live.subscribe()
+	defer live.unsubscribe(ch)
+
+	// The first poll publishes every match, the second publishes nothing as nothing changed
+	live.poll(context.Background())
+	first := len(ch)
+	if first == 0 {
+		t.Fatal("first poll published no updates")
+	}
+
+	live.poll(context.Background())
+	if len(ch) != first {
+		t.Errorf("second poll published %d updates, want none", len(ch)-first)
+	}
+}
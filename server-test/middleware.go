@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// requestIDHeader is the header used to receive and return the request ID
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from a client
+const maxRequestIDLength = 64
+
+// middleware wraps a handler to add behaviour before or after it
+type middleware func(http.Handler) http.Handler
+
+// chain will wrap the handler in the middleware, the first middleware being the outermost
+func chain(handler http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// responseRecorder wraps a ResponseWriter to record the status code and number of bytes written
+type responseRecorder struct {
+	http.ResponseWriter
+	// status is the status code written, zero until the header has been written
+	status int
+	// bytes is the number of body bytes written
+	bytes int
+}
+
+// WriteHeader will record the status code and pass it on
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write will record the number of bytes and pass them on
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	// Writing without a header implies 200 OK
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+
+	return n, err
+}
+
+// Unwrap will return the wrapped ResponseWriter so that http.ResponseController can reach it
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush will flush the wrapped ResponseWriter if it supports flushing
+func (r *responseRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// requestIDFromContext will return the request ID stored in the context, or an empty string
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestID).(string)
+	return id
+}
+
+// newRequestID will return a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// validRequestID will return true if a request ID from a client is safe to log and echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestIDHandler will give every request an ID, reusing the one sent by the client if it is valid
+// The ID is stored in the request context and returned in the response headers
+func requestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Use the client's ID so that requests can be traced across services
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		// Return the ID to the client
+		w.Header().Set(requestIDHeader, id)
+
+		// Add the ID to the request context
+		ctx := context.WithValue(req.Context(), requestID, id)
+
+		// Call the next handler
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// accessLogHandler will log every request once it has been handled
+func accessLogHandler(logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+
+			// Record the status and size of the response
+			recorder := &responseRecorder{ResponseWriter: w}
+
+			// Call the next handler
+			next.ServeHTTP(recorder, req)
+
+			// A handler that writes nothing sends 200 OK
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			logger.LogAttrs(
+				req.Context(),
+				slog.LevelInfo,
+				"request",
+				slog.String("request_id", requestIDFromContext(req.Context())),
+				slog.String("remote_addr", req.RemoteAddr),
+				slog.String("method", req.Method),
+				slog.String("path", req.URL.Path),
+				slog.String("query", req.URL.RawQuery),
+				slog.String("proto", req.Proto),
+				slog.Int("status", status),
+				slog.Int("bytes", recorder.bytes),
+				slog.Duration("duration", time.Since(start)),
+			)
+		})
+	}
+}
+
+// recoverHandler will turn a panic in a handler into a 500 response
+func recoverHandler(logger *slog.Logger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// Record whether the handler has started the response
+			recorder := &responseRecorder{ResponseWriter: w}
+
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+
+				// Let the server abort the response as it was asked to
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				logger.Error(
+					"panic",
+					slog.String("request_id", requestIDFromContext(req.Context())),
+					slog.Any("error", err),
+					slog.String("stack", string(debug.Stack())),
+				)
+
+				// Only send an error if the handler hadn't already started the response
+				if recorder.status == 0 {
+					writeError(w, http.StatusInternalServerError, "internal server error")
+				}
+			}()
+
+			// Call the next handler
+			next.ServeHTTP(recorder, req)
+		})
+	}
+}
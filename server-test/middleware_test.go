@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logRecords will decode the JSON log lines written to buf
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decoding log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, req)
+			})
+		}
+	}
+
+	handler := chain(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		order = append(order, "handler")
+	}), mark("first"), mark("second"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := strings.Join(order, " "); got != "first second handler" {
+		t.Errorf("order = %q, want the first middleware outermost", got)
+	}
+}
+
+func TestRequestIDHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		// keep is true if the sent ID should be used
+		keep bool
+	}{
+		{"no id", "", false},
+		{"client id", "abc-123", true},
+		{"longest id", strings.Repeat("a", maxRequestIDLength), true},
+		{"id too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"id with a space", "abc 123", false},
+		{"id with a newline", "abc\n123", false},
+		{"id with non-ascii", "abcé", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inContext string
+			handler := requestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				inContext = requestIDFromContext(req.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.sent != "" {
+				req.Header.Set(requestIDHeader, tt.sent)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(requestIDHeader)
+			if got == "" {
+				t.Fatalf("no %s header", requestIDHeader)
+			}
+			if got != inContext {
+				t.Errorf("header %q and context %q differ", got, inContext)
+			}
+			if (got == tt.sent) != tt.keep {
+				t.Errorf("request ID = %q, sent %q, want kept %v", got, tt.sent, tt.keep)
+			}
+			if !validRequestID(got) {
+				t.Errorf("generated request ID %q isn't valid", got)
+			}
+		})
+	}
+
+	// Generated IDs are different for each request
+	if newRequestID() == newRequestID() {
+		t.Error("newRequestID returned the same ID twice")
+	}
+}
+
+func TestAccessLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus float64
+		wantBytes  float64
+	}{
+		{
+			name:       "implicit ok",
+			handler:    func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("hello")) },
+			wantStatus: 200,
+			wantBytes:  5,
+		},
+		{
+			name: "error status",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				http.Error(w, "nope", http.StatusTeapot)
+			},
+			wantStatus: 418,
+			wantBytes:  5,
+		},
+		{
+			name:       "no body",
+			handler:    func(w http.ResponseWriter, req *http.Request) {},
+			wantStatus: 200,
+			wantBytes:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+
+			handler := chain(tt.handler, requestIDHandler, accessLogHandler(logger))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/matches?team=Liverpool", nil)
+			req.Header.Set(requestIDHeader, "trace-1")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			records := logRecords(t, &buf)
+			if len(records) != 1 {
+				t.Fatalf("logged %d records, want 1", len(records))
+			}
+			record := records[0]
+
+			want := map[string]any{
+				"msg":        "request",
+				"request_id": "trace-1",
+				"method":     "GET",
+				"path":       "/api/matches",
+				"query":      "team=Liverpool",
+				"status":     tt.wantStatus,
+				"bytes":      tt.wantBytes,
+			}
+			for key, value := range want {
+				if record[key] != value {
+					t.Errorf("%s = %v, want %v", key, record[key], value)
+				}
+			}
+			if _, ok := record["duration"]; !ok {
+				t.Error("no duration logged")
+			}
+		})
+	}
+}
+
+func TestRecoverHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	t.Run("panic before the response", func(t *testing.T) {
+		buf.Reset()
+
+		handler := chain(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			panic("boom")
+		}), requestIDHandler, recoverHandler(logger))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(requestIDHeader, "trace-2")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "internal server error") {
+			t.Errorf("status = %d, body = %q, want a 500 error", rec.Code, rec.Body)
+		}
+
+		records := logRecords(t, &buf)
+		if len(records) != 1 || records[0]["msg"] != "panic" || records[0]["error"] != "boom" || records[0]["request_id"] != "trace-2" {
+			t.Errorf("logged %v, want the panic with its request ID", records)
+		}
+		if stack, _ := records[0]["stack"].(string); !strings.Contains(stack, "middleware_test.go") {
+			t.Error("logged stack doesn't include the panicking handler")
+		}
+	})
+
+	t.Run("panic after the response started", func(t *testing.T) {
+		buf.Reset()
+
+		handler := recoverHandler(logger)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("partial"))
+			panic("boom")
+		}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		// The response already sent is left alone
+		if rec.Code != http.StatusAccepted || rec.Body.String() != "partial" {
+			t.Errorf("status = %d, body = %q, want the partial response untouched", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("abort handler is passed on", func(t *testing.T) {
+		buf.Reset()
+
+		handler := recoverHandler(logger)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want %v", err, http.ErrAbortHandler)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("logged %q, want nothing", buf.String())
+			}
+		}()
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		t.Error("ErrAbortHandler was swallowed")
+	})
+}
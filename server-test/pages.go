@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+
+	"mongodb-test"
+	"mongodb-test/models"
+	"mongodb-test/standings"
+)
+
+// teamPage is the data for the team page
+type teamPage struct {
+	Team models.Team
+	// Row is the team's line in the table, nil if it isn't in the latest season
+	Row     *standings.Row
+	Matches []models.Match
+}
+
+// fixturesHandler handles GET /fixtures, listing the matches still to be completed in kick off order
+func (s *server) fixturesHandler(w http.ResponseWriter, req *http.Request) {
+	// Get the matches that haven't finished
+	matches, err := s.store.GetMatches(req.Context(), mongodb_test.MatchFilter{
+		Status: []models.MatchStatus{models.Scheduled, models.Timed, models.InPlay, models.Paused, models.Suspended, models.Postponed},
+	})
+
+	// Check for errors
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.templates.render(w, "fixtures.html", matches)
+}
+
+// resultsHandler handles GET /results, listing completed matches with the most recent first
+func (s *server) resultsHandler(w http.ResponseWriter, req *http.Request) {
+	// Get the matches that have a result, most recent first
+	q := mongodb_test.NewQuery().Status(models.Finished, models.Awarded).OrderBy(mongodb_test.ByKickoff, true)
+	results, err := collectMatches(req.Context(), s.store, q)
+
+	// Check for errors
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	matches := models.MatchList{Matches: results}
+
+	s.templates.render(w, "results.html", matches)
+}
+
+// teamPageHandler handles GET /teams/{team}, showing a team's matches and its place in the table
+func (s *server) teamPageHandler(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("team")
+
+	// Get the team's matches
+	matches, err := s.store.GetAllTeamMatches(req.Context(), name)
+
+	// Check for errors
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(matches.Matches) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	// Find the team from one of its matches
+	page := teamPage{Team: matches.Matches[0].HomeTeam, Matches: matches.Matches}
+	if page.Team.ShortName != name {
+		page.Team = matches.Matches[0].AwayTeam
+	}
+
+	// Find the team in the table
+	table, err := s.standings(req.Context(), standings.Options{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for i := range table.Table {
+		if table.Table[i].Team.Id == page.Team.Id {
+			page.Row = &table.Table[i]
+		}
+	}
+
+	s.templates.render(w, "team.html", page)
+}
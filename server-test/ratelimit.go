@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBuckets is the number of clients above which idle buckets are swept out
+const maxBuckets = 10000
+
+// rateLimit is a token bucket limit of Rate requests per second with bursts of up to Burst requests
+// A zero Rate disables the limit
+type rateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// String will format the limit as the flag value that creates it
+func (l *rateLimit) String() string {
+	if l == nil || l.Rate == 0 {
+		return "0"
+	}
+
+	// Use the shortest period that gives a whole count
+	for _, period := range []struct {
+		d    time.Duration
+		name string
+	}{{time.Second, "s"}, {time.Minute, "m"}, {time.Hour, "h"}} {
+		n := l.Rate * period.d.Seconds()
+		if n >= 1 && math.Abs(n-math.Round(n)) < 1e-9 {
+			return fmt.Sprintf("%d/%s,%d", int(math.Round(n)), period.name, l.Burst)
+		}
+	}
+
+	return fmt.Sprintf("%g/s,%d", l.Rate, l.Burst)
+}
+
+// Set will parse a limit of the form count/period[,burst], such as 10/s,20 or 5/1m
+// The burst defaults to the count
+func (l *rateLimit) Set(s string) error {
+	if s == "0" || s == "off" {
+		*l = rateLimit{}
+		return nil
+	}
+
+	limit, burst, hasBurst := strings.Cut(s, ",")
+
+	count, period, ok := strings.Cut(limit, "/")
+	if !ok {
+		return errors.New("expected count/period")
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid count: %q", count)
+	}
+
+	// Allow a unit on its own, such as s for 1s
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid period: %q", period)
+	}
+
+	b := n
+	if hasBurst {
+		b, err = strconv.Atoi(burst)
+		if err != nil || b < 1 {
+			return fmt.Errorf("invalid burst: %q", burst)
+		}
+	}
+
+	*l = rateLimit{Rate: float64(n) / d.Seconds(), Burst: b}
+	return nil
+}
+
+// routeLimits are the rate limits for each group of routes
+type routeLimits struct {
+	// API limits the JSON API
+	API rateLimit
+	// Pages limits the HTML pages
+	Pages rateLimit
+	// Login limits attempts to log in, to slow down password guessing
+	Login rateLimit
+	// Live limits opening live score streams
+	Live rateLimit
+}
+
+// defaultRouteLimits are the rate limits used unless they are set on the command line
+var defaultRouteLimits = routeLimits{
+	API:   rateLimit{Rate: 10, Burst: 20},
+	Pages: rateLimit{Rate: 5, Burst: 10},
+	Login: rateLimit{Rate: 5.0 / 60, Burst: 5},
+	Live:  rateLimit{Rate: 6.0 / 60, Burst: 5},
+}
+
+// bucket is a client's tokens as of the last time it was refilled
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket for each client
+type rateLimiter struct {
+	limit rateLimit
+	// now returns the current time, it can be replaced to test refilling
+	now func() time.Time
+
+	// mu guards buckets
+	mu sync.Mutex
+	// buckets are the clients' buckets by IP address
+	buckets map[string]*bucket
+}
+
+// newRateLimiter will create a limiter giving each client the limit
+func newRateLimiter(limit rateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow will take a token from the client's bucket
+// If the bucket is empty it returns false and how long until a token is available
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucketFor(client)
+
+	if b.tokens < 1 {
+		return false, l.waitFor(b)
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// check will return true if the client's bucket has a token, like allow but without taking it
+func (l *rateLimiter) check(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucketFor(client)
+
+	if b.tokens < 1 {
+		return false, l.waitFor(b)
+	}
+
+	return true, 0
+}
+
+// waitFor will return how long until the bucket has a token
+func (l *rateLimiter) waitFor(b *bucket) time.Duration {
+	return time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+// bucketFor will return the client's bucket refilled up to now, l.mu must be held
+func (l *rateLimiter) bucketFor(client string) *bucket {
+	now := l.now()
+
+	b, ok := l.buckets[client]
+	if !ok {
+		// Sweep out buckets that have refilled, they are the same as new ones
+		if len(l.buckets) >= maxBuckets {
+			for k, old := range l.buckets {
+				if old.tokens+now.Sub(old.last).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+					delete(l.buckets, k)
+				}
+			}
+		}
+
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[client] = b
+	}
+
+	// Refill the bucket for the time since it was last used
+	b.tokens = min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	return b
+}
+
+// clientIP will return the IP address of the client that sent the request
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// noLimit is the middleware for a zero limit, which lets everything through
+func noLimit(next http.Handler) http.Handler {
+	return next
+}
+
+// writeRateLimited will write a 429 Too Many Requests response telling the client how long to wait
+func writeRateLimited(w http.ResponseWriter, wait time.Duration) {
+	// Round up so that a client waiting exactly this long will get a token
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+}
+
+// rateLimitHandler will reject requests from clients that exceed the limit with 429 Too Many Requests
+// Every route wrapped by the same middleware shares the limit
+func rateLimitHandler(limit rateLimit) middleware {
+	return limiterHandler(newRateLimiter(limit))
+}
+
+// limiterHandler will take a token from the limiter for every request, rejecting those from clients that have none
+func limiterHandler(limiter *rateLimiter) middleware {
+	if limiter.limit.Rate == 0 {
+		return noLimit
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ok, wait := limiter.allow(clientIP(req))
+			if !ok {
+				writeRateLimited(w, wait)
+				return
+			}
+
+			// Call the next handler
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// basicAuthLimitHandlers will return middleware for either side of the authenticator that applies the login limit to basic auth
+// Each basic auth request checks a password, so password guesses mustn't get round the limit on POST /login
+// before refuses requests with credentials from clients out of tokens, so they cost no password check
+// after takes a token when the credentials were wrong, so clients with valid credentials are only limited by their route
+func basicAuthLimitHandlers(limiter *rateLimiter) (before, after middleware) {
+	if limiter.limit.Rate == 0 {
+		return noLimit, noLimit
+	}
+
+	before = func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if _, _, ok := req.BasicAuth(); ok {
+				if ok, wait := limiter.check(clientIP(req)); !ok {
+					writeRateLimited(w, wait)
+					return
+				}
+			}
+
+			// Call the next handler
+			next.ServeHTTP(w, req)
+		})
+	}
+
+	after = func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// The authenticator leaves the request anonymous if the credentials were wrong
+			if _, _, ok := req.BasicAuth(); ok && userFromContext(req.Context()) == nil {
+				limiter.allow(clientIP(req))
+			}
+
+			// Call the next handler
+			next.ServeHTTP(w, req)
+		})
+	}
+
+	return before, after
+}
+
+// maxBytesHandler will limit the size of request bodies
+// Reading past the limit fails, and the server closes the connection once the response is sent
+func maxBytesHandler(limit int64) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req.Body = http.MaxBytesReader(w, req.Body, limit)
+
+			// Call the next handler
+			next.ServeHTTP(w, req)
+		})
+	}
+}
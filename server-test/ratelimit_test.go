@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Date(2023, 9, 2, 12, 0, 0, 0, time.UTC)
+
+	limiter := newRateLimiter(rateLimit{Rate: 2, Burst: 3})
+	limiter.now = func() time.Time { return now }
+
+	// A new client can use its whole burst at once
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow("a"); !ok {
+			t.Fatalf("request %d of the burst was refused", i+1)
+		}
+	}
+
+	// The next request waits for a token at 2 per second
+	ok, wait := limiter.allow("a")
+	if ok {
+		t.Fatal("request past the burst was allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait = %v, want 500ms", wait)
+	}
+
+	// Other clients have their own buckets
+	if ok, _ := limiter.allow("b"); !ok {
+		t.Error("another client was refused")
+	}
+
+	// Half a token isn't enough
+	now = now.Add(250 * time.Millisecond)
+	if ok, wait := limiter.allow("a"); ok || wait != 250*time.Millisecond {
+		t.Errorf("allow after 250ms = %v, %v, want false, 250ms", ok, wait)
+	}
+
+	// Waiting as long as it was told gives the client a token
+	now = now.Add(250 * time.Millisecond)
+	if ok, _ := limiter.allow("a"); !ok {
+		t.Error("request after the wait was refused")
+	}
+
+	// The bucket refills no further than the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow("a"); !ok {
+			t.Fatalf("request %d after refilling was refused", i+1)
+		}
+	}
+	if ok, _ := limiter.allow("a"); ok {
+		t.Error("bucket refilled past the burst")
+	}
+}
+
+func TestRateLimiterCheck(t *testing.T) {
+	now := time.Date(2023, 9, 2, 12, 0, 0, 0, time.UTC)
+
+	limiter := newRateLimiter(rateLimit{Rate: 1, Burst: 1})
+	limiter.now = func() time.Time { return now }
+
+	// Checking doesn't take the token
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.check("a"); !ok {
+			t.Fatalf("check %d was refused", i+1)
+		}
+	}
+
+	if ok, _ := limiter.allow("a"); !ok {
+		t.Fatal("allow after checking was refused")
+	}
+
+	// Once it has been taken checking says how long to wait
+	if ok, wait := limiter.check("a"); ok || wait != time.Second {
+		t.Errorf("check with an empty bucket = %v, %v, want false, 1s", ok, wait)
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	handler := newTestServer(t, newTestStore(t), routeLimits{
+		API: rateLimit{Rate: 1.0 / 60, Burst: 2},
+	}).routes()
+
+	get := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The API routes share the limit
+	for _, path := range []string{"/api/teams", "/api/matches"} {
+		if rec := get(path, "192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want 200", path, rec.Code)
+		}
+	}
+
+	rec := get("/api/today", "192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("GET /api/today over the limit status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+
+	// Other clients, other route groups and the health checks aren't affected
+	if rec := get("/api/teams", "192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("GET /api/teams from another client status = %d, want 200", rec.Code)
+	}
+	if rec := get("/fixtures", "192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("GET /fixtures status = %d, want 200", rec.Code)
+	}
+	if rec := get("/healthz", "192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("GET /healthz status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimitBasicAuth(t *testing.T) {
+	handler := newTestServer(t, newTestStore(t), routeLimits{
+		Login: rateLimit{Rate: 1.0 / 60, Burst: 1},
+	}).routes()
+
+	get := func(username, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/go/", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A password guess with basic auth uses up the login limit
+	if rec := get("admin", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("GET with the wrong password status = %d, want 401", rec.Code)
+	}
+
+	// So the next is refused before its password is checked, even when it's right
+	if rec := get("admin", "password"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("GET with basic auth over the login limit status = %d, want 429", rec.Code)
+	}
+
+	// Logging in shares the same limit
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("username=admin&password=password"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("POST /login over the login limit status = %d, want 429", rec.Code)
+	}
+
+	// Requests without credentials don't check a password, so they aren't limited by it
+	if rec := get("", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET without credentials status = %d, want 401", rec.Code)
+	}
+}
+
+func TestRateLimitValidBasicAuth(t *testing.T) {
+	handler := newTestServer(t, newTestStore(t), routeLimits{
+		API:   rateLimit{Rate: 1.0 / 60, Burst: 3},
+		Login: rateLimit{Rate: 1.0 / 60, Burst: 1},
+	}).routes()
+
+	// Valid credentials don't use up the login limit, however many requests carry them
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/go/", nil)
+		req.SetBasicAuth("admin", "password")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %d with valid basic auth status = %d, want 200", i+1, rec.Code)
+		}
+	}
+
+	// They are limited by the route's own limit instead
+	req := httptest.NewRequest(http.MethodGet, "/api/go/", nil)
+	req.SetBasicAuth("admin", "password")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("GET over the API limit status = %d, want 429", rec.Code)
+	}
+
+	// The login limit is still untouched
+	req = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("username=admin&password=password"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("POST /login after valid basic auth requests status = %d, want 200", rec.Code)
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"html/template"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -15,50 +20,270 @@ import (
 	"mongodb-test/models"
 )
 
+const (
+	// defaultMaxBody is the largest request body accepted unless it is set on the command line
+	defaultMaxBody = 1 << 20
+	// readHeaderTimeout is the longest time to read a request's headers
+	readHeaderTimeout = 5 * time.Second
+	// defaultReadTimeout is the longest time to read a request unless it is set on the command line
+	defaultReadTimeout = 10 * time.Second
+	// defaultWriteTimeout is the longest time to write a response unless it is set on the command line
+	defaultWriteTimeout = 30 * time.Second
+	// defaultShutdownDelay is how long readiness fails before the server stops listening unless it is set on the command line
+	defaultShutdownDelay = 5 * time.Second
+	// defaultIdleTimeout is the longest time to keep an idle connection open unless it is set on the command line
+	defaultIdleTimeout = 2 * time.Minute
+	// defaultMigrateTimeout is the longest time migrating MongoDB can take unless it is set on the command line
+	defaultMigrateTimeout = 5 * time.Minute
+)
+
 type ctxKeys string
 
 const (
-	user ctxKeys = "user"
+	user      ctxKeys = "user"
+	requestID ctxKeys = "request_id"
 )
 
-var mongo *mongodb_test.MongoTest
+// server holds the dependencies of the handlers
+type server struct {
+	// store is where the matches are read from
+	store MatchStore
+	// logger is used for the access log and errors
+	logger *slog.Logger
+	// auth authenticates users and manages their sessions
+	auth *authenticator
+	// live pushes score changes to connected browsers
+	live *liveScores
+	// templates renders the HTML pages
+	templates *templates
+	// limits are the rate limits for each group of routes
+	limits routeLimits
+	// maxBody is the largest request body accepted
+	maxBody int64
+	// shuttingDown is set once the server has been asked to stop, failing readiness checks
+	shuttingDown atomic.Bool
+}
 
-func main() {
+// newServer will create a server that reads matches from the store
+func newServer(store MatchStore, logger *slog.Logger, auth *authenticator, live *liveScores, templates *templates, limits routeLimits, maxBody int64) *server {
+	return &server{
+		store:     store,
+		logger:    logger,
+		auth:      auth,
+		live:      live,
+		templates: templates,
+		limits:    limits,
+		maxBody:   maxBody,
+	}
+}
+
+// routes will return the handler for every route the server serves
+func (s *server) routes() http.Handler {
 	// Create a new mux
 	mux := http.NewServeMux()
 
+	// Handle the health checks, which aren't rate limited so that they don't fail under load
+	// They don't authenticate either, so they never check a password
+	mux.Handle("GET /healthz", http.HandlerFunc(s.healthzHandler))
+	mux.Handle("GET /readyz", http.HandlerFunc(s.readyzHandler))
+	mux.Handle("GET /version", http.HandlerFunc(versionHandler))
+
+	// Create the rate limits, each shared by a group of routes
+	apiLimit := rateLimitHandler(s.limits.API)
+	pageLimit := rateLimitHandler(s.limits.Pages)
+	liveLimit := rateLimitHandler(s.limits.Live)
+
+	// Basic auth checks a password on any route, so wrong passwords share the login limit
+	loginLimiter := newRateLimiter(s.limits.Login)
+	loginLimit := limiterHandler(loginLimiter)
+	checkBasicAuth, chargeBasicAuth := basicAuthLimitHandlers(loginLimiter)
+
+	// authed will rate limit a route and then authenticate it, so that clients over the limit cost no password checks
+	authed := func(handler http.HandlerFunc, limit middleware, middlewares ...middleware) http.Handler {
+		return chain(handler, append([]middleware{limit, checkBasicAuth, s.auth.handler, chargeBasicAuth}, middlewares...)...)
+	}
+
 	// Handle the root route
-	mux.Handle("/", http.HandlerFunc(rootHandler))
+	mux.Handle("/", authed(rootHandler, pageLimit))
 
 	// Handle the /api/ route
-	mux.Handle("/api/", http.HandlerFunc(apiHandler))
+	mux.Handle("/api/", authed(s.apiHandler, pageLimit))
+
+	// Handle the /api/go/ route, which only admins can use
+	mux.Handle("/api/go/", authed(goHandler, apiLimit, requireRole("admin")))
+
+	// Handle logging in and out, logging in checks its own password
+	mux.Handle("POST /login", chain(http.HandlerFunc(s.auth.loginHandler), loginLimit))
+	mux.Handle("POST /logout", authed(s.auth.logoutHandler, apiLimit))
+
+	// Handle the JSON API routes
+	mux.Handle("GET /api/matches", authed(s.matchesHandler, apiLimit))
+	mux.Handle("GET /api/matches/{id}", authed(s.matchHandler, apiLimit))
+	mux.Handle("GET /api/teams", authed(s.teamsHandler, apiLimit))
+	mux.Handle("GET /api/today", authed(s.todayHandler, apiLimit))
+	mux.Handle("GET /api/week", authed(s.weekHandler, apiLimit))
+	mux.Handle("GET /api/matchday", authed(s.matchdayHandler, apiLimit))
+	mux.Handle("GET /api/teams/{team}/next", authed(s.nextFixturesHandler, apiLimit))
+	mux.Handle("GET /api/standings", authed(s.standingsHandler, apiLimit))
+
+	// Handle the calendar feeds
+	mux.Handle("GET /teams/{team}/fixtures.ics", authed(s.icalHandler, apiLimit))
+
+	// Handle the HTML pages
+	mux.Handle("GET /fixtures", authed(s.fixturesHandler, pageLimit))
+	mux.Handle("GET /results", authed(s.resultsHandler, pageLimit))
+	mux.Handle("GET /teams/{team}", authed(s.teamPageHandler, pageLimit))
+	mux.Handle("GET /table", authed(s.tablePageHandler, pageLimit))
+
+	// Handle the live score stream
+	mux.Handle("GET /api/live", authed(s.liveHandler, liveLimit))
+
+	// Wrap the handlers with the middleware, outermost first
+	return chain(
+		mux,
+		requestIDHandler,
+		accessLogHandler(s.logger),
+		recoverHandler(s.logger),
+		maxBytesHandler(s.maxBody),
+	)
+}
 
-	// Handle the /api/go/ route
-	mux.Handle("/api/go/", http.HandlerFunc(goHandler))
+func main() {
+	// Load the configuration from the command line, environment and config file
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:], os.Getenv)
 
-	// Wrap the handlers with the logHandler function
-	handler := logHandler(mux)
+	// Check for errors
+	if err != nil {
+		fmt.Println("Error loading configuration:", err)
+		return
+	}
 
-	// Create a new server
-	server := http.Server{
-		Addr:    ":8080",
-		Handler: handler,
+	// Hash a password for the users file if asked to
+	if cfg.HashPassword {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fmt.Println("Error reading password:", err)
+			return
+		}
+
+		hash, err := hashPassword(strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			fmt.Println("Error hashing password:", err)
+			return
+		}
+
+		fmt.Println(hash)
+		return
+	}
+
+	// Load the users
+	var users []User
+	if cfg.UsersFile != "" {
+		users, err = loadUsers(cfg.UsersFile)
+
+		// Check for errors
+		if err != nil {
+			fmt.Println("Error loading users:", err)
+			return
+		}
 	}
 
-	// Creare an error variable
-	var err error
+	// Sign sessions with the configured key so that they survive a restart
+	if cfg.SessionKey == "" {
+		fmt.Println("Warning: no session key set, signing sessions with a random key so they won't survive a restart")
+	}
+	auth := newAuthenticator(users, []byte(cfg.SessionKey))
 
-	// Create a new MongoDB test
-	mongo, err = mongodb_test.NewMongoTest()
+	// Parse the templates
+	tmpls, err := newTemplates(cfg.Dev, cfg.location())
 
 	// Check for errors
 	if err != nil {
-		fmt.Println("Error connecting to MongoDB:", err)
+		fmt.Println("Error parsing templates:", err)
 		return
 	}
 
-	// Close the MongoDB connection
-	defer mongo.Close()
+	// Create a structured logger for the access log
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	// Create the store
+	var store MatchStore
+
+	if cfg.DataFile != "" {
+		// Load the matches from the file
+		memory, err := loadMemoryStore(cfg.DataFile)
+
+		// Check for errors
+		if err != nil {
+			fmt.Println("Error loading matches:", err)
+			return
+		}
+
+		fmt.Printf("Loaded %d matches from %s\n", len(memory.matches), cfg.DataFile)
+		memory.location = cfg.location()
+		store = memory
+	} else {
+		// Create a new MongoDB test
+		mongo, err := mongodb_test.NewMongoTest(context.Background(),
+			append(cfg.Mongo.options(), mongodb_test.WithLocation(cfg.location()))...)
+
+		// Check for errors
+		if err != nil {
+			fmt.Println("Error connecting to MongoDB:", err)
+			return
+		}
+
+		// Close the MongoDB connection
+		defer func() {
+			if err := mongo.Close(); err != nil {
+				fmt.Println("Error closing MongoDB:", err)
+			}
+		}()
+
+		// Apply migrations and create missing indexes, giving up rather than never starting if they hang
+		if cfg.Mongo.Migrate {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.MigrateTimeout)
+			versions, err := mongo.Migrate(ctx)
+			cancel()
+
+			if err != nil {
+				fmt.Println("Error migrating MongoDB:", err)
+				return
+			}
+
+			if len(versions) > 0 {
+				fmt.Println("Applied migrations", versions)
+			}
+		}
+
+		store = mongo
+	}
+
+	// Start polling for live scores
+	live := newLiveScores(store, cfg.LiveInterval, logger)
+	liveCtx, stopLive := context.WithCancel(context.Background())
+	defer stopLive()
+
+	go live.run(liveCtx)
+
+	// Cache the handlers' reads, the live scores poll the store directly so they aren't delayed
+	cache := store
+	if cfg.Cache {
+		caching := newCachingStore(store)
+		caching.location = cfg.location()
+		cache = caching
+	}
+
+	// Create a new server
+	srv := newServer(cache, logger, auth, live, tmpls, cfg.Limits, cfg.MaxBody)
+	server := http.Server{
+		Addr:              cfg.Addr,
+		Handler:           srv.routes(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
 
 	// Create a goroutine to listen for signals
 	go func() {
@@ -83,6 +308,13 @@ func main() {
 	// Print the signal
 	fmt.Println("Received Signal:", sig)
 
+	// Fail readiness checks and give the orchestrator time to stop sending traffic before closing the listener
+	srv.shuttingDown.Store(true)
+	time.Sleep(cfg.ShutdownDelay)
+
+	// Stop the live scores so that the open streams finish
+	stopLive()
+
 	// Create a context
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -96,64 +328,23 @@ func main() {
 	fmt.Println("Server stopped gracefully")
 }
 
-// Function to wrap the handlers and print the function name, request method, URL path, and protocol
-func logHandler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		// Print the calling function name, request method, URL path, and protocol
-		fmt.Printf("%s %s %s\n", req.Method, req.URL.Path, req.Proto)
-
-		// Add some data to the request context
-		ctx := req.Context()
-		ctx = context.WithValue(ctx, user, "steve")
-		req = req.WithContext(ctx)
-
-		// Call the next handler
-		next.ServeHTTP(w, req)
-
-		// Write the function name, request method, URL path, and protocol to the response
-		fmt.Fprintf(w, "Request: %s %s %s\n", req.Method, req.URL.Path, req.Proto)
-
-		// Print the query string
-		query := req.URL.Query()
-
-		// Print the query string
-		if len(query) == 0 {
-			fmt.Println("No query string")
-		} else {
-			fmt.Println("Query string:")
-		}
-
-		// Loop through the query string
-		for key, value := range query {
-			fmt.Printf("%s\n", key)
-			for _, v := range value {
-				fmt.Printf("  %s\n", v)
-			}
-		}
-	})
-}
-
 func rootHandler(w http.ResponseWriter, req *http.Request) {
 	// Write the response
 	fmt.Println("Root handler")
 
-	// Print the data from the request context
-	ctx := req.Context()
-	value := ctx.Value(user)
-	fmt.Println("User:", value)
+	// Print the user from the request context
+	fmt.Println("User:", usernameFromContext(req.Context()))
 }
 
 func goHandler(w http.ResponseWriter, req *http.Request) {
 	// Write the response
 	fmt.Println("Go handler")
 
-	// Print the data from the request context
-	ctx := req.Context()
-	value := ctx.Value(user)
-	fmt.Println("User:", value)
+	// Print the user from the request context
+	fmt.Println("User:", usernameFromContext(req.Context()))
 }
 
-func apiHandler(w http.ResponseWriter, req *http.Request) {
+func (s *server) apiHandler(w http.ResponseWriter, req *http.Request) {
 	// Check that the request is for the /api/ route
 	if req.URL.Path != "/api/" {
 		http.NotFound(w, req)
@@ -162,16 +353,7 @@ func apiHandler(w http.ResponseWriter, req *http.Request) {
 
 	fmt.Println("API handler")
 	// Get the matches
-	matches, err := getMatches()
-
-	// Check for errors
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Create a new template
-	tmpl, err := template.ParseFiles("template.html")
+	matches, err := s.getMatches(req.Context())
 
 	// Check for errors
 	if err != nil {
@@ -179,16 +361,12 @@ func apiHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Parse the template
-	if err := tmpl.Execute(w, matches); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	s.templates.render(w, "matches.html", matches)
 }
 
-func getMatches() (models.MatchList, error) {
+func (s *server) getMatches(ctx context.Context) (models.MatchList, error) {
 	// Get the matches
-	matches, err := mongo.GetAllTeamMatches("Liverpool")
+	matches, err := s.store.GetAllTeamMatches(ctx, "Liverpool")
 
 	// Check for errors
 	if err != nil {
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"mongodb-test/models"
+	"mongodb-test/standings"
+)
+
+// testDataFile holds the matches the test server serves
+const testDataFile = "../mongodb-test/testdata/matches.json"
+
+// testNow is the fixed time the test server's store sees, during matchday 4
+var testNow = time.Date(2023, 9, 2, 12, 0, 0, 0, time.UTC)
+
+// newTestStore will load the test matches into a store with a fixed clock
+func newTestStore(t testing.TB) *memoryStore {
+	t.Helper()
+
+	store, err := loadMemoryStore(testDataFile)
+	if err != nil {
+		t.Fatalf("loading %s: %v", testDataFile, err)
+	}
+
+	store.now = func() time.Time { return testNow }
+	store.location = time.UTC
+
+	return store
+}
+
+// newTestServer will create a server over the store with an admin and an ordinary user, both with the password "password"
+func newTestServer(t testing.TB, store MatchStore, limits routeLimits) *server {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := []User{
+		{Username: "admin", PasswordHash: string(hash), Roles: []string{"admin"}},
+		{Username: "fan", PasswordHash: string(hash)},
+	}
+
+	tmpls, err := newTemplates(false, time.UTC)
+	if err != nil {
+		t.Fatalf("parsing templates: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return newServer(store, logger, newAuthenticator(users, []byte("test key")),
+		newLiveScores(store, time.Hour, logger), tmpls, limits, defaultMaxBody)
+}
+
+func TestRoutes(t *testing.T) {
+	handler := newTestServer(t, newTestStore(t), routeLimits{}).routes()
+
+	form := url.Values{"username": {"admin"}, "password": {"password"}}.Encode()
+	badForm := url.Values{"username": {"admin"}, "password": {"wrong"}}.Encode()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		// user logs in with basic auth if set
+		user string
+
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		// Health checks
+		{name: "healthz", path: "/healthz", wantStatus: 200, wantContentType: "application/json", wantBody: `"ok"`},
+		{name: "readyz", path: "/readyz", wantStatus: 200, wantBody: `"ok"`},
+		{name: "version", path: "/version", wantStatus: 200, wantContentType: "application/json"},
+
+		// Legacy routes
+		{name: "root", path: "/", wantStatus: 200},
+		{name: "api page", path: "/api/", wantStatus: 200, wantContentType: "text/html", wantBody: "<h1>Liverpool Matches</h1>"},
+		{name: "api page unknown path", path: "/api/unknown", wantStatus: 404},
+		{name: "go anonymous", path: "/api/go/", wantStatus: 401},
+		{name: "go without role", path: "/api/go/", user: "fan", wantStatus: 403},
+		{name: "go admin", path: "/api/go/", user: "admin", wantStatus: 200},
+
+		// Logging in and out
+		{name: "login", method: "POST", path: "/login", body: form, wantStatus: 200, wantBody: `"admin"`},
+		{name: "login wrong password", method: "POST", path: "/login", body: badForm, wantStatus: 401},
+		{name: "logout", method: "POST", path: "/logout", wantStatus: 204},
+
+		// Matches
+		{name: "matches", path: "/api/matches", wantStatus: 200, wantContentType: "application/json", wantBody: `"total":20`},
+		{name: "matches by team", path: "/api/matches?team=Liverpool&status=finished", wantStatus: 200, wantBody: `"total":3`},
+		{name: "matches by matchday", path: "/api/matches?matchday=1&page_size=2&page=2", wantStatus: 200, wantBody: `"page":2`},
+		{name: "matches invalid from", path: "/api/matches?from=yesterday", wantStatus: 400, wantBody: "invalid from date"},
+		{name: "matches invalid status", path: "/api/matches?status=WON", wantStatus: 400, wantBody: "invalid status"},
+		{name: "matches invalid matchday", path: "/api/matches?matchday=0", wantStatus: 400, wantBody: "invalid matchday"},
+		{name: "matches invalid page", path: "/api/matches?page=0", wantStatus: 400, wantBody: "invalid page"},
+		{name: "match", path: "/api/matches/435943", wantStatus: 200, wantBody: `"id":435943`},
+		{name: "match not found", path: "/api/matches/1", wantStatus: 404, wantBody: "not found"},
+		{name: "match invalid id", path: "/api/matches/abc", wantStatus: 400, wantBody: "invalid match id"},
+
+		// Teams and dates
+		{name: "teams", path: "/api/teams", wantStatus: 200, wantBody: "Liverpool"},
+		{name: "today", path: "/api/today", wantStatus: 200, wantBody: `"id":`},
+		{name: "today in a time zone", path: "/api/today?tz=Europe/London", wantStatus: 200, wantBody: `"id":`},
+		{name: "today invalid time zone", path: "/api/today?tz=Nowhere/Special", wantStatus: 400, wantBody: "invalid tz"},
+		{name: "week", path: "/api/week", wantStatus: 200, wantBody: `"matchday":4`},
+		{name: "week invalid time zone", path: "/api/week?tz=x", wantStatus: 400},
+		{name: "matchday", path: "/api/matchday", wantStatus: 200, wantBody: `"matchday":4`},
+		{name: "matchday invalid time zone", path: "/api/matchday?tz=x", wantStatus: 400},
+		{name: "next fixtures", path: "/api/teams/Liverpool/next?n=2", wantStatus: 200, wantBody: `"matches":[]`},
+		{name: "next fixtures invalid n", path: "/api/teams/Liverpool/next?n=0", wantStatus: 400, wantBody: "invalid n"},
+		{name: "next fixtures n too large", path: "/api/teams/Liverpool/next?n=1000", wantStatus: 400},
+
+		// Standings
+		{name: "standings", path: "/api/standings", wantStatus: 200, wantBody: `"season":1564`},
+		{name: "standings home", path: "/api/standings?venue=home&matchday=2", wantStatus: 200, wantBody: `"venue":"home"`},
+		{name: "standings invalid venue", path: "/api/standings?venue=neutral", wantStatus: 400, wantBody: "invalid venue"},
+		{name: "standings invalid season", path: "/api/standings?season=-1", wantStatus: 400, wantBody: "invalid season"},
+
+		// Calendar feeds
+		{name: "calendar", path: "/teams/Liverpool/fixtures.ics", wantStatus: 200, wantContentType: "text/calendar", wantBody: "BEGIN:VCALENDAR"},
+		{name: "calendar unknown team", path: "/teams/Nobody/fixtures.ics", wantStatus: 404},
+
+		// Pages
+		{name: "fixtures page", path: "/fixtures", wantStatus: 200, wantContentType: "text/html"},
+		{name: "results page", path: "/results", wantStatus: 200, wantContentType: "text/html", wantBody: "Liverpool"},
+		{name: "team page", path: "/teams/Liverpool", wantStatus: 200, wantContentType: "text/html", wantBody: "Liverpool FC"},
+		{name: "team page unknown team", path: "/teams/Nobody", wantStatus: 404},
+		{name: "table page", path: "/table", wantStatus: 200, wantContentType: "text/html", wantBody: "Arsenal"},
+		{name: "table page invalid matchday", path: "/table?matchday=x", wantStatus: 400},
+
+		// Other methods fall through to the /api/ page, which only serves its own path
+		{name: "matches wrong method", method: "POST", path: "/api/matches", wantStatus: 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			req := httptest.NewRequest(method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, "password")
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s %s status = %d, want %d, body: %s", method, tt.path, rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.wantContentType) {
+				t.Errorf("%s %s Content-Type = %q, want %q", method, tt.path, ct, tt.wantContentType)
+			}
+
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("%s %s body doesn't contain %q: %s", method, tt.path, tt.wantBody, rec.Body)
+			}
+
+			if rec.Header().Get(requestIDHeader) == "" {
+				t.Errorf("%s %s has no %s header", method, tt.path, requestIDHeader)
+			}
+		})
+	}
+}
+
+func TestRoutesNotModified(t *testing.T) {
+	handler := newTestServer(t, newTestStore(t), routeLimits{}).routes()
+
+	for _, path := range []string{"/api/matches", "/api/matches/435943", "/api/today", "/teams/Liverpool/fixtures.ics"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		etag := rec.Header().Get("ETag")
+		if rec.Code != http.StatusOK || etag == "" {
+			t.Fatalf("GET %s status = %d, ETag = %q", path, rec.Code, etag)
+		}
+
+		// Asking again with the ETag returns no body
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("If-None-Match", etag)
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("GET %s with If-None-Match status = %d, body = %q, want 304 and no body", path, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestMatchesPages(t *testing.T) {
+	store := newTestStore(t)
+	handler := newTestServer(t, store, routeLimits{}).routes()
+
+	// Every match, in kick off order
+	var want []int
+	for _, match := range store.matches {
+		want = append(want, match.Id)
+	}
+
+	var got []int
+	etags := make(map[string]int)
+	for page := 1; page <= 4; page++ {
+		path := fmt.Sprintf("/api/matches?page_size=7&page=%d", page)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, body: %s", path, rec.Code, rec.Body)
+		}
+
+		// The page uses the same camelCase names as the matches
+		for _, field := range []string{`"pageSize":7`, `"totalPages":3`, `"total":20`, fmt.Sprintf(`"page":%d`, page)} {
+			if !strings.Contains(rec.Body.String(), field) {
+				t.Errorf("GET %s body doesn't contain %s: %s", path, field, rec.Body)
+			}
+		}
+
+		var body matchPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+
+		wantLen := []int{7, 7, 6, 0}[page-1]
+		if len(body.Matches) != wantLen {
+			t.Errorf("GET %s returned %d matches, want %d", path, len(body.Matches), wantLen)
+		}
+		for _, match := range body.Matches {
+			got = append(got, match.Id)
+		}
+
+		// Each page has its own ETag
+		etag := rec.Header().Get("ETag")
+		if other, ok := etags[etag]; ok {
+			t.Errorf("pages %d and %d have the same ETag %s", other, page, etag)
+		}
+		etags[etag] = page
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("pages held %v, want every match once in kick off order %v", got, want)
+	}
+
+	// Past the last page is empty rather than an error, even far past it
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/matches?page=9223372036854775807", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"matches":[]`) {
+		t.Errorf("GET far past the last page status = %d, body: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestStandingsRoute(t *testing.T) {
+	handler := newTestServer(t, newTestStore(t), routeLimits{}).routes()
+
+	tests := []struct {
+		name string
+		path string
+		// wantTop is the short names of the first teams in the table
+		wantTop []string
+		// wantLiverpool is Liverpool's row, ignoring the team and position
+		wantLiverpool standings.Row
+	}{
+		{
+			// Man City, Liverpool and Tottenham are level on points, so goal difference decides
+			name:          "whole season",
+			path:          "/api/standings",
+			wantTop:       []string{"Man City", "Liverpool", "Tottenham"},
+			wantLiverpool: standings.Row{Played: 3, Won: 2, Drawn: 1, GoalsFor: 6, GoalsAgainst: 2, GoalDifference: 4, Points: 7},
+		},
+		{
+			name:          "after matchday 1",
+			path:          "/api/standings?matchday=1",
+			wantTop:       []string{"Man City", "Arsenal", "Man United"},
+			wantLiverpool: standings.Row{Played: 1, Drawn: 1, GoalsFor: 1, GoalsAgainst: 1, Points: 1},
+		},
+		{
+			name:          "home matches after matchday 2",
+			path:          "/api/standings?venue=home&matchday=2",
+			wantTop:       []string{"Liverpool", "Man City", "Arsenal"},
+			wantLiverpool: standings.Row{Played: 1, Won: 1, GoalsFor: 3, GoalsAgainst: 1, GoalDifference: 2, Points: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET %s status = %d, body: %s", tt.path, rec.Code, rec.Body)
+			}
+
+			var body standingsResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("GET %s: %v", tt.path, err)
+			}
+
+			if body.Season != 1564 {
+				t.Errorf("GET %s season = %d, want 1564", tt.path, body.Season)
+			}
+
+			// Every team is in the table, positioned in points order
+			if len(body.Table) != 10 {
+				t.Fatalf("GET %s table has %d rows, want 10", tt.path, len(body.Table))
+			}
+			for i, row := range body.Table {
+				if row.Position != i+1 {
+					t.Errorf("GET %s row %d has position %d", tt.path, i, row.Position)
+				}
+				if i > 0 && row.Points > body.Table[i-1].Points {
+					t.Errorf("GET %s %s has more points than %s above it", tt.path, row.Team.ShortName, body.Table[i-1].Team.ShortName)
+				}
+			}
+
+			var top []string
+			for _, row := range body.Table[:len(tt.wantTop)] {
+				top = append(top, row.Team.ShortName)
+			}
+			if !slices.Equal(top, tt.wantTop) {
+				t.Errorf("GET %s top of the table = %v, want %v", tt.path, top, tt.wantTop)
+			}
+
+			for _, row := range body.Table {
+				if row.Team.ShortName != "Liverpool" {
+					continue
+				}
+
+				row.Position, row.Team = 0, models.Team{}
+				if row != tt.wantLiverpool {
+					t.Errorf("GET %s Liverpool = %+v, want %+v", tt.path, row, tt.wantLiverpool)
+				}
+			}
+		})
+	}
+}
+
+func TestLiveRoute(t *testing.T) {
+	store := newTestStore(t)
+	s := newTestServer(t, store, routeLimits{})
+
+	// Poll once so that the stream starts with today's matches
+	s.live.poll(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/live", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	// The stream ends when the request's context does
+	s.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /api/live status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("GET /api/live Content-Type = %q, want text/event-stream", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "event: match\ndata: {") {
+		t.Errorf("GET /api/live sent no match events: %q", rec.Body)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"mongodb-test"
+	"mongodb-test/standings"
+)
+
+// standingsResponse is the body returned by /api/standings
+type standingsResponse struct {
+	Season   int             `json:"season"`
+	Matchday int             `json:"matchday,omitempty"`
+	Venue    string          `json:"venue"`
+	Table    standings.Table `json:"table"`
+}
+
+// parseStandingsOptions will read the season, matchday and venue from the query string
+func parseStandingsOptions(query url.Values) (standings.Options, error) {
+	var opts standings.Options
+	var err error
+
+	opts.Season, err = parsePositiveInt(query.Get("season"), 0)
+	if err != nil {
+		return opts, fmt.Errorf("invalid season: %q", query.Get("season"))
+	}
+
+	opts.Matchday, err = parsePositiveInt(query.Get("matchday"), 0)
+	if err != nil {
+		return opts, fmt.Errorf("invalid matchday: %q", query.Get("matchday"))
+	}
+
+	switch query.Get("venue") {
+	case "", "all":
+		opts.Venue = standings.All
+	case "home":
+		opts.Venue = standings.Home
+	case "away":
+		opts.Venue = standings.Away
+	default:
+		return opts, fmt.Errorf("invalid venue: %q", query.Get("venue"))
+	}
+
+	return opts, nil
+}
+
+// standings will compute the table for the options, defaulting to the latest season
+func (s *server) standings(ctx context.Context, opts standings.Options) (standingsResponse, error) {
+	// Get every match, the table needs the whole season
+	matches, err := s.store.GetMatches(ctx, mongodb_test.MatchFilter{})
+	if err != nil {
+		return standingsResponse{}, err
+	}
+
+	if opts.Season == 0 {
+		opts.Season = standings.LatestSeason(matches.Matches)
+	}
+
+	return standingsResponse{
+		Season:   opts.Season,
+		Matchday: opts.Matchday,
+		Venue:    opts.Venue.String(),
+		Table:    standings.Compute(matches.Matches, opts),
+	}, nil
+}
+
+// standingsHandler handles GET /api/standings?season=&matchday=&venue=all|home|away
+func (s *server) standingsHandler(w http.ResponseWriter, req *http.Request) {
+	// Parse the options from the query string
+	opts, err := parseStandingsOptions(req.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// Compute the table
+	table, err := s.standings(req.Context(), opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting matches")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, table)
+}
+
+// tablePageHandler handles GET /table, rendering the standings as a page
+func (s *server) tablePageHandler(w http.ResponseWriter, req *http.Request) {
+	// Parse the options from the query string
+	opts, err := parseStandingsOptions(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Compute the table
+	table, err := s.standings(req.Context(), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.templates.render(w, "table.html", table)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"mongodb-test"
+	"mongodb-test/models"
+)
+
+// MatchStore is the set of match queries the server needs
+// *mongodb_test.MongoTest implements it against MongoDB and memoryStore implements it in memory
+type MatchStore interface {
+	GetOneMatch(ctx context.Context, homeTeam, awayTeam string) (models.Match, error)
+	GetAllTeamMatches(ctx context.Context, team string) (models.MatchList, error)
+	GetTodaysMatches(ctx context.Context, loc *time.Location) (models.MatchList, error)
+	GetThisWeeksMatches(ctx context.Context, loc *time.Location) (models.MatchList, error)
+	GetThisMatchday(ctx context.Context, loc *time.Location) (models.MatchList, error)
+	GetNextFixtures(ctx context.Context, team string, n int) (models.MatchList, error)
+	GetMatch(ctx context.Context, id int) (models.Match, error)
+	GetMatches(ctx context.Context, filter mongodb_test.MatchFilter) (models.MatchList, error)
+	GetTeams(ctx context.Context) ([]models.Team, error)
+	FindMatches(ctx context.Context, q mongodb_test.Query, fn func(models.Match) error) error
+	CountMatches(ctx context.Context, q mongodb_test.Query) (int, error)
+}
+
+// collectMatches will return every match selected by the query
+func collectMatches(ctx context.Context, store MatchStore, q mongodb_test.Query) ([]models.Match, error) {
+	var matches []models.Match
+
+	err := store.FindMatches(ctx, q, func(match models.Match) error {
+		matches = append(matches, match)
+		return nil
+	})
+
+	return matches, err
+}
+
+// memoryStore holds a fixed set of matches in memory
+type memoryStore struct {
+	// matches are held in kick off order
+	matches []models.Match
+	// now returns the current time, it can be replaced to fix the date used for today's matches
+	now func() time.Time
+	// location is the time zone days and weeks are counted in when no other is given
+	location *time.Location
+}
+
+// newMemoryStore will create a store holding the given matches
+func newMemoryStore(matches []models.Match) *memoryStore {
+	// Sort a copy of the matches into kick off order, the same order GetMatches uses
+	sorted := slices.Clone(matches)
+	slices.SortStableFunc(sorted, func(a, b models.Match) int {
+		return cmp.Or(a.UtcDate.Compare(b.UtcDate), cmp.Compare(a.Id, b.Id))
+	})
+
+	return &memoryStore{
+		matches:  sorted,
+		now:      time.Now,
+		location: time.Local,
+	}
+}
+
+// loadMemoryStore will create a store from a JSON file in the format returned by football-data.org
+func loadMemoryStore(path string) (*memoryStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var matchList models.MatchList
+	err = json.Unmarshal(data, &matchList)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMemoryStore(matchList.Matches), nil
+}
+
+// GetOneMatch will return the match between the home and away team
+func (s *memoryStore) GetOneMatch(ctx context.Context, homeTeam, awayTeam string) (models.Match, error) {
+	return s.findOne(mongodb_test.NewQuery().HomeTeam(homeTeam).AwayTeam(awayTeam))
+}
+
+// GetAllTeamMatches will return every match the team plays in
+func (s *memoryStore) GetAllTeamMatches(ctx context.Context, team string) (models.MatchList, error) {
+	return s.GetMatches(ctx, mongodb_test.MatchFilter{Team: team})
+}
+
+// locationOr will return loc, or the store's time zone if loc is nil
+func (s *memoryStore) locationOr(loc *time.Location) *time.Location {
+	if loc == nil {
+		return s.location
+	}
+
+	return loc
+}
+
+// GetTodaysMatches will return the matches kicking off today in loc, or in the store's time zone if loc is nil
+func (s *memoryStore) GetTodaysMatches(ctx context.Context, loc *time.Location) (models.MatchList, error) {
+	return s.find(mongodb_test.NewQuery().Day(s.now(), s.locationOr(loc))), nil
+}
+
+// GetThisWeeksMatches will return the matches kicking off from Monday to Sunday this week in loc, or in the store's time zone if loc is nil
+func (s *memoryStore) GetThisWeeksMatches(ctx context.Context, loc *time.Location) (models.MatchList, error) {
+	return s.find(mongodb_test.NewQuery().Week(s.now(), s.locationOr(loc))), nil
+}
+
+// GetThisMatchday will return the matches of the current matchday, counting days in loc, or in the store's time zone if loc is nil
+func (s *memoryStore) GetThisMatchday(ctx context.Context, loc *time.Location) (models.MatchList, error) {
+	return mongodb_test.FindThisMatchday(ctx, s, s.now(), s.locationOr(loc))
+}
+
+// GetNextFixtures will return the next n matches the team plays in that are yet to kick off, n must be greater than zero
+func (s *memoryStore) GetNextFixtures(ctx context.Context, team string, n int) (models.MatchList, error) {
+	// A limit of zero would return every match rather than none
+	if n <= 0 {
+		return models.MatchList{}, fmt.Errorf("next fixtures: n must be greater than zero, got %d", n)
+	}
+
+	return s.find(mongodb_test.NewQuery().Team(team).Upcoming(s.now()).Limit(n)), nil
+}
+
+// GetMatch will return the match with the given id
+func (s *memoryStore) GetMatch(ctx context.Context, id int) (models.Match, error) {
+	return s.findOne(mongodb_test.NewQuery().IDs(id))
+}
+
+// GetMatches will return the matches selected by the filter in kick off order
+func (s *memoryStore) GetMatches(ctx context.Context, filter mongodb_test.MatchFilter) (models.MatchList, error) {
+	return s.find(filter.Query()), nil
+}
+
+// find will return the matches selected by the query
+func (s *memoryStore) find(q mongodb_test.Query) models.MatchList {
+	return models.MatchList{Matches: q.Apply(s.matches)}
+}
+
+// FindMatches will call fn with each match selected by the query, in the query's order
+func (s *memoryStore) FindMatches(ctx context.Context, q mongodb_test.Query, fn func(models.Match) error) error {
+	for _, match := range q.Apply(s.matches) {
+		if err := fn(match); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CountMatches will return the number of matches selected by the query, ignoring its skip and limit
+func (s *memoryStore) CountMatches(ctx context.Context, q mongodb_test.Query) (int, error) {
+	return len(q.Skip(0).Limit(0).Apply(s.matches)), nil
+}
+
+// findOne will return the first match selected by the query, or ErrNotFound
+func (s *memoryStore) findOne(q mongodb_test.Query) (models.Match, error) {
+	matches := q.Limit(1).Apply(s.matches)
+	if len(matches) == 0 {
+		return models.Match{}, mongodb_test.ErrNotFound
+	}
+
+	return matches[0], nil
+}
+
+// GetTeams will return every team that plays at home, sorted by name
+func (s *memoryStore) GetTeams(ctx context.Context) ([]models.Team, error) {
+	seen := make(map[int]bool)
+	teams := []models.Team{}
+
+	for _, match := range s.matches {
+		if !seen[match.HomeTeam.Id] {
+			seen[match.HomeTeam.Id] = true
+			teams = append(teams, match.HomeTeam)
+		}
+	}
+
+	slices.SortFunc(teams, func(a, b models.Team) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return teams, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"mongodb-test/models"
+)
+
+// loadLocation will load the time zone, skipping the test if there is no time zone database
+func loadLocation(t testing.TB, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	return loc
+}
+
+// matchIDs will return the ids of the matches in order
+func matchIDs(matchList models.MatchList) []int {
+	var ids []int
+	for _, match := range matchList.Matches {
+		ids = append(ids, match.Id)
+	}
+	return ids
+}
+
+func TestMemoryStoreCalendar(t *testing.T) {
+	tokyo := loadLocation(t, "Asia/Tokyo")
+
+	// Days and weeks are counted in Tokyo unless the request names another time zone
+	store := newTestStore(t)
+	store.location = tokyo
+
+	type method func(context.Context, *time.Location) (models.MatchList, error)
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		method method
+		loc    *time.Location
+		want   []int
+	}{
+		{"today", time.Date(2023, 8, 13, 14, 0, 0, 0, time.UTC), store.GetTodaysMatches, time.UTC, []int{435946, 435947}},
+		// 23:00 on Sunday in Tokyo, the last match of the weekend kicks off at 00:30 on Monday
+		{"today in the store's zone", time.Date(2023, 8, 13, 14, 0, 0, 0, time.UTC), store.GetTodaysMatches, nil, []int{435946}},
+		{"today after midnight in the store's zone", time.Date(2023, 8, 13, 15, 0, 0, 0, time.UTC), store.GetTodaysMatches, nil, []int{435947}},
+		{"today in a named zone", time.Date(2023, 8, 13, 15, 0, 0, 0, time.UTC), store.GetTodaysMatches, tokyo, []int{435947}},
+		{"this week", time.Date(2023, 8, 13, 15, 0, 0, 0, time.UTC), store.GetThisWeeksMatches, time.UTC, []int{435943, 435944, 435945, 435946, 435947}},
+		{"last minute of the week in the store's zone", time.Date(2023, 8, 13, 14, 59, 0, 0, time.UTC), store.GetThisWeeksMatches, nil, []int{435943, 435944, 435945, 435946}},
+		{"first minute of the week in the store's zone", time.Date(2023, 8, 13, 15, 0, 0, 0, time.UTC), store.GetThisWeeksMatches, nil, []int{435947, 435948, 435949, 435950, 435951}},
+		// Matchday 3 is over in UTC, but its last match kicked off on Monday in Tokyo, which is still today
+		{"this matchday", time.Date(2023, 8, 28, 0, 30, 0, 0, time.UTC), store.GetThisMatchday, time.UTC, []int{435958, 435959, 435960, 435961, 435962}},
+		{"this matchday in the store's zone", time.Date(2023, 8, 28, 0, 30, 0, 0, time.UTC), store.GetThisMatchday, nil, []int{435953, 435954, 435955, 435956, 435957}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.now = func() time.Time { return tt.now }
+
+			matchList, err := tt.method(context.Background(), tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := matchIDs(matchList); !slices.Equal(got, tt.want) {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreNextFixtures(t *testing.T) {
+	// The day before Liverpool's only fixture left to play
+	store := newTestStore(t)
+	store.now = func() time.Time { return time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		n       int
+		want    []int
+		wantErr bool
+	}{
+		{n: 1, want: []int{435958}},
+		{n: 5, want: []int{435958}},
+		// A limit of zero would be every match, so it isn't allowed
+		{n: 0, wantErr: true},
+		{n: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		matchList, err := store.GetNextFixtures(context.Background(), "Liverpool", tt.n)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("GetNextFixtures with n = %d returned %v, want an error", tt.n, matchIDs(matchList))
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("GetNextFixtures with n = %d: %v", tt.n, err)
+		}
+		if got := matchIDs(matchList); !slices.Equal(got, tt.want) {
+			t.Errorf("GetNextFixtures with n = %d = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
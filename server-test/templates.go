@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"mongodb-test/models"
+)
+
+// templateDir is the directory holding the templates, embedded in the binary and read from disk in dev mode
+const templateDir = "templates"
+
+// kickoffFormat is the layout used to show kick off times
+const kickoffFormat = "Mon 2 Jan 15:04"
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// templateFuncs will return the helper functions available to every template
+// Kick off times are shown in loc
+func templateFuncs(loc *time.Location) template.FuncMap {
+	return template.FuncMap{
+		"kickoff": func(t time.Time) string {
+			return kickoff(t, loc)
+		},
+		"started":     started,
+		"statusBadge": statusBadge,
+	}
+}
+
+// kickoff will format a kick off time in the time zone
+func kickoff(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(kickoffFormat)
+}
+
+// started will return true if a match with the status has a score to show
+func started(status models.MatchStatus) bool {
+	switch status {
+	case models.InPlay, models.Paused, models.Finished, models.Suspended, models.Awarded:
+		return true
+	default:
+		return false
+	}
+}
+
+// statusBadge will return a badge showing the status, styled by the layout
+func statusBadge(status models.MatchStatus) template.HTML {
+	return template.HTML(fmt.Sprintf(
+		`<span class="status badge badge-%s">%s</span>`,
+		template.HTMLEscapeString(strings.ToLower(string(status))),
+		template.HTMLEscapeString(status.String()),
+	))
+}
+
+// templates holds a parsed template for each page, built from the layout, the partials and the page
+type templates struct {
+	// fsys holds the layout.html, partials and pages
+	fsys fs.FS
+	// dev reparses the pages on every render so that edits show without a restart
+	dev bool
+	// funcs are the helper functions available to every page
+	funcs template.FuncMap
+	// pages are the parsed pages by file name
+	pages map[string]*template.Template
+}
+
+// newTemplates will parse the templates embedded in the binary, showing times in loc
+// In dev mode they are read from the templates directory on disk instead and reparsed on every render
+func newTemplates(dev bool, loc *time.Location) (*templates, error) {
+	var fsys fs.FS
+	if dev {
+		fsys = os.DirFS(templateDir)
+	} else {
+		var err error
+		fsys, err = fs.Sub(embeddedTemplates, templateDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t := &templates{
+		fsys:  fsys,
+		dev:   dev,
+		funcs: templateFuncs(loc),
+	}
+
+	// Parse the pages now so that mistakes are found at startup, even in dev mode
+	pages, err := t.parse()
+	if err != nil {
+		return nil, err
+	}
+	t.pages = pages
+
+	return t, nil
+}
+
+// parse will parse every page along with the layout and partials
+func (t *templates) parse() (map[string]*template.Template, error) {
+	names, err := fs.Glob(t.fsys, "pages/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make(map[string]*template.Template)
+
+	for _, name := range names {
+		// Parse the page last so that its definitions replace the layout's defaults
+		tmpl, err := template.New(name).Funcs(t.funcs).ParseFS(t.fsys, "layout.html", "partials/*.html", name)
+		if err != nil {
+			return nil, err
+		}
+
+		pages[strings.TrimPrefix(name, "pages/")] = tmpl
+	}
+
+	return pages, nil
+}
+
+// render will execute the page's layout with the data and write it to the response
+// The page is rendered to a buffer first so that a template error can still send a 500
+func (t *templates) render(w http.ResponseWriter, page string, data any) {
+	pages := t.pages
+
+	// Pick up any edits in dev mode
+	if t.dev {
+		var err error
+		pages, err = t.parse()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tmpl, ok := pages[page]
+	if !ok {
+		http.Error(w, "unknown page "+page, http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "layout", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"mongodb-test/models"
+)
+
+func TestKickoff(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	tests := []struct {
+		t    time.Time
+		loc  *time.Location
+		want string
+	}{
+		{time.Date(2023, 8, 12, 11, 30, 0, 0, time.UTC), time.UTC, "Sat 12 Aug 11:30"},
+		// British Summer Time
+		{time.Date(2023, 8, 12, 11, 30, 0, 0, time.UTC), london, "Sat 12 Aug 12:30"},
+		// A late kick off falls on the next day in London
+		{time.Date(2023, 8, 12, 23, 30, 0, 0, time.UTC), london, "Sun 13 Aug 00:30"},
+		// Greenwich Mean Time
+		{time.Date(2023, 12, 2, 15, 0, 0, 0, time.UTC), london, "Sat 2 Dec 15:00"},
+	}
+
+	for _, tt := range tests {
+		if got := kickoff(tt.t, tt.loc); got != tt.want {
+			t.Errorf("kickoff(%v, %v) = %q, want %q", tt.t, tt.loc, got, tt.want)
+		}
+	}
+}
+
+func TestRenderUsesLocation(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	tmpls, err := newTemplates(false, tokyo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches := models.MatchList{Matches: []models.Match{{
+		Id:      1,
+		UtcDate: time.Date(2023, 8, 12, 20, 0, 0, 0, time.UTC),
+		Status:  models.Timed,
+	}}}
+
+	rec := httptest.NewRecorder()
+	tmpls.render(rec, "fixtures.html", matches)
+
+	if want := "Sun 13 Aug 05:00"; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("rendered page doesn't show the kick off in Tokyo time %q:\n%s", want, rec.Body)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// authTimeout is how long a peer has to complete the authentication handshake
+const authTimeout = 10 * time.Second
+
+// nonceLength is the length of the random challenge sent in hmac mode
+const nonceLength = 32
+
+// Create an enumeration of the authentication modes
+const (
+	// authNone accepts every peer
+	authNone = ""
+	// authToken expects the client to present the shared secret
+	authToken = "token"
+	// authHMAC sends the client a random challenge and expects an HMAC of it keyed by the shared secret
+	authHMAC = "hmac"
+)
+
+// errAuthFailed is returned when a peer fails to authenticate
+var errAuthFailed = errors.New("authentication failed")
+
+// authConfig describes how peers authenticate
+type authConfig struct {
+	// Mode is one of authNone, authToken or authHMAC
+	Mode string
+	// Secret is the pre-shared secret
+	Secret string
+	// Identity is the name a client authenticates as
+	Identity string
+}
+
+// validate will check that the config can be used
+func (a authConfig) validate() error {
+	switch a.Mode {
+	case authNone:
+		return nil
+	case authToken, authHMAC:
+		if a.Secret == "" {
+			return fmt.Errorf("auth mode %s needs a secret", a.Mode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown auth mode: %s", a.Mode)
+	}
+}
+
+// authPayload will create the data for an auth message
+func authPayload(identity string, proof []byte) []byte {
+	return append([]byte(identity+"\n"), proof...)
+}
+
+// authProof will create the HMAC of the challenge and identity keyed by the secret
+func authProof(secret string, nonce []byte, identity string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(nonce)
+	mac.Write([]byte(identity))
+
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// authenticate will run the server side of the handshake, returning the identity of the peer
+// Peers that fail are sent a close message with the reason
+func authenticate(conn net.Conn, config authConfig) (string, error) {
+	if config.Mode == authNone {
+		return "", nil
+	}
+
+	// Don't let a peer hold the connection open without authenticating
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// Send a challenge in hmac mode
+	var nonce []byte
+	if config.Mode == authHMAC {
+		nonce = make([]byte, nonceLength)
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return "", err
+		}
+
+		err = writeMessage(conn, Message{Type: challenge, Length: nonceLength, Data: nonce})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The first message back must be an auth
+	message, err := readMessage(conn)
+	if err != nil {
+		return "", err
+	}
+
+	if message.Type != auth {
+		rejectPeer(conn, "expected auth")
+		return "", errAuthFailed
+	}
+
+	// Split the identity from the proof
+	identity, proof, ok := bytes.Cut(message.Data, []byte("\n"))
+	if !ok || len(identity) == 0 {
+		rejectPeer(conn, "malformed auth")
+		return "", errAuthFailed
+	}
+
+	// Check the proof in constant time
+	var expected []byte
+	if config.Mode == authHMAC {
+		expected = authProof(config.Secret, nonce, string(identity))
+	} else {
+		expected = []byte(config.Secret)
+	}
+
+	if subtle.ConstantTimeCompare(proof, expected) != 1 {
+		rejectPeer(conn, "invalid credentials")
+		return "", errAuthFailed
+	}
+
+	// Tell the peer it can carry on
+	err = writeMessage(conn, Message{Type: authOK})
+	if err != nil {
+		return "", err
+	}
+
+	return string(identity), nil
+}
+
+// peerIdentity will return the authenticated identity of the other end of a connection, if there is one
+func peerIdentity(conn net.Conn) string {
+	p, ok := conn.(*peer)
+	if !ok {
+		return ""
+	}
+
+	return p.identity
+}
+
+// rejectPeer will tell a peer why it has been rejected
+func rejectPeer(conn net.Conn, reason string) {
+	writeMessage(conn, Message{Type: close, Length: uint16(len(reason)), Data: []byte(reason)})
+}
+
+// login will run the client side of the handshake
+func login(conn net.Conn, config authConfig) error {
+	if config.Mode == authNone {
+		return nil
+	}
+
+	// Don't wait forever for a server that isn't expecting us to authenticate
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// Work out the proof, waiting for the challenge in hmac mode
+	proof := []byte(config.Secret)
+	if config.Mode == authHMAC {
+		message, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+
+		if message.Type != challenge || len(message.Data) != nonceLength {
+			return errors.New("expected an auth challenge")
+		}
+
+		proof = authProof(config.Secret, message.Data, config.Identity)
+	}
+
+	data := authPayload(config.Identity, proof)
+	err := writeMessage(conn, Message{Type: auth, Length: uint16(len(data)), Data: data})
+	if err != nil {
+		return err
+	}
+
+	// Wait for the server to accept or reject us
+	message, err := readMessage(conn)
+	if err != nil {
+		return err
+	}
+
+	switch message.Type {
+	case authOK:
+		return nil
+	case close:
+		return fmt.Errorf("%w: %s", errAuthFailed, message.Data)
+	default:
+		return errors.New("unexpected reply to auth")
+	}
+}
+
+// dial will connect to the server and authenticate
+func dial(addr string, config authConfig) (*peer, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = login(conn, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return newPeer(conn), nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// authResult is the outcome of the server side of a handshake
+type authResult struct {
+	identity string
+	err      error
+}
+
+// handshake will run both sides of the handshake over a pipe
+func handshake(t *testing.T, server, client authConfig) (authResult, error) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	results := make(chan authResult, 1)
+	go func() {
+		identity, err := authenticate(serverConn, server)
+		results <- authResult{identity, err}
+
+		// Let the client read a rejection before the pipe closes
+		serverConn.Close()
+	}()
+
+	err := login(clientConn, client)
+	clientConn.Close()
+
+	return <-results, err
+}
+
+func TestAuthHandshake(t *testing.T) {
+	tests := []struct {
+		name         string
+		server       authConfig
+		client       authConfig
+		wantIdentity string
+		wantErr      bool
+	}{
+		{
+			name:   "no authentication",
+			server: authConfig{},
+			client: authConfig{Identity: "alice"},
+		},
+		{
+			name:         "token accepted",
+			server:       authConfig{Mode: authToken, Secret: "s3cret"},
+			client:       authConfig{Mode: authToken, Secret: "s3cret", Identity: "alice"},
+			wantIdentity: "alice",
+		},
+		{
+			name:    "token rejected",
+			server:  authConfig{Mode: authToken, Secret: "s3cret"},
+			client:  authConfig{Mode: authToken, Secret: "guess", Identity: "alice"},
+			wantErr: true,
+		},
+		{
+			name:         "hmac accepted",
+			server:       authConfig{Mode: authHMAC, Secret: "s3cret"},
+			client:       authConfig{Mode: authHMAC, Secret: "s3cret", Identity: "alice"},
+			wantIdentity: "alice",
+		},
+		{
+			name:    "hmac wrong secret",
+			server:  authConfig{Mode: authHMAC, Secret: "s3cret"},
+			client:  authConfig{Mode: authHMAC, Secret: "guess", Identity: "alice"},
+			wantErr: true,
+		},
+		{
+			name:    "no identity",
+			server:  authConfig{Mode: authHMAC, Secret: "s3cret"},
+			client:  authConfig{Mode: authHMAC, Secret: "s3cret"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := handshake(t, tt.server, tt.client)
+
+			if tt.wantErr {
+				if !errors.Is(result.err, errAuthFailed) {
+					t.Errorf("authenticate error = %v, want %v", result.err, errAuthFailed)
+				}
+				if err == nil {
+					t.Error("login succeeded, want an error")
+				}
+				return
+			}
+
+			if result.err != nil || err != nil {
+				t.Fatalf("authenticate error = %v, login error = %v, want neither", result.err, err)
+			}
+			if result.identity != tt.wantIdentity {
+				t.Errorf("identity = %q, want %q", result.identity, tt.wantIdentity)
+			}
+		})
+	}
+}
+
+func TestAuthWrongSecretReason(t *testing.T) {
+	server := authConfig{Mode: authHMAC, Secret: "s3cret"}
+	client := authConfig{Mode: authHMAC, Secret: "guess", Identity: "alice"}
+
+	_, err := handshake(t, server, client)
+
+	if !errors.Is(err, errAuthFailed) || !strings.Contains(err.Error(), "invalid credentials") {
+		t.Errorf("login error = %v, want invalid credentials", err)
+	}
+}
+
+// presentAuth will answer the server's challenge with the given auth payload, returning the challenge and the reply
+// If payload is nil the proof is worked out from the challenge with the secret
+func presentAuth(t *testing.T, config authConfig, secret, identity string, payload []byte) (nonce []byte, reply Message, result authResult) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	results := make(chan authResult, 1)
+	go func() {
+		identity, err := authenticate(serverConn, config)
+		results <- authResult{identity, err}
+	}()
+
+	message, err := readMessage(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != challenge {
+		t.Fatalf("got %v, want a challenge", message)
+	}
+	nonce = message.Data
+
+	if payload == nil {
+		payload = authPayload(identity, authProof(secret, nonce, identity))
+	}
+
+	err = writeMessage(clientConn, Message{Type: auth, Length: uint16(len(payload)), Data: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err = readMessage(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return nonce, reply, <-results
+}
+
+func TestAuthHMACReplay(t *testing.T) {
+	config := authConfig{Mode: authHMAC, Secret: "s3cret"}
+
+	// Record a successful handshake
+	firstNonce, reply, result := presentAuth(t, config, "s3cret", "alice", nil)
+	if reply.Type != authOK || result.err != nil {
+		t.Fatalf("first handshake got %v, error %v, want authOK", reply, result.err)
+	}
+	recorded := authPayload("alice", authProof("s3cret", firstNonce, "alice"))
+
+	// Replaying it on a new connection fails because the challenge is different
+	secondNonce, reply, result := presentAuth(t, config, "", "", recorded)
+
+	if bytes.Equal(firstNonce, secondNonce) {
+		t.Error("server sent the same challenge twice")
+	}
+	if reply.Type != close || string(reply.Data) != "invalid credentials" {
+		t.Errorf("replay got %v, want close with invalid credentials", reply)
+	}
+	if !errors.Is(result.err, errAuthFailed) {
+		t.Errorf("authenticate error = %v, want %v", result.err, errAuthFailed)
+	}
+}
+
+func TestAuthHMACBindsIdentity(t *testing.T) {
+	config := authConfig{Mode: authHMAC, Secret: "s3cret"}
+
+	// A proof made for alice can't be presented as mallory
+	nonce, reply, _ := presentAuth(t, config, "s3cret", "alice", nil)
+	if reply.Type != authOK {
+		t.Fatalf("got %v, want authOK", reply)
+	}
+
+	_, reply, result := presentAuth(t, config, "", "", authPayload("mallory", authProof("s3cret", nonce, "alice")))
+	if reply.Type != close || !errors.Is(result.err, errAuthFailed) {
+		t.Errorf("got %v, error %v, want mallory rejected", reply, result.err)
+	}
+}
+
+func TestAuthExpectsAuthFirst(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	results := make(chan authResult, 1)
+	go func() {
+		identity, err := authenticate(serverConn, authConfig{Mode: authToken, Secret: "s3cret"})
+		results <- authResult{identity, err}
+	}()
+
+	// Skip the handshake and send a ping
+	go writeMessage(clientConn, Message{Type: ping, Length: 2, Data: []byte("hi")})
+
+	reply, err := readMessage(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != close || string(reply.Data) != "expected auth" {
+		t.Errorf("got %v, want close with expected auth", reply)
+	}
+	if result := <-results; !errors.Is(result.err, errAuthFailed) {
+		t.Errorf("authenticate error = %v, want %v", result.err, errAuthFailed)
+	}
+}
+
+func TestAuthConfigValidate(t *testing.T) {
+	tests := []struct {
+		config  authConfig
+		wantErr bool
+	}{
+		{authConfig{}, false},
+		{authConfig{Mode: authToken, Secret: "s3cret"}, false},
+		{authConfig{Mode: authHMAC, Secret: "s3cret"}, false},
+		{authConfig{Mode: authToken}, true},
+		{authConfig{Mode: authHMAC}, true},
+		{authConfig{Mode: "password", Secret: "s3cret"}, true},
+	}
+
+	for _, tt := range tests {
+		err := tt.config.validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) error = %v, want error %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
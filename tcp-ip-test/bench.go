@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// benchConfig describes a load test
+type benchConfig struct {
+	// Addr is the address of the server
+	Addr string
+	// Connections is the number of concurrent client connections
+	Connections int
+	// Rate is the number of messages each connection sends per second
+	Rate float64
+	// Size is the size of each message in bytes
+	Size int
+	// Duration is how long to send messages for
+	Duration time.Duration
+	// Type is either ping, answered by the server with a pong, or dataMessage, relayed by a hub to a partner connection
+	Type commandType
+	// Auth is how each connection authenticates
+	Auth authConfig
+}
+
+// maxBenchRate is the fastest rate a connection can send at, one message a nanosecond
+const maxBenchRate = float64(time.Second)
+
+// validate will check that the load test can be run
+func (c benchConfig) validate() error {
+	if c.Connections <= 0 {
+		return fmt.Errorf("the number of connections must be positive, got %d", c.Connections)
+	}
+
+	// The rate is turned into a ticker interval, which must be at least a nanosecond
+	if math.IsNaN(c.Rate) || c.Rate <= 0 || c.Rate > maxBenchRate {
+		return fmt.Errorf("the rate must be greater than 0 and at most %g, got %v", maxBenchRate, c.Rate)
+	}
+
+	if c.Duration <= 0 {
+		return fmt.Errorf("the duration must be positive, got %v", c.Duration)
+	}
+
+	// Each message has to fit in a single message
+	if c.Size > maxDataLength {
+		return fmt.Errorf("the size must be at most %d bytes, got %d", maxDataLength, c.Size)
+	}
+
+	return nil
+}
+
+// benchResult is the outcome of a load test
+type benchResult struct {
+	// Sent is the number of messages sent
+	Sent int64
+	// Received is the number of replies or relayed messages received
+	Received int64
+	// Errors is the number of dial, send and receive errors
+	Errors int64
+	// Bytes is the number of bytes of message data received
+	Bytes int64
+	// Elapsed is how long messages were sent for
+	Elapsed time.Duration
+	// Latency is the distribution of round trip times for pings, or relay times for data messages
+	Latency latencySummary
+}
+
+// benchCounters are the counters shared by the connections in a load test
+type benchCounters struct {
+	sent     atomic.Int64
+	received atomic.Int64
+	errors   atomic.Int64
+	bytes    atomic.Int64
+	latency  latencyStats
+}
+
+// String will return a string representation of the result
+func (r benchResult) String() string {
+	seconds := r.Elapsed.Seconds()
+
+	return fmt.Sprintf(
+		"elapsed %v, sent %d, received %d, errors %d\nthroughput %.1f msg/s, %.1f KiB/s\nlatency %v",
+		r.Elapsed.Round(time.Millisecond), r.Sent, r.Received, r.Errors,
+		float64(r.Received)/seconds, float64(r.Bytes)/1024/seconds,
+		r.Latency,
+	)
+}
+
+// runBench will open the configured number of connections and send messages on each until the duration is up
+func runBench(ctx context.Context, config benchConfig) benchResult {
+	var counters benchCounters
+
+	// Stop sending when the duration is up
+	ctx, cancel := context.WithTimeout(ctx, config.Duration)
+	defer cancel()
+
+	start := time.Now()
+
+	// Start a goroutine for each connection
+	var wg sync.WaitGroup
+	for i := 0; i < config.Connections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			benchConnection(ctx, config, i, &counters)
+		}()
+	}
+
+	// Measure the time spent sending, leaving out the time spent waiting for the last replies
+	<-ctx.Done()
+	elapsed := time.Since(start)
+
+	wg.Wait()
+
+	return benchResult{
+		Sent:     counters.sent.Load(),
+		Received: counters.received.Load(),
+		Errors:   counters.errors.Load(),
+		Bytes:    counters.bytes.Load(),
+		Elapsed:  elapsed,
+		Latency:  counters.latency.summary(),
+	}
+}
+
+// benchConnection will send messages on a single connection until the context is cancelled
+func benchConnection(ctx context.Context, config benchConfig, index int, counters *benchCounters) {
+	conn, err := dial(config.Addr, config.Auth)
+	if err != nil {
+		fmt.Println("Error dialing:", err)
+		counters.errors.Add(1)
+		return
+	}
+
+	t := streamTransport{conn: conn}
+	defer t.Close()
+
+	// Data messages are relayed by a hub, so join a room shared with one other connection
+	name := fmt.Sprintf("bench-%d", index)
+	if config.Type == dataMessage {
+		err = t.Send(join, joinPayload(name, fmt.Sprintf("bench-%d", index/2)))
+		if err != nil {
+			counters.errors.Add(1)
+			return
+		}
+	}
+
+	// Start a goroutine to receive the replies
+	done := make(chan struct{}, 1)
+	go func() {
+		benchReceiver(t, counters)
+		done <- struct{}{}
+	}()
+
+	// Send a message at the configured rate
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / config.Rate))
+	defer ticker.Stop()
+
+	data := make([]byte, max(config.Size, latencyHeaderLength))
+	var seq uint32
+
+sending:
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break sending
+		}
+
+		binary.BigEndian.PutUint32(data, seq)
+		binary.BigEndian.PutUint64(data[4:], uint64(time.Now().UnixNano()))
+		seq++
+
+		err := t.Send(config.Type, data)
+		if err != nil {
+			counters.errors.Add(1)
+			break
+		}
+
+		counters.sent.Add(1)
+	}
+
+	// Give the last replies a chance to arrive before closing the connection
+	select {
+	case <-done:
+	case <-time.After(latencyDrain):
+		t.Close()
+		<-done
+	}
+}
+
+// benchReceiver will count the replies on a connection until it is closed
+func benchReceiver(t transport, counters *benchCounters) {
+	for {
+		message, err := t.Receive()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				counters.errors.Add(1)
+			}
+			return
+		}
+
+		data := message.Data
+
+		switch message.Type {
+		case ping:
+			// Answer pings from the server
+			t.Send(pong, data)
+			continue
+		case pong:
+		case dataMessage:
+			// Relayed messages are prefixed with the name of the sender
+			_, payload, ok := bytes.Cut(data, []byte(": "))
+			if !ok {
+				continue
+			}
+			data = payload
+		case close:
+			return
+		default:
+			continue
+		}
+
+		// Ignore anything that isn't one of our messages, such as announcements and the server's pings
+		if len(data) < latencyHeaderLength {
+			continue
+		}
+
+		sent := time.Unix(0, int64(binary.BigEndian.Uint64(data[4:])))
+
+		counters.received.Add(1)
+		counters.bytes.Add(int64(len(data)))
+		counters.latency.add(time.Since(sent))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBenchConfigValidate(t *testing.T) {
+	valid := benchConfig{Connections: 10, Rate: 10, Size: latencyHeaderLength, Duration: time.Second, Type: ping}
+
+	tests := []struct {
+		name    string
+		modify  func(c *benchConfig)
+		wantErr bool
+	}{
+		{"defaults", func(c *benchConfig) {}, false},
+		{"fractional rate", func(c *benchConfig) { c.Rate = 0.5 }, false},
+		{"fastest rate", func(c *benchConfig) { c.Rate = maxBenchRate }, false},
+		{"no connections", func(c *benchConfig) { c.Connections = 0 }, true},
+		{"negative connections", func(c *benchConfig) { c.Connections = -1 }, true},
+		{"zero rate", func(c *benchConfig) { c.Rate = 0 }, true},
+		{"negative rate", func(c *benchConfig) { c.Rate = -10 }, true},
+		{"rate too fast for the ticker", func(c *benchConfig) { c.Rate = 2e9 }, true},
+		{"infinite rate", func(c *benchConfig) { c.Rate = math.Inf(1) }, true},
+		{"not a number", func(c *benchConfig) { c.Rate = math.NaN() }, true},
+		{"zero duration", func(c *benchConfig) { c.Duration = 0 }, true},
+		{"negative duration", func(c *benchConfig) { c.Duration = -time.Second }, true},
+		{"largest message", func(c *benchConfig) { c.Size = maxDataLength }, false},
+		{"message too long", func(c *benchConfig) { c.Size = maxDataLength + 1 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid
+			tt.modify(&config)
+
+			err := config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	// The server answers each ping with a pong
+	s := &Server{Handler: func(ctx context.Context, conn net.Conn) {
+		receiver(conn)
+	}}
+	startServer(t, s)
+
+	config := benchConfig{
+		Addr:        s.Addr,
+		Connections: 3,
+		Rate:        50,
+		Size:        100,
+		Duration:    500 * time.Millisecond,
+		Type:        ping,
+	}
+
+	result := runBench(context.Background(), config)
+
+	// Each connection sends one message a tick, 25 in the duration, allowing for a slow start
+	perConnection := int64(config.Rate * config.Duration.Seconds())
+	if result.Sent < int64(config.Connections)*perConnection/2 || result.Sent > int64(config.Connections)*perConnection {
+		t.Errorf("sent %d messages, want about %d", result.Sent, int64(config.Connections)*perConnection)
+	}
+
+	if result.Errors != 0 {
+		t.Errorf("errors = %d, want 0", result.Errors)
+	}
+	if result.Received != result.Sent {
+		t.Errorf("received %d pongs, want one for each of the %d pings", result.Received, result.Sent)
+	}
+	if result.Bytes != result.Received*int64(config.Size) {
+		t.Errorf("received %d bytes, want %d", result.Bytes, result.Received*int64(config.Size))
+	}
+	if result.Latency.Count != int(result.Received) {
+		t.Errorf("latency samples = %d, want %d", result.Latency.Count, result.Received)
+	}
+	if result.Elapsed < config.Duration {
+		t.Errorf("elapsed %v, want at least %v", result.Elapsed, config.Duration)
+	}
+}
+
+func TestRunBenchDialError(t *testing.T) {
+	// Find a port with nothing listening on it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	result := runBench(context.Background(), benchConfig{
+		Addr:        addr,
+		Connections: 2,
+		Rate:        10,
+		Duration:    50 * time.Millisecond,
+		Type:        ping,
+	})
+
+	if result.Errors != 2 || result.Sent != 0 {
+		t.Errorf("result = %+v, want a dial error for each connection and nothing sent", result)
+	}
+}
+
+func TestBenchResultString(t *testing.T) {
+	result := benchResult{
+		Sent:     200,
+		Received: 190,
+		Errors:   1,
+		Bytes:    190 * 1024,
+		Elapsed:  2*time.Second + 400*time.Microsecond,
+		Latency: latencySummary{
+			Count: 190,
+			Min:   time.Millisecond,
+			Mean:  2 * time.Millisecond,
+			P50:   2 * time.Millisecond,
+			P95:   4 * time.Millisecond,
+			P99:   5 * time.Millisecond,
+			Max:   6 * time.Millisecond,
+		},
+	}
+
+	want := "elapsed 2s, sent 200, received 190, errors 1\n" +
+		"throughput 95.0 msg/s, 95.0 KiB/s\n" +
+		"latency min 1ms, mean 2ms, p50 2ms, p95 4ms, p99 5ms, max 6ms (190 samples)"
+
+	if got := result.String(); got != want {
+		t.Errorf("String() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync/atomic"
+)
+
+// messageFlags is a set of optional features used to encode a message
+type messageFlags uint8
+
+// Create an enumeration of the optional features
+const (
+	// The data is compressed with deflate
+	flagCompressed messageFlags = 1 << iota
+	// The message is followed by a CRC32 of the header and data
+	flagChecksum
+)
+
+// knownFlags is every flag that this version understands
+const knownFlags = flagCompressed | flagChecksum
+
+// compressionThreshold is the smallest data that is worth compressing
+const compressionThreshold = 256
+
+// errChecksum is returned when a message does not match its checksum
+var errChecksum = errors.New("message checksum mismatch")
+
+// offeredFeatures are the features this process will offer to its peers
+var offeredFeatures = knownFlags
+
+// peer wraps a connection with the state shared with the other end
+type peer struct {
+	net.Conn
+	// features are the agreed features, stored as a uint32 so they can be read and written atomically
+	features atomic.Uint32
+	// streams are the chunked streams in progress
+	streams streamTable
+	// identity is the name the other end authenticated as, set before any messages are handled
+	identity string
+}
+
+// newPeer will wrap a connection that has not agreed any features yet
+func newPeer(conn net.Conn) *peer {
+	return &peer{Conn: conn}
+}
+
+// Features will return the features agreed with the other end
+func (p *peer) Features() messageFlags {
+	return messageFlags(p.features.Load())
+}
+
+// setFeatures will set the features agreed with the other end
+func (p *peer) setFeatures(features messageFlags) {
+	p.features.Store(uint32(features))
+}
+
+// String will return a string representation of the flags
+func (f messageFlags) String() string {
+	if f == 0 {
+		return "none"
+	}
+
+	var s string
+	if f&flagCompressed != 0 {
+		s += "compression "
+	}
+	if f&flagChecksum != 0 {
+		s += "checksum "
+	}
+
+	return s[:len(s)-1]
+}
+
+// messageFlagsFor will return the flags to use when sending data over a connection
+// Connections that have not agreed any features use the original header
+func messageFlagsFor(conn net.Conn, data []byte) messageFlags {
+	p, ok := conn.(*peer)
+	if !ok {
+		return 0
+	}
+
+	flags := p.Features()
+
+	// Don't bother compressing small amounts of data
+	if len(data) < compressionThreshold {
+		flags &^= flagCompressed
+	}
+
+	// A message with no flags is sent with the original header
+	return flags
+}
+
+// sendHello will offer our features to the other end
+// A peer that doesn't understand hello will ignore it and both ends keep using the original header
+func sendHello(conn net.Conn) {
+	sendMessage(conn, hello, []byte{uint8(offeredFeatures)})
+}
+
+// acceptHello will agree the features offered by the other end and reply with a welcome
+func acceptHello(conn net.Conn, data []byte) {
+	if len(data) != 1 {
+		fmt.Println("Ignoring malformed hello")
+		return
+	}
+
+	// Agree the features that both ends support
+	agreed := messageFlags(data[0]) & offeredFeatures
+
+	// Reply before switching so that the welcome is readable by any peer
+	sendMessage(conn, welcome, []byte{uint8(agreed)})
+
+	if p, ok := conn.(*peer); ok {
+		p.setFeatures(agreed)
+	}
+
+	fmt.Println("Agreed features:", agreed)
+}
+
+// acceptWelcome will start using the features agreed by the other end
+func acceptWelcome(conn net.Conn, data []byte) {
+	if len(data) != 1 {
+		fmt.Println("Ignoring malformed welcome")
+		return
+	}
+
+	// Only use features that we offered in the first place
+	agreed := messageFlags(data[0]) & offeredFeatures
+
+	if p, ok := conn.(*peer); ok {
+		p.setFeatures(agreed)
+	}
+
+	fmt.Println("Agreed features:", agreed)
+}
+
+// compress will deflate the data
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompress will inflate the data, refusing to produce more than a message can hold
+func decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	// Read one byte more than the limit so that we can tell if it was exceeded
+	out, err := io.ReadAll(io.LimitReader(r, math.MaxUint16+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out) > math.MaxUint16 {
+		return nil, errors.New("decompressed message data too long")
+	}
+
+	return out, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+// negotiate will run the hello and welcome exchange between two peers over a pipe
+// The client offers the given features and the server agrees them with offeredFeatures
+func negotiate(t *testing.T, offer messageFlags) (client, server *peer) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	client, server = newPeer(clientConn), newPeer(serverConn)
+
+	go sendMessage(client, hello, []byte{uint8(offer)})
+
+	message, err := readMessage(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != hello {
+		t.Fatalf("server got %v, want hello", message.Type)
+	}
+
+	accepted := make(chan struct{}, 1)
+	go func() {
+		acceptHello(server, message.Data)
+		accepted <- struct{}{}
+	}()
+
+	message, err = readMessage(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != welcome {
+		t.Fatalf("client got %v, want welcome", message.Type)
+	}
+	acceptWelcome(client, message.Data)
+	<-accepted
+
+	return client, server
+}
+
+func TestFeatureNegotiation(t *testing.T) {
+	tests := []struct {
+		name    string
+		offered messageFlags
+		offer   messageFlags
+		want    messageFlags
+	}{
+		{"both features", knownFlags, knownFlags, knownFlags},
+		{"client offers checksum only", knownFlags, flagChecksum, flagChecksum},
+		{"server offers compression only", flagCompressed, knownFlags, flagCompressed},
+		{"unknown flags are dropped", knownFlags, 0xff, knownFlags},
+		{"nothing in common", flagChecksum, flagCompressed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(offered messageFlags) { offeredFeatures = offered }(offeredFeatures)
+			offeredFeatures = tt.offered
+
+			client, server := negotiate(t, tt.offer)
+
+			if got := server.Features(); got != tt.want {
+				t.Errorf("server features = %v, want %v", got, tt.want)
+			}
+			if got := client.Features(); got != tt.want {
+				t.Errorf("client features = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiatedFeaturesAreUsed(t *testing.T) {
+	tests := []struct {
+		name      string
+		offer     messageFlags
+		data      string
+		wantFlags messageFlags
+	}{
+		{"compressed with checksum", knownFlags, strings.Repeat("compress me ", 100), knownFlags},
+		{"small data isn't compressed", knownFlags, "hi", flagChecksum},
+		{"checksum only", flagChecksum, strings.Repeat("compress me ", 100), flagChecksum},
+		{"nothing agreed uses the original header", 0, strings.Repeat("compress me ", 100), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := negotiate(t, tt.offer)
+
+			go sendMessage(client, dataMessage, []byte(tt.data))
+
+			message, err := readMessage(server)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if message.Flags != tt.wantFlags {
+				t.Errorf("flags on the wire = %v, want %v", message.Flags, tt.wantFlags)
+			}
+			if !bytes.Equal(message.Data, []byte(tt.data)) {
+				t.Errorf("data = %q, want %q", message.Data, tt.data)
+			}
+		})
+	}
+}
+
+func TestUnnegotiatedConnectionUsesOriginalHeader(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	// A plain connection has never agreed any features
+	go sendMessage(clientConn, dataMessage, []byte(strings.Repeat("compress me ", 100)))
+
+	buf := make([]byte, 3)
+	if _, err := serverConn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf[0]&flagsPresent != 0 {
+		t.Errorf("header type byte = %#x, want no flags present", buf[0])
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRoom is the room a client is put in if it doesn't ask for one
+const defaultRoom = "lobby"
+
+// clientQueueLength is the number of messages that can wait to be written to a hub client
+// A client that falls this far behind is disconnected so that it can't hold up the room
+const clientQueueLength = 64
+
+// clientWriteTimeout is the longest a write to a hub client can take before it is disconnected
+const clientWriteTimeout = 10 * time.Second
+
+// hubClient is a connection that has joined the hub
+type hubClient struct {
+	// conn is the connection to the client
+	conn net.Conn
+	// name is the name the client is known by in the hub
+	name string
+	// room is the room the client is currently in
+	room string
+	// queue holds the messages waiting to be written to the client
+	queue chan string
+}
+
+// newHubClient will create a client for the connection with a default name and room
+func newHubClient(conn net.Conn) *hubClient {
+	return &hubClient{
+		conn:  conn,
+		name:  conn.RemoteAddr().String(),
+		room:  defaultRoom,
+		queue: make(chan string, clientQueueLength),
+	}
+}
+
+// enqueue will queue a message for the client without waiting, disconnecting the client if its queue is full
+func (c *hubClient) enqueue(text string) {
+	select {
+	case c.queue <- text:
+	default:
+		fmt.Printf("Disconnecting %s, it has fallen %d messages behind\n", c.name, clientQueueLength)
+		c.conn.Close()
+	}
+}
+
+// writer will write the queued messages to the client until the context is cancelled
+// Each client has its own writer so that a slow client only holds up its own messages
+func (c *hubClient) writer(ctx context.Context) {
+	for {
+		select {
+		case text := <-c.queue:
+			// Don't let a client that has stopped reading block the writer forever
+			c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+			err := sendMessage(c.conn, dataMessage, []byte(text))
+			c.conn.SetWriteDeadline(time.Time{})
+
+			// Disconnect the client if it can't be written to, closing the connection ends its reader
+			if err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Hub tracks all connected clients and relays data messages between clients in the same room
+type Hub struct {
+	// mutex protects the clients map and the room of each client
+	mutex sync.Mutex
+	// clients is the set of connected clients
+	clients map[*hubClient]struct{}
+}
+
+// NewHub will create a hub with no clients
+func NewHub() *Hub {
+	return &Hub{
+		clients: make(map[*hubClient]struct{}),
+	}
+}
+
+// joinPayload will create the data for a join message
+func joinPayload(name, room string) []byte {
+	return []byte(name + "\n" + room)
+}
+
+// parseJoinPayload will split the data from a join message into a name and room
+func parseJoinPayload(data []byte) (string, string) {
+	name, room, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSpace(name), strings.TrimSpace(room)
+}
+
+// add will add a client to the hub and announce it to the room
+func (h *Hub) add(client *hubClient) {
+	h.mutex.Lock()
+	h.clients[client] = struct{}{}
+	h.mutex.Unlock()
+
+	h.broadcast(client, fmt.Sprintf("%s joined %s", client.name, client.room))
+}
+
+// remove will remove a client from the hub and announce it to the room
+func (h *Hub) remove(client *hubClient) {
+	h.mutex.Lock()
+	delete(h.clients, client)
+	h.mutex.Unlock()
+
+	h.broadcast(client, fmt.Sprintf("%s left %s", client.name, client.room))
+}
+
+// move will move a client to a new room, announcing it to both the old and new rooms
+func (h *Hub) move(client *hubClient, room string) {
+	h.broadcast(client, fmt.Sprintf("%s left %s", client.name, client.room))
+
+	h.mutex.Lock()
+	client.room = room
+	h.mutex.Unlock()
+
+	h.broadcast(client, fmt.Sprintf("%s joined %s", client.name, client.room))
+}
+
+// broadcast will queue a data message for every client in the same room as from, other than from
+func (h *Hub) broadcast(from *hubClient, text string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// Queueing doesn't wait, so the lock isn't held while writing
+	for client := range h.clients {
+		if client != from && client.room == from.room {
+			client.enqueue(text)
+		}
+	}
+}
+
+// handleConnection will add the connection to the hub and relay its messages until it closes
+func (h *Hub) handleConnection(conn net.Conn) {
+	// Close the connection when the function returns
+	defer conn.Close()
+
+	// Fail any streams still in progress when the connection closes
+	if streams := streamsFor(conn); streams != nil {
+		defer streams.abort()
+	}
+
+	// Create a client with a default name and room
+	client := newHubClient(conn)
+
+	// The first message from a hub client should be a join
+	message, err := readMessage(conn)
+	if err != nil {
+		fmt.Println("Error reading message:", err)
+		return
+	}
+
+	// Take the name and room from the join message if they were given
+	if message.Type == join {
+		name, room := parseJoinPayload(message.Data)
+		if name != "" {
+			client.name = name
+		}
+		if room != "" {
+			client.room = room
+		}
+	}
+
+	// Authenticated clients are always known by their identity
+	if identity := peerIdentity(conn); identity != "" {
+		client.name = identity
+	}
+
+	// Start writing the client's messages, stopping when it leaves
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.writer(ctx)
+
+	// Add the client to the hub and remove it when the connection closes
+	h.add(client)
+	defer h.remove(client)
+
+	fmt.Printf("%s joined %s\n", client.name, client.room)
+
+	// Handle the first message as normal unless it was the join
+	if message.Type != join && !h.handleMessage(client, message) {
+		return
+	}
+
+	for {
+		// Read a message from the connection
+		message, err := readMessage(conn)
+		if err != nil {
+			fmt.Println("Error reading message:", err)
+			return
+		}
+
+		// Handle the message, stopping if the client has gone
+		if !h.handleMessage(client, message) {
+			return
+		}
+	}
+}
+
+// handleMessage will act on a message from a hub client, returning false if the client has closed
+func (h *Hub) handleMessage(client *hubClient, message Message) bool {
+	// Print the message to the console
+	if logMessages {
+		fmt.Printf("Received message from %s: %v\n", client.name, message)
+	}
+
+	// Switch on the type of message
+	switch message.Type {
+	case ping:
+		// Send a pong message
+		sendMessage(client.conn, pong, message.Data)
+	case dataMessage:
+		// Tell the sender if the message is too long to relay once their name has been added
+		text := fmt.Sprintf("%s: %s", client.name, message.Data)
+		if len(text) > maxDataLength {
+			fmt.Printf("Not relaying a %d byte message from %s, it is too long\n", len(text), client.name)
+			client.enqueue(fmt.Sprintf("Message not relayed, it is longer than %d bytes with your name", maxDataLength))
+			return true
+		}
+
+		// Relay the message to everyone else in the room
+		h.broadcast(client, text)
+	case join:
+		// Move the client to the requested room
+		_, room := parseJoinPayload(message.Data)
+		if room != "" && room != client.room {
+			h.move(client, room)
+		}
+	case pong:
+		// Do nothing
+	case close:
+		// The client has left
+		return false
+	case hello:
+		// Agree the features that both ends support
+		acceptHello(client.conn, message.Data)
+	case streamStart, streamChunk, streamEnd, streamAck, streamReject:
+		// Streams are received by the hub rather than relayed
+		handleStreamMessage(client.conn, message)
+	}
+
+	return true
+}
+
+// handleHubClient will join a hub, print messages relayed from other clients and send lines read from stdin
+func handleHubClient(ctx context.Context, conn net.Conn, name, room string) {
+	// Close the connection when the function returns
+	defer conn.Close()
+
+	// Join the hub and offer our features
+	sendMessage(conn, join, joinPayload(name, room))
+	sendHello(conn)
+
+	// Create a channel to wait for the connection or stdin to close
+	done := make(chan struct{}, 2)
+
+	// Start a goroutine to print the messages from the hub
+	go func() {
+		hubReceiver(conn)
+		done <- struct{}{}
+	}()
+
+	// Start a goroutine to send the lines typed on stdin
+	go func() {
+		hubSender(conn, name)
+		done <- struct{}{}
+	}()
+
+	// Wait for the connection to close or the context to be cancelled
+	select {
+	case <-done:
+		fmt.Println("Connection closed")
+	case <-ctx.Done():
+		sendMessage(conn, close, nil)
+	}
+}
+
+// hubReceiver will print the messages relayed by the hub until the connection closes
+func hubReceiver(conn net.Conn) {
+	for {
+		// Read a message from the connection
+		message, err := readMessage(conn)
+		if err != nil {
+			fmt.Println("Error reading message:", err)
+			return
+		}
+
+		// Switch on the type of message
+		switch message.Type {
+		case ping:
+			// Send a pong message
+			sendMessage(conn, pong, message.Data)
+		case dataMessage:
+			// Print the relayed message
+			fmt.Println(string(message.Data))
+		case close:
+			// Close the connection
+			return
+		case welcome:
+			// Start using the agreed features
+			acceptWelcome(conn, message.Data)
+		}
+	}
+}
+
+// hubSender will read lines from stdin and send them to the hub as data messages
+// A line of the form "/join room" will move to another room and "/quit" will leave the hub
+func hubSender(conn net.Conn, name string) {
+	scanner := bufio.NewScanner(os.Stdin)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			// Ignore empty lines
+		case line == "/quit":
+			sendMessage(conn, close, nil)
+			return
+		case strings.HasPrefix(line, "/join "):
+			sendMessage(conn, join, joinPayload(name, strings.TrimPrefix(line, "/join ")))
+		default:
+			sendMessage(conn, dataMessage, []byte(line))
+		}
+	}
+
+	// Stdin has closed so leave the hub
+	sendMessage(conn, close, nil)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Don't print every message sent and received
+	logMessages = false
+
+	os.Exit(m.Run())
+}
+
+// joinHub will connect a client to the hub over a pipe and join the room as name
+// It returns once the hub has added the client
+func joinHub(t *testing.T, h *Hub, name, room string) net.Conn {
+	t.Helper()
+
+	server, client := net.Pipe()
+	go h.handleConnection(newPeer(server))
+	t.Cleanup(func() { client.Close() })
+
+	data := joinPayload(name, room)
+	if err := writeMessage(client, Message{Type: join, Length: uint16(len(data)), Data: data}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The hub answers the ping once it has handled the join
+	if err := writeMessage(client, Message{Type: ping}); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		message, err := readMessage(client)
+		if err != nil {
+			t.Fatalf("%s waiting to join: %v", name, err)
+		}
+		if message.Type == pong {
+			break
+		}
+	}
+	client.SetReadDeadline(time.Time{})
+
+	return client
+}
+
+// say will send a data message from a hub client
+func say(t *testing.T, conn net.Conn, text string) {
+	t.Helper()
+
+	if err := writeMessage(conn, Message{Type: dataMessage, Length: uint16(len(text)), Data: []byte(text)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nextRelayed will return the next data message for a hub client that starts with prefix, skipping announcements
+func nextRelayed(t *testing.T, conn net.Conn, prefix string) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for {
+		message, err := readMessage(conn)
+		if err != nil {
+			t.Fatalf("waiting for a message starting %q: %v", prefix, err)
+		}
+		if message.Type == dataMessage && strings.HasPrefix(string(message.Data), prefix) {
+			return string(message.Data)
+		}
+	}
+}
+
+// assertNothingRelayed will check that no message starting with prefix arrives for a short time
+func assertNothingRelayed(t *testing.T, conn net.Conn, prefix string) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for {
+		message, err := readMessage(conn)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.Type == dataMessage && strings.HasPrefix(string(message.Data), prefix) {
+			t.Errorf("unexpected message: %q", message.Data)
+		}
+	}
+}
+
+func TestHubRelaysWithinRoom(t *testing.T) {
+	h := NewHub()
+
+	alice := joinHub(t, h, "alice", "red")
+	bob := joinHub(t, h, "bob", "red")
+	carol := joinHub(t, h, "carol", "blue")
+
+	// Alice hears that bob joined her room
+	if got := nextRelayed(t, alice, "bob"); got != "bob joined red" {
+		t.Errorf("alice got %q, want the join announcement", got)
+	}
+
+	say(t, alice, "hello")
+
+	if got := nextRelayed(t, bob, "alice"); got != "alice: hello" {
+		t.Errorf("bob got %q, want alice: hello", got)
+	}
+	assertNothingRelayed(t, carol, "alice")
+
+	// Moving rooms changes who hears the messages
+	data := joinPayload("bob", "blue")
+	writeMessage(bob, Message{Type: join, Length: uint16(len(data)), Data: data})
+
+	if got := nextRelayed(t, carol, "bob"); got != "bob joined blue" {
+		t.Errorf("carol got %q, want bob's join announcement", got)
+	}
+
+	say(t, bob, "hi carol")
+	if got := nextRelayed(t, carol, "bob:"); got != "bob: hi carol" {
+		t.Errorf("carol got %q, want bob: hi carol", got)
+	}
+}
+
+func TestHubSlowClientDoesNotBlockRoom(t *testing.T) {
+	h := NewHub()
+
+	alice := joinHub(t, h, "alice", "room")
+	fast := joinHub(t, h, "fast", "room")
+
+	// The slow client never reads again after joining
+	slow := joinHub(t, h, "slow", "room")
+
+	// Keep reading alice's announcements so only the slow client falls behind
+	go func() {
+		for {
+			if _, err := readMessage(alice); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Send more messages than the slow client can queue
+	count := clientQueueLength + 10
+	sent := make(chan struct{})
+	go func() {
+		defer func() { sent <- struct{}{} }()
+		for i := 0; i < count; i++ {
+			if err := writeMessage(alice, Message{Type: dataMessage, Length: 1, Data: []byte("x")}); err != nil {
+				return
+			}
+		}
+	}()
+
+	// The fast client gets every message even though the slow client isn't reading
+	for i := 0; i < count; i++ {
+		nextRelayed(t, fast, "alice: x")
+	}
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sending was held up by the slow client")
+	}
+
+	// The slow client has been disconnected, so reading its pipe ends rather than timing out
+	slow.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, err := readMessage(slow)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatal("slow client wasn't disconnected")
+		}
+		if err != nil {
+			break
+		}
+	}
+}
+
+func TestHubRejectsMessagesTooLongToRelay(t *testing.T) {
+	h := NewHub()
+
+	alice := joinHub(t, h, "alice", "room")
+	bob := joinHub(t, h, "bob", "room")
+
+	// The message fits in a frame, but not once "alice: " is added
+	say(t, alice, strings.Repeat("a", maxDataLength-3))
+
+	if got := nextRelayed(t, alice, "Message not relayed"); !strings.Contains(got, "longer than") {
+		t.Errorf("alice got %q, want to be told the message wasn't relayed", got)
+	}
+	assertNothingRelayed(t, bob, "alice:")
+
+	// Shorter messages are still relayed
+	say(t, alice, "short")
+	if got := nextRelayed(t, bob, "alice:"); got != "alice: short" {
+		t.Errorf("bob got %q, want alice: short", got)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// ErrServerClosed is returned by Serve once Shutdown has been called
+var ErrServerClosed = errors.New("server closed")
+
+// Server accepts connections and hands each of them to a handler until it is shut down
+type Server struct {
+	// Addr is the address to listen on
+	Addr string
+	// MaxConnections is the number of connections that can be handled at once, zero means no limit
+	MaxConnections int
+	// Handler is called in its own goroutine for each accepted connection
+	// The connection is closed by the server once the handler returns
+	Handler func(ctx context.Context, conn net.Conn)
+	// Auth is how connections must authenticate before they are passed to the handler
+	Auth authConfig
+
+	// mutex protects the fields below
+	mutex sync.Mutex
+	// listener is the listener that connections are accepted from
+	listener net.Listener
+	// conns is the set of live connections
+	conns map[net.Conn]struct{}
+	// cancel will cancel the context passed to the handlers
+	cancel context.CancelFunc
+	// shuttingDown is set once Shutdown has been called
+	shuttingDown bool
+	// handlers tracks the running handlers
+	handlers sync.WaitGroup
+}
+
+// Serve will listen on the server address and handle connections until Shutdown is called
+// The context is the parent of the context passed to each handler
+func (s *Server) Serve(ctx context.Context) error {
+	// Listen for connections
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	// Create a context that Shutdown will cancel once the connections have finished
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Store the listener so that Shutdown can close it
+	s.mutex.Lock()
+	if s.shuttingDown {
+		s.mutex.Unlock()
+		cancel()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.cancel = cancel
+	s.conns = make(map[net.Conn]struct{})
+	s.mutex.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			// The listener is closed by Shutdown so this is the normal way out of the loop
+			if s.isShuttingDown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		// Wrap the connection so that it can agree features with the client
+		p := newPeer(conn)
+
+		// Reject the connection if the server is full or shutting down
+		if !s.track(p) {
+			fmt.Println("Rejecting connection from", p.RemoteAddr())
+			sendMessage(p, close, []byte("server unavailable"))
+			p.Close()
+			continue
+		}
+
+		go func() {
+			// Stop tracking and close the connection when the handler returns
+			defer s.handlers.Done()
+			defer s.untrack(p)
+
+			// Only hand authenticated connections to the handler
+			identity, err := authenticate(p, s.Auth)
+			if err != nil {
+				fmt.Println("Rejected connection from", p.RemoteAddr(), err)
+				return
+			}
+
+			if identity != "" {
+				p.identity = identity
+				fmt.Println("Authenticated", p.RemoteAddr(), "as", identity)
+			}
+
+			s.Handler(ctx, p)
+		}()
+	}
+}
+
+// Shutdown will stop accepting connections, send close to every live peer and wait for the handlers to return
+// If the context expires first the remaining connections are closed and the context error is returned
+func (s *Server) Shutdown(ctx context.Context) error {
+	// Mark the server as shutting down and take a copy of the live connections
+	s.mutex.Lock()
+	s.shuttingDown = true
+	listener := s.listener
+	conns := make([]net.Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mutex.Unlock()
+
+	// Stop accepting new connections
+	if listener != nil {
+		listener.Close()
+	}
+
+	// Ask every peer to close
+	for _, conn := range conns {
+		sendMessage(conn, close, []byte("server shutting down"))
+	}
+
+	// Wait for the handlers to return in a goroutine so that the wait can time out
+	done := make(chan struct{}, 1)
+	go func() {
+		s.handlers.Wait()
+		done <- struct{}{}
+	}()
+
+	select {
+	case <-done:
+		fmt.Println("All connections drained")
+		s.cancelHandlers()
+		return nil
+	case <-ctx.Done():
+		// Force close any connections that are still open
+		s.mutex.Lock()
+		for conn := range s.conns {
+			fmt.Println("Force closing connection to", conn.RemoteAddr())
+			conn.Close()
+		}
+		s.mutex.Unlock()
+
+		s.cancelHandlers()
+		return ctx.Err()
+	}
+}
+
+// isShuttingDown will return true once Shutdown has been called
+func (s *Server) isShuttingDown() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.shuttingDown
+}
+
+// cancelHandlers will cancel the context passed to the handlers
+func (s *Server) cancelHandlers() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// track will add a connection to the set of live connections, returning false if it can't be accepted
+func (s *Server) track(conn net.Conn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.shuttingDown {
+		return false
+	}
+
+	if s.MaxConnections > 0 && len(s.conns) >= s.MaxConnections {
+		return false
+	}
+
+	// Add to the wait group while the lock is held so that Shutdown can't start waiting first
+	s.conns[conn] = struct{}{}
+	s.handlers.Add(1)
+
+	return true
+}
+
+// untrack will close a connection and remove it from the set of live connections
+func (s *Server) untrack(conn net.Conn) {
+	conn.Close()
+
+	s.mutex.Lock()
+	delete(s.conns, conn)
+	s.mutex.Unlock()
+}
+
+// signalContext will return a context that is cancelled when SIGINT or SIGTERM is received
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Create a channel to handle SIGINT or SIGTERM
+	signals := make(chan os.Signal, 1)
+
+	// Register the channel to receive the signals
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		// Cancel the context when a signal is received
+		select {
+		case sig := <-signals:
+			fmt.Println("Received signal:", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+
+		// Stop receiving signals
+		signal.Stop(signals)
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// startServer will serve on a free local port, returning once the server is accepting connections
+// Serve's result is sent on the returned channel
+func startServer(t *testing.T, s *Server) <-chan error {
+	t.Helper()
+
+	// Find a free port for the server to listen on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Addr = l.Addr().String()
+	l.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve(context.Background())
+	}()
+
+	// Wait for the listener to be stored so that connections are tracked
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mutex.Lock()
+		listening := s.listener != nil
+		s.mutex.Unlock()
+
+		if listening {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server didn't start listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+
+	return errs
+}
+
+// readUntilClose will read messages until a close arrives, returning its data
+func readUntilClose(t *testing.T, conn net.Conn) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		message, err := readMessage(conn)
+		if err != nil {
+			t.Fatalf("waiting for close: %v", err)
+		}
+		if message.Type == close {
+			return string(message.Data)
+		}
+	}
+}
+
+func TestServerShutdownDrainsConnections(t *testing.T) {
+	handled := make(chan struct{}, 1)
+	s := &Server{Handler: func(ctx context.Context, conn net.Conn) {
+		// Handle messages until the client goes
+		receiver(conn)
+		handled <- struct{}{}
+	}}
+	errs := startServer(t, s)
+
+	conn, err := dial(s.Addr, authConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The client closes its end once the server asks it to
+	closed := make(chan string, 1)
+	go func() {
+		closed <- readUntilClose(t, conn)
+		conn.Close()
+	}()
+
+	// Wait for the server to track the connection
+	waitFor(t, func() bool { return s.liveConnections() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown error = %v, want nil once the connection drained", err)
+	}
+
+	if got := <-closed; got != "server shutting down" {
+		t.Errorf("close reason = %q, want server shutting down", got)
+	}
+
+	select {
+	case <-handled:
+	default:
+		t.Error("Shutdown returned before the handler")
+	}
+
+	if err := <-errs; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve error = %v, want %v", err, ErrServerClosed)
+	}
+
+	// No more connections are accepted
+	if conn, err := net.Dial("tcp", s.Addr); err == nil {
+		conn.Close()
+		t.Error("server accepted a connection after shutting down")
+	}
+}
+
+func TestServerShutdownForceClosesAfterDeadline(t *testing.T) {
+	handled := make(chan struct{}, 1)
+	s := &Server{Handler: func(ctx context.Context, conn net.Conn) {
+		// Read until the connection is closed, ignoring the close message
+		for {
+			if _, err := readMessage(conn); err != nil {
+				handled <- struct{}{}
+				return
+			}
+		}
+	}}
+	startServer(t, s)
+
+	// The client never closes its end
+	conn, err := dial(s.Addr, authConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	waitFor(t, func() bool { return s.liveConnections() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Closing the connection ends the handler
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Error("handler still running after the connection was force closed")
+	}
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	s := &Server{
+		MaxConnections: 1,
+		Handler: func(ctx context.Context, conn net.Conn) {
+			receiver(conn)
+		},
+	}
+	startServer(t, s)
+
+	first, err := dial(s.Addr, authConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	waitFor(t, func() bool { return s.liveConnections() == 1 })
+
+	// The second connection is turned away
+	second, err := dial(s.Addr, authConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	if got := readUntilClose(t, second); got != "server unavailable" {
+		t.Errorf("close reason = %q, want server unavailable", got)
+	}
+
+	// There is room again once the first connection has gone
+	first.Close()
+	waitFor(t, func() bool { return s.liveConnections() == 0 })
+
+	third, err := dial(s.Addr, authConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+
+	waitFor(t, func() bool { return s.liveConnections() == 1 })
+}
+
+// waitFor will wait for the condition to hold, failing the test if it doesn't within a few seconds
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// liveConnections will return the number of connections the server is tracking
+func (s *Server) liveConnections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.conns)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencyStats collects round trip times so that they can be summarised at the end of a run
+type latencyStats struct {
+	// mutex protects samples
+	mutex sync.Mutex
+	// samples are the round trip times recorded so far
+	samples []time.Duration
+}
+
+// latencySummary is the distribution of a set of round trip times
+type latencySummary struct {
+	Count int
+	Min   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// add will record a round trip time
+func (s *latencyStats) add(rtt time.Duration) {
+	s.mutex.Lock()
+	s.samples = append(s.samples, rtt)
+	s.mutex.Unlock()
+}
+
+// summary will return the distribution of the round trip times recorded so far
+func (s *latencyStats) summary() latencySummary {
+	// Sort a copy of the samples so that recording can carry on
+	s.mutex.Lock()
+	sorted := slices.Clone(s.samples)
+	s.mutex.Unlock()
+
+	if len(sorted) == 0 {
+		return latencySummary{}
+	}
+
+	slices.Sort(sorted)
+
+	// Add up the samples to get the mean
+	var total time.Duration
+	for _, rtt := range sorted {
+		total += rtt
+	}
+
+	return latencySummary{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Mean:  total / time.Duration(len(sorted)),
+		P50:   percentile(sorted, 50),
+		P95:   percentile(sorted, 95),
+		P99:   percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// percentile will return the nearest rank percentile p of the sorted samples
+// This is the smallest sample that at least p percent of the samples are less than or equal to
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	// Multiply before dividing so that exact ranks such as 95% of 20 aren't pushed up by rounding error
+	rank := int(math.Ceil(p*float64(len(sorted))/100)) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+
+	return sorted[rank]
+}
+
+// String will return a string representation of the distribution
+func (s latencySummary) String() string {
+	if s.Count == 0 {
+		return "no samples"
+	}
+
+	return fmt.Sprintf(
+		"min %v, mean %v, p50 %v, p95 %v, p99 %v, max %v (%d samples)",
+		s.Min, s.Mean, s.P50, s.P95, s.P99, s.Max, s.Count,
+	)
+}
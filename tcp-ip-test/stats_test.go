@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	// samples will return 1ms to nms in order
+	samples := func(n int) []time.Duration {
+		sorted := make([]time.Duration, n)
+		for i := range sorted {
+			sorted[i] = time.Duration(i+1) * time.Millisecond
+		}
+		return sorted
+	}
+
+	tests := []struct {
+		n    int
+		p    float64
+		want time.Duration
+	}{
+		{1, 50, 1 * time.Millisecond},
+		{1, 99, 1 * time.Millisecond},
+		{10, 50, 5 * time.Millisecond},
+		{10, 95, 10 * time.Millisecond},
+		{13, 50, 7 * time.Millisecond},
+		{13, 95, 13 * time.Millisecond},
+		{20, 95, 19 * time.Millisecond},
+		{100, 99, 99 * time.Millisecond},
+		{100, 100, 100 * time.Millisecond},
+		{100, 0, 1 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := percentile(samples(tt.n), tt.p); got != tt.want {
+			t.Errorf("percentile(%d samples, %v) = %v, want %v", tt.n, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestLatencySummary(t *testing.T) {
+	var stats latencyStats
+	if got := stats.summary(); got.Count != 0 {
+		t.Errorf("summary of no samples = %+v, want zero", got)
+	}
+
+	// Record the samples out of order
+	for _, ms := range []int{4, 1, 3, 2} {
+		stats.add(time.Duration(ms) * time.Millisecond)
+	}
+
+	want := latencySummary{
+		Count: 4,
+		Min:   1 * time.Millisecond,
+		Mean:  2500 * time.Microsecond,
+		P50:   2 * time.Millisecond,
+		P95:   4 * time.Millisecond,
+		P99:   4 * time.Millisecond,
+		Max:   4 * time.Millisecond,
+	}
+	if got := stats.summary(); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+}
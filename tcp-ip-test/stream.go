@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// chunkSize is the most content carried by a single chunk message
+const chunkSize = 32 * 1024
+
+// streamWindow is the number of chunks that can be sent before the receiver has consumed them
+// This bounds the memory the receiver needs for each stream to streamWindow * chunkSize
+const streamWindow = 8
+
+// streamAckTimeout is how long a sender will wait for the receiver to consume a chunk
+const streamAckTimeout = 30 * time.Second
+
+// streamHeaderLength is the length of the stream id and sequence number at the start of a chunk
+const streamHeaderLength = 8
+
+// maxIncomingStreams is the most streams a connection will receive at once
+// Each stream can buffer a window of chunks so this bounds the memory one sender can use
+const maxIncomingStreams = 16
+
+// receiveDir is the directory incoming streams are saved to, empty to discard them
+var receiveDir string
+
+// errStreamClosed is returned when the connection closes before a stream has finished
+var errStreamClosed = errors.New("connection closed during stream")
+
+// StreamHandler consumes an incoming stream, reading the reassembled content from r
+// Each chunk is acked once it has been read, so a slow handler slows the sender down
+// If the handler returns before reading everything the rest is discarded and the sender is told
+type StreamHandler func(name string, r io.Reader)
+
+// streamItem is a chunk of content, or the end of the stream, waiting to be consumed
+type streamItem struct {
+	// data is the content of the chunk
+	data []byte
+	// end is set on the last item in the stream
+	end bool
+	// err is the reason the stream ended early, nil if it completed
+	err error
+}
+
+// incomingStream is a stream that is being reassembled from chunk messages
+type incomingStream struct {
+	// name is the name the sender gave the stream
+	name string
+	// next is the sequence number of the next chunk expected, only used by the receiver loop
+	next uint32
+	// items are the chunks received but not yet consumed
+	items chan streamItem
+	// ctx is cancelled if the connection closes before the stream ends
+	ctx context.Context
+	// cancel will cancel ctx
+	cancel context.CancelFunc
+}
+
+// outgoingStream is a stream that is being sent as chunk messages
+type outgoingStream struct {
+	// acks receives the sequence number of each chunk consumed by the receiver
+	acks chan uint32
+	// ctx is cancelled if the connection closes or the receiver rejects the stream before it ends
+	ctx context.Context
+	// cancel will cancel ctx with the reason the stream failed
+	cancel context.CancelCauseFunc
+}
+
+// streamTable is the set of streams in progress on a connection
+type streamTable struct {
+	// mutex protects the fields below
+	mutex sync.Mutex
+	// nextID is the id of the last outgoing stream
+	nextID uint32
+	// incoming are the streams being received, by id
+	incoming map[uint32]*incomingStream
+	// outgoing are the streams being sent, by id
+	outgoing map[uint32]*outgoingStream
+	// handler consumes incoming streams, receiveStream if it is nil
+	handler StreamHandler
+}
+
+// streamsFor will return the stream table for a connection, or nil if it can't carry streams
+func streamsFor(conn net.Conn) *streamTable {
+	p, ok := conn.(*peer)
+	if !ok {
+		return nil
+	}
+
+	return &p.streams
+}
+
+// HandleStreams will set the handler for streams received over the connection
+// It should be called before the connection starts receiving messages
+func HandleStreams(conn net.Conn, handler StreamHandler) error {
+	streams := streamsFor(conn)
+	if streams == nil {
+		return errors.New("connection does not support streams")
+	}
+
+	streams.mutex.Lock()
+	streams.handler = handler
+	streams.mutex.Unlock()
+
+	return nil
+}
+
+// SendStream will send the content of r to the other end of the connection as a sequence of chunks
+// It returns once the receiver has consumed every chunk
+func SendStream(conn net.Conn, name string, r io.Reader) error {
+	streams := streamsFor(conn)
+	if streams == nil {
+		return errors.New("connection does not support streams")
+	}
+
+	// Register the stream so that the receiver loop can pass on the acks
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	stream := &outgoingStream{
+		acks:   make(chan uint32, streamWindow),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	streams.mutex.Lock()
+	streams.nextID++
+	id := streams.nextID
+	if streams.outgoing == nil {
+		streams.outgoing = make(map[uint32]*outgoingStream)
+	}
+	streams.outgoing[id] = stream
+	streams.mutex.Unlock()
+
+	// Unregister the stream when the function returns
+	defer func() {
+		streams.mutex.Lock()
+		delete(streams.outgoing, id)
+		streams.mutex.Unlock()
+	}()
+
+	// Tell the receiver a stream is starting
+	err := sendMessage(conn, streamStart, append(binary.BigEndian.AppendUint32(nil, id), name...))
+	if err != nil {
+		return err
+	}
+
+	var seq uint32
+	inFlight := 0
+	buf := make([]byte, chunkSize)
+
+	for {
+		// Stop sending as soon as the stream has failed
+		err = context.Cause(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Fill a chunk from the reader
+		n, readErr := io.ReadFull(r, buf)
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			// Tell the receiver the stream failed
+			sendMessage(conn, streamEnd, streamEndPayload(id, seq, readErr))
+			return readErr
+		}
+
+		// Wait for the receiver to consume a chunk if the window is full
+		if inFlight == streamWindow {
+			err = waitForAck(stream)
+			if err != nil {
+				return err
+			}
+			inFlight--
+		}
+
+		// Send the chunk
+		data := binary.BigEndian.AppendUint32(nil, id)
+		data = binary.BigEndian.AppendUint32(data, seq)
+		data = append(data, buf[:n]...)
+
+		err = sendMessage(conn, streamChunk, data)
+		if err != nil {
+			return err
+		}
+
+		seq++
+		inFlight++
+
+		// A short chunk means the reader is finished
+		if readErr == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+
+	// Tell the receiver how many chunks there were
+	err = sendMessage(conn, streamEnd, streamEndPayload(id, seq, nil))
+	if err != nil {
+		return err
+	}
+
+	// Wait for the receiver to consume the remaining chunks
+	for ; inFlight > 0; inFlight-- {
+		err = waitForAck(stream)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForAck will wait for the receiver to consume a chunk
+func waitForAck(stream *outgoingStream) error {
+	select {
+	case <-stream.acks:
+		return nil
+	case <-stream.ctx.Done():
+		return context.Cause(stream.ctx)
+	case <-time.After(streamAckTimeout):
+		return errors.New("timed out waiting for the receiver")
+	}
+}
+
+// streamEndPayload will create the data for a stream end message
+func streamEndPayload(id, count uint32, err error) []byte {
+	data := binary.BigEndian.AppendUint32(nil, id)
+	data = binary.BigEndian.AppendUint32(data, count)
+
+	if err != nil {
+		data = append(data, err.Error()...)
+	}
+
+	return data
+}
+
+// handleStreamMessage will handle a stream message from the other end of the connection
+func handleStreamMessage(conn net.Conn, message Message) {
+	streams := streamsFor(conn)
+	if streams == nil {
+		fmt.Println("Ignoring stream message on a connection that does not support streams")
+		return
+	}
+
+	// Every stream message starts with the stream id
+	if len(message.Data) < 4 {
+		fmt.Println("Ignoring malformed stream message")
+		return
+	}
+	id := binary.BigEndian.Uint32(message.Data)
+
+	switch message.Type {
+	case streamStart:
+		streams.start(conn, id, string(message.Data[4:]))
+	case streamChunk, streamEnd:
+		if len(message.Data) < streamHeaderLength {
+			fmt.Println("Ignoring malformed stream message")
+			return
+		}
+		streams.deliver(id, message)
+	case streamAck:
+		if len(message.Data) < streamHeaderLength {
+			fmt.Println("Ignoring malformed stream message")
+			return
+		}
+		streams.ack(id, binary.BigEndian.Uint32(message.Data[4:]))
+	case streamReject:
+		streams.reject(id, string(message.Data[4:]))
+	}
+}
+
+// start will begin reassembling a new incoming stream
+func (t *streamTable) start(conn net.Conn, id uint32, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream := &incomingStream{
+		name:   name,
+		items:  make(chan streamItem, streamWindow+1),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	t.mutex.Lock()
+	if t.incoming == nil {
+		t.incoming = make(map[uint32]*incomingStream)
+	}
+	if _, ok := t.incoming[id]; ok {
+		t.mutex.Unlock()
+		cancel()
+		fmt.Println("Ignoring duplicate stream:", id)
+		return
+	}
+	if len(t.incoming) >= maxIncomingStreams {
+		t.mutex.Unlock()
+		cancel()
+		fmt.Println("Rejecting stream, too many in progress:", id)
+
+		// Tell the sender so that it stops sending chunks
+		reason := fmt.Sprintf("too many streams in progress, at most %d", maxIncomingStreams)
+		sendMessage(conn, streamReject, append(binary.BigEndian.AppendUint32(nil, id), reason...))
+		return
+	}
+	t.incoming[id] = stream
+	handler := t.handler
+	t.mutex.Unlock()
+
+	if handler == nil {
+		handler = receiveStream
+	}
+
+	fmt.Printf("Receiving stream %d: %s\n", id, name)
+
+	// Write the chunks into a pipe as they are consumed, acking each one
+	r, w := io.Pipe()
+	go func() {
+		defer cancel()
+		stream.pump(conn, id, w)
+	}()
+
+	// Consume the stream
+	go func() {
+		handler(name, r)
+
+		// Unblock the pump if we stopped reading early
+		r.Close()
+
+		t.mutex.Lock()
+		delete(t.incoming, id)
+		t.mutex.Unlock()
+	}()
+}
+
+// deliver will queue a chunk or end message for an incoming stream
+func (t *streamTable) deliver(id uint32, message Message) {
+	t.mutex.Lock()
+	stream, ok := t.incoming[id]
+	t.mutex.Unlock()
+
+	if !ok {
+		fmt.Println("Ignoring message for unknown stream:", id)
+		return
+	}
+
+	seq := binary.BigEndian.Uint32(message.Data[4:])
+
+	var item streamItem
+	if message.Type == streamChunk {
+		// Chunks must arrive in order
+		if seq != stream.next {
+			item = streamItem{end: true, err: fmt.Errorf("expected chunk %d, got %d", stream.next, seq)}
+		} else {
+			item = streamItem{data: message.Data[streamHeaderLength:]}
+			stream.next++
+		}
+	} else {
+		// The end message carries the number of chunks and any error from the sender
+		item = streamItem{end: true}
+		if len(message.Data) > streamHeaderLength {
+			item.err = errors.New(string(message.Data[streamHeaderLength:]))
+		} else if seq != stream.next {
+			item.err = fmt.Errorf("expected %d chunks, got %d", seq, stream.next)
+		}
+	}
+
+	// The sender should never have more than a window of chunks unconsumed
+	select {
+	case stream.items <- item:
+	default:
+		fmt.Println("Stream sender exceeded the window, aborting stream:", id)
+		stream.cancel()
+	}
+}
+
+// ack will pass on an ack for an outgoing stream
+func (t *streamTable) ack(id, seq uint32) {
+	t.mutex.Lock()
+	stream, ok := t.outgoing[id]
+	t.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case stream.acks <- seq:
+	default:
+		fmt.Println("Ignoring unexpected ack for stream:", id)
+	}
+}
+
+// reject will fail an outgoing stream that the receiver refused
+func (t *streamTable) reject(id uint32, reason string) {
+	t.mutex.Lock()
+	stream, ok := t.outgoing[id]
+	t.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	stream.cancel(fmt.Errorf("stream rejected: %s", reason))
+}
+
+// abort will fail every stream in progress, called when the connection closes
+func (t *streamTable) abort() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, stream := range t.incoming {
+		stream.cancel()
+	}
+	for _, stream := range t.outgoing {
+		stream.cancel(errStreamClosed)
+	}
+}
+
+// pump will write the queued chunks to the pipe, acking each chunk once it has been read
+func (s *incomingStream) pump(conn net.Conn, id uint32, w *io.PipeWriter) {
+	// The sequence number of the next chunk to be consumed
+	var seq uint32
+
+	for {
+		select {
+		case item := <-s.items:
+			if item.end {
+				w.CloseWithError(item.err)
+				return
+			}
+
+			// The write returns once the consumer has read the whole chunk
+			_, err := w.Write(item.data)
+			if err != nil {
+				// The consumer stopped reading so tell the sender not to wait for it
+				sendMessage(conn, streamReject, append(binary.BigEndian.AppendUint32(nil, id), "receiver stopped reading"...))
+				return
+			}
+
+			ack := binary.BigEndian.AppendUint32(nil, id)
+			ack = binary.BigEndian.AppendUint32(ack, seq)
+			sendMessage(conn, streamAck, ack)
+			seq++
+		case <-s.ctx.Done():
+			w.CloseWithError(errStreamClosed)
+			return
+		}
+	}
+}
+
+// receiveStream is the default StreamHandler, saving the stream to receiveDir if it is set
+func receiveStream(name string, r io.Reader) {
+	// Discard the content if there is nowhere to save it
+	w := io.Discard
+
+	if receiveDir != "" {
+		// Only use the base name so the sender can't write outside the directory
+		path := filepath.Join(receiveDir, filepath.Base(name))
+
+		f, err := os.Create(path)
+		if err != nil {
+			fmt.Println("Error creating file:", err)
+			return
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	n, err := io.Copy(w, r)
+	if err != nil {
+		fmt.Printf("Error receiving stream %s after %d bytes: %v\n", name, n, err)
+		return
+	}
+
+	fmt.Printf("Received stream %s: %d bytes\n", name, n)
+}
+
+// sendFile will send a file to the other end of the connection as a stream
+func sendFile(conn net.Conn, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return SendStream(conn, filepath.Base(path), f)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// streamPeers will connect two peers with a pipe and receive messages on both ends
+func streamPeers(t *testing.T) (sender, recv *peer) {
+	t.Helper()
+
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	sender, recv = newPeer(a), newPeer(b)
+
+	go receiver(sender)
+	go receiver(recv)
+
+	return sender, recv
+}
+
+// receivedStream is a stream passed to a test StreamHandler
+type receivedStream struct {
+	name string
+	data []byte
+	err  error
+}
+
+func TestSendStreamRoundTrip(t *testing.T) {
+	sender, recv := streamPeers(t)
+
+	received := make(chan receivedStream, 1)
+	err := HandleStreams(recv, func(name string, r io.Reader) {
+		data, err := io.ReadAll(r)
+		received <- receivedStream{name, data, err}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Send more than a window of chunks so the sender has to wait for acks
+	data := make([]byte, (streamWindow+3)*chunkSize+100)
+	rand.Read(data)
+
+	err = SendStream(sender, "report.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("SendStream error: %v", err)
+	}
+
+	got := <-received
+	if got.err != nil {
+		t.Fatalf("reading stream error: %v", got.err)
+	}
+	if got.name != "report.bin" {
+		t.Errorf("name = %q, want report.bin", got.name)
+	}
+	if !bytes.Equal(got.data, data) {
+		t.Errorf("received %d bytes, want the %d bytes sent", len(got.data), len(data))
+	}
+}
+
+func TestSendStreamEmpty(t *testing.T) {
+	sender, recv := streamPeers(t)
+
+	received := make(chan receivedStream, 1)
+	HandleStreams(recv, func(name string, r io.Reader) {
+		data, err := io.ReadAll(r)
+		received <- receivedStream{name, data, err}
+	})
+
+	err := SendStream(sender, "empty", strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("SendStream error: %v", err)
+	}
+
+	got := <-received
+	if got.err != nil || len(got.data) != 0 {
+		t.Errorf("received %d bytes, error %v, want an empty stream", len(got.data), got.err)
+	}
+}
+
+func TestIncomingStreamLimit(t *testing.T) {
+	sender, recv := streamPeers(t)
+
+	// Hold every stream open until released
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(maxIncomingStreams)
+	HandleStreams(recv, func(name string, r io.Reader) {
+		started.Done()
+		<-release
+		io.Copy(io.Discard, r)
+	})
+
+	// Fill every slot
+	errs := make(chan error, maxIncomingStreams)
+	for i := 0; i < maxIncomingStreams; i++ {
+		go func(i int) {
+			errs <- SendStream(sender, fmt.Sprint("stream ", i), strings.NewReader("content"))
+		}(i)
+	}
+	started.Wait()
+
+	// The next stream is rejected rather than buffered
+	err := SendStream(sender, "one too many", strings.NewReader("content"))
+	if err == nil || !strings.Contains(err.Error(), "stream rejected") {
+		t.Errorf("SendStream error = %v, want the stream rejected", err)
+	}
+
+	// The streams already in progress still complete
+	for i := 0; i < maxIncomingStreams; i++ {
+		release <- struct{}{}
+	}
+	for i := 0; i < maxIncomingStreams; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("SendStream error = %v, want nil", err)
+		}
+	}
+
+	// There is room for new streams once they have finished
+	waitFor(t, func() bool {
+		recv.streams.mutex.Lock()
+		defer recv.streams.mutex.Unlock()
+
+		return len(recv.streams.incoming) == 0
+	})
+
+	HandleStreams(recv, func(name string, r io.Reader) {
+		io.Copy(io.Discard, r)
+	})
+	err = SendStream(sender, "after", strings.NewReader("content"))
+	if err != nil {
+		t.Errorf("SendStream error = %v, want nil once streams have finished", err)
+	}
+}
+
+func TestStreamHandlerStopsReading(t *testing.T) {
+	sender, recv := streamPeers(t)
+
+	// Read a little and give up
+	HandleStreams(recv, func(name string, r io.Reader) {
+		r.Read(make([]byte, 10))
+	})
+
+	data := make([]byte, (streamWindow+3)*chunkSize)
+	err := SendStream(sender, "abandoned", bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "receiver stopped reading") {
+		t.Errorf("SendStream error = %v, want the receiver to have stopped reading", err)
+	}
+}
+
+func TestHandleStreamsNeedsPeer(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if err := HandleStreams(a, receiveStream); err == nil {
+		t.Error("HandleStreams on a plain connection succeeded, want an error")
+	}
+}
@@ -2,13 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"flag"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
 	"net"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 )
 
@@ -25,12 +27,45 @@ const (
 	dataMessage
 	// Command type for sending a pong
 	close
+	// Command type for joining a hub room
+	join
+	// Command type for offering optional message features
+	hello
+	// Command type for accepting optional message features
+	welcome
+	// Command type for starting a chunked stream
+	streamStart
+	// Command type for sending a chunk of a stream
+	streamChunk
+	// Command type for ending a chunked stream
+	streamEnd
+	// Command type for acknowledging that a chunk has been consumed
+	streamAck
+	// Command type for sending an authentication challenge
+	challenge
+	// Command type for presenting credentials
+	auth
+	// Command type for accepting credentials
+	authOK
+	// Command type for refusing an incoming chunked stream
+	streamReject
 )
 
+// maxDataLength is the most data a single message can carry
+const maxDataLength = math.MaxUint16
+
+// logMessages controls whether every message sent and received is printed to the console
+var logMessages = true
+
+// flagsPresent is set in the type byte when a flags byte follows it in the header
+const flagsPresent uint8 = 0x80
+
 // Message is a struct that represents a message that can be sent over the network
 type Message struct {
 	// Type is the type of message that is being sent
 	Type commandType
+	// Flags are the optional features used to encode the message, zero for the original header
+	Flags messageFlags
 	// The length of the data that is being sent
 	Length uint16
 	// Data is the data that is being sent
@@ -39,6 +74,12 @@ type Message struct {
 
 // String will return a string representation of the message
 func (m Message) String() string {
+	// Stream and authentication messages are binary or secret so just show their size
+	switch m.Type {
+	case streamStart, streamChunk, streamEnd, streamAck, streamReject, challenge, auth:
+		return fmt.Sprintf("Type: %d, Data: %d bytes", m.Type, len(m.Data))
+	}
+
 	return fmt.Sprintf("Type: %d, Data: %s", m.Type, string(m.Data))
 }
 
@@ -46,37 +87,106 @@ func (m Message) MarshallBinary() ([]byte, error) {
 	// Create a buffer to write the data to
 	var buf bytes.Buffer
 
-	// Write the type to the buffer
-	err := binary.Write(&buf, binary.BigEndian, m.Type)
-	if err != nil {
-		return nil, err
+	// Messages without flags use the original header so that older peers can read them
+	if m.Flags == 0 {
+		// Write the type to the buffer
+		err := binary.Write(&buf, binary.BigEndian, m.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		// Write the length of the data to the buffer
+		err = binary.Write(&buf, binary.BigEndian, m.Length)
+		if err != nil {
+			return nil, err
+		}
+
+		// Write the data to the buffer
+		_, err = buf.Write(m.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
 	}
 
-	// Write the length of the data to the buffer
-	err = binary.Write(&buf, binary.BigEndian, m.Length)
-	if err != nil {
-		return nil, err
+	// Check that the flags are ones we know how to encode
+	if m.Flags&^knownFlags != 0 {
+		return nil, fmt.Errorf("unknown message flags: %08b", m.Flags)
 	}
 
-	// Write the data to the buffer
-	_, err = buf.Write(m.Data)
+	// Compress the data if asked to, only keeping the flag if it made the data smaller
+	flags := m.Flags
+	payload := m.Data
+	if flags&flagCompressed != 0 {
+		compressed, err := compress(m.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(compressed) < len(m.Data) {
+			payload = compressed
+		} else {
+			flags &^= flagCompressed
+		}
+	}
+
+	// Check the payload will fit in the length field
+	if len(payload) > maxDataLength {
+		return nil, fmt.Errorf("message data too long: %d bytes", len(payload))
+	}
+
+	// Write the type with the flags present bit set, followed by the flags
+	buf.WriteByte(uint8(m.Type) | flagsPresent)
+	buf.WriteByte(uint8(flags))
+
+	// Write the length of the payload to the buffer
+	err := binary.Write(&buf, binary.BigEndian, uint16(len(payload)))
 	if err != nil {
 		return nil, err
 	}
 
+	// Write the payload to the buffer
+	buf.Write(payload)
+
+	// Write a checksum of everything before it to the buffer
+	if flags&flagChecksum != 0 {
+		err = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return buf.Bytes(), nil
 }
 
 func (m *Message) UnmarshallBinary(data []byte) error {
-	// Create a buffer to read the data from
-	buf := bytes.NewBuffer(data)
+	// Create a reader to read the data from
+	buf := bytes.NewReader(data)
 
 	// Read the type from the buffer
-	err := binary.Read(buf, binary.BigEndian, &m.Type)
+	var typeByte uint8
+	err := binary.Read(buf, binary.BigEndian, &typeByte)
 	if err != nil {
 		return err
 	}
 
+	// Split the flags present bit from the type
+	m.Type = commandType(typeByte &^ flagsPresent)
+	m.Flags = 0
+
+	// Read the flags from the buffer if they are present
+	if typeByte&flagsPresent != 0 {
+		err = binary.Read(buf, binary.BigEndian, &m.Flags)
+		if err != nil {
+			return err
+		}
+
+		if m.Flags&^knownFlags != 0 {
+			return fmt.Errorf("unknown message flags: %08b", m.Flags)
+		}
+	}
+
 	// Read the length of the data from the buffer
 	err = binary.Read(buf, binary.BigEndian, &m.Length)
 	if err != nil {
@@ -84,12 +194,37 @@ func (m *Message) UnmarshallBinary(data []byte) error {
 	}
 
 	// Read the data from the buffer
-	m.Data = make([]byte, m.Length)
-	_, err = buf.Read(m.Data)
+	payload := make([]byte, m.Length)
+	_, err = io.ReadFull(buf, payload)
 	if err != nil {
 		return err
 	}
 
+	// Check the checksum against everything before it
+	if m.Flags&flagChecksum != 0 {
+		checked := data[:len(data)-buf.Len()]
+
+		var checksum uint32
+		err = binary.Read(buf, binary.BigEndian, &checksum)
+		if err != nil {
+			return err
+		}
+
+		if crc32.ChecksumIEEE(checked) != checksum {
+			return errChecksum
+		}
+	}
+
+	// Decompress the data if it was compressed
+	if m.Flags&flagCompressed != 0 {
+		payload, err = decompress(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.Data = payload
+
 	return nil
 }
 
@@ -98,56 +233,231 @@ func main() {
 	// Parse the command line to work out if this is a client or server
 	isServer := flag.Bool("s", false, "Run as server")
 
+	// Parse the command line to work out if this is running in hub mode
+	isHub := flag.Bool("hub", false, "Run in hub mode, relaying data messages between clients")
+
+	// The name and room used by a hub client
+	name := flag.String("name", "", "Name to use when joining a hub (defaults to the local address)")
+	room := flag.String("room", defaultRoom, "Room to join when connecting to a hub")
+
+	// The address to listen on or connect to
+	addr := flag.String("addr", "localhost:8080", "Address to listen on or connect to")
+
+	// The server limits
+	maxConnections := flag.Int("max", 0, "Maximum number of connections the server will handle at once (0 for no limit)")
+	grace := flag.Duration("grace", 5*time.Second, "Time allowed for connections to drain when the server shuts down")
+
+	// The optional features offered to the other end
+	useCompression := flag.Bool("compress", true, "Offer compression of large messages")
+	useChecksum := flag.Bool("crc", true, "Offer CRC32 checksums on messages")
+
+	// The file to send and the directory to save received files to
+	sendPath := flag.String("send", "", "File to send to the server as a chunked stream")
+	flag.StringVar(&receiveDir, "recvdir", "", "Directory to save received streams to (discarded if empty)")
+
+	// The latency test options
+	useUDP := flag.Bool("udp", false, "Use UDP datagrams instead of a TCP connection for the latency test")
+	latencyCount := flag.Int("latency", 0, "Run a latency test with this many pings")
+	latencyInterval := flag.Duration("interval", 100*time.Millisecond, "Interval between pings in the latency test")
+	latencySize := flag.Int("size", latencyHeaderLength, "Size of the messages in the latency and load tests in bytes")
+
+	// The load test options
+	bench := flag.Bool("bench", false, "Run a load test against the server")
+	benchConnections := flag.Int("conns", 10, "Number of concurrent connections in the load test")
+	benchRate := flag.Float64("rate", 10, "Messages per second sent on each connection in the load test")
+	benchDuration := flag.Duration("duration", 10*time.Second, "Duration of the load test")
+	benchType := flag.String("type", "ping", "Type of message sent in the load test, ping or data (data needs a hub server)")
+
+	// Turn off the per-message output, useful under load
+	quiet := flag.Bool("quiet", false, "Don't print every message sent and received")
+
+	// The authentication options, the secret can also be set in the environment to keep it off the command line
+	var authentication authConfig
+	flag.StringVar(&authentication.Mode, "auth", authNone, "Authentication mode, token or hmac (none if empty)")
+	flag.StringVar(&authentication.Secret, "secret", os.Getenv("TCP_TEST_SECRET"), "Pre-shared secret for authentication (defaults to $TCP_TEST_SECRET)")
+
 	flag.Parse()
 
-	if *isServer {
-		fmt.Println("Running as server")
+	logMessages = !*quiet
+
+	// Clients authenticate as their name, or the host name if they don't have one
+	authentication.Identity = *name
+	if authentication.Identity == "" {
+		authentication.Identity, _ = os.Hostname()
+	}
+
+	if err := authentication.validate(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	// Only offer the features that haven't been turned off
+	if !*useCompression {
+		offeredFeatures &^= flagCompressed
+	}
+	if !*useChecksum {
+		offeredFeatures &^= flagChecksum
+	}
+
+	// Create a context that is cancelled on SIGINT or SIGTERM
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if *isServer && *useUDP {
+		fmt.Println("Running as UDP server")
 
-		l, err := net.Listen("tcp", "localhost:8080")
+		// Answer pings until a signal is received
+		err := serveUDP(ctx, *addr)
 		if err != nil {
-			fmt.Println("Error listening:", err.Error())
+			fmt.Println("Error serving:", err.Error())
 			os.Exit(1)
 		}
 
-		defer l.Close()
+		fmt.Println("Server stopped")
+	} else if *isServer {
+		server := &Server{
+			Addr:           *addr,
+			MaxConnections: *maxConnections,
+			Auth:           authentication,
+		}
+
+		if *isHub {
+			fmt.Println("Running as hub server")
+
+			// Create the hub that all connections will join
+			hub := NewHub()
+
+			server.Handler = func(ctx context.Context, conn net.Conn) {
+				hub.handleConnection(conn)
+			}
+		} else {
+			fmt.Println("Running as server")
 
-		for {
-			conn, err := l.Accept()
+			server.Handler = func(ctx context.Context, conn net.Conn) {
+				handleConnection(ctx, conn, isServer)
+			}
+		}
+
+		// Start the server in a goroutine so that we can wait for a signal
+		errs := make(chan error, 1)
+		go func() {
+			errs <- server.Serve(context.Background())
+		}()
+
+		// Wait for a signal or for the server to fail
+		select {
+		case <-ctx.Done():
+		case err := <-errs:
+			fmt.Println("Error serving:", err.Error())
+			os.Exit(1)
+		}
+
+		// Give the connections time to drain before forcing them closed
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), *grace)
+		defer shutdownCancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			fmt.Println("Error shutting down server:", err)
+			return
+		}
+
+		fmt.Println("Server stopped gracefully")
+	} else if *bench {
+		config := benchConfig{
+			Addr:        *addr,
+			Connections: *benchConnections,
+			Rate:        *benchRate,
+			Size:        *latencySize,
+			Duration:    *benchDuration,
+			Auth:        authentication,
+		}
+
+		// Work out which type of message to send
+		switch *benchType {
+		case "ping":
+			config.Type = ping
+		case "data":
+			config.Type = dataMessage
+		default:
+			fmt.Println("Unknown message type:", *benchType)
+			os.Exit(1)
+		}
+
+		if err := config.validate(); err != nil {
+			fmt.Println("Error:", err)
+			flag.Usage()
+			os.Exit(2)
+		}
+
+		fmt.Printf("Running load test: %d connections sending %s messages at %v/s for %v\n",
+			config.Connections, *benchType, config.Rate, config.Duration)
+
+		// Run the test and print the result
+		result := runBench(ctx, config)
+		fmt.Println(result)
+	} else if *latencyCount > 0 {
+		if err := validateLatencyTest(*latencyInterval, *latencySize, *useUDP); err != nil {
+			fmt.Println("Error:", err)
+			flag.Usage()
+			os.Exit(2)
+		}
+
+		fmt.Println("Running latency test")
+
+		// Create the transport for the test
+		var t transport
+		if *useUDP {
+			conn, err := net.Dial("udp", *addr)
 			if err != nil {
-				fmt.Println("Error accepting: ", err.Error())
+				fmt.Println("Error dialing:", err.Error())
 				os.Exit(1)
 			}
-
-			go handleConnection(conn, isServer)
+			t = datagramTransport{conn: conn}
+		} else {
+			conn, err := dial(*addr, authentication)
+			if err != nil {
+				fmt.Println("Error dialing:", err.Error())
+				os.Exit(1)
+			}
+			t = streamTransport{conn: conn}
 		}
+		defer t.Close()
+
+		// Run the test and print the report
+		report := runLatencyTest(ctx, t, *latencyCount, *latencyInterval, *latencySize)
+		fmt.Println(report)
 	} else {
 		fmt.Println("Running as client")
 
-		// Connect to the server
-		conn, err := net.Dial("tcp", "localhost:8080")
+		// Connect to the server and authenticate
+		conn, err := dial(*addr, authentication)
 		if err != nil {
 			fmt.Println("Error dialing:", err.Error())
 			os.Exit(1)
 		}
 
-		handleConnection(conn, isServer)
+		if *isHub {
+			handleHubClient(ctx, conn, *name, *room)
+		} else if *sendPath != "" {
+			handleSendFile(ctx, conn, *sendPath)
+		} else {
+			handleConnection(ctx, conn, isServer)
+		}
 	}
 }
 
 // handleConnection will read the data from the connection and print it to the console
 // It will also write data from the console to the connection
-func handleConnection(conn net.Conn, isServer *bool) {
-	// Create a channel to handle SIGINT or SIGTERM
-	signals := make(chan os.Signal, 1)
-
-	// Register the channel to receive the signals
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
-
+func handleConnection(ctx context.Context, conn net.Conn, isServer *bool) {
 	// Close the connection when the function returns
 	defer conn.Close()
 
+	// Cancel the context when the function returns so that the sender stops
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// Create a channel to wait for the connection to close
-	done := make(chan struct{})
+	done := make(chan struct{}, 2)
 
 	// Start a goroutine to read from the connection
 	go func() {
@@ -155,36 +465,84 @@ func handleConnection(conn net.Conn, isServer *bool) {
 		done <- struct{}{}
 	}()
 
+	// Clients offer their features, servers wait to be offered them
+	if !*isServer {
+		sendHello(conn)
+	}
+
 	if *isServer {
 		// Start a goroutine to write to the connection
 		go func() {
-			sender(conn)
+			sender(ctx, conn)
 			done <- struct{}{}
 		}()
 	}
 
-	// Wait for the connection to close or a signal to be received
+	// Wait for the connection to close or the context to be cancelled
 	select {
 	case <-done:
 		fmt.Println("Connection closed")
-	case sig := <- signals:
-		fmt.Println("Received signal:", sig)
+	case <-ctx.Done():
+		fmt.Println("Connection cancelled")
+	}
+}
+
+// handleSendFile will send a file to the server as a chunked stream and then close the connection
+func handleSendFile(ctx context.Context, conn net.Conn, path string) {
+	// Close the connection when the function returns
+	defer conn.Close()
+
+	// Offer our features to the server
+	sendHello(conn)
+
+	// Start a goroutine to read from the connection so that the acks are received
+	go receiver(conn)
+
+	// Send the file in a goroutine so that we can stop on a signal
+	result := make(chan error, 1)
+	go func() {
+		result <- sendFile(conn, path)
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			fmt.Println("Error sending file:", err)
+			return
+		}
+
+		fmt.Println("Sent file:", path)
+		sendMessage(conn, close, nil)
+	case <-ctx.Done():
+		fmt.Println("Send cancelled")
 	}
 }
 
-// sender will read from the console and write to the connection in a loop
-func sender(conn net.Conn) {
-	// Send a ping message once a second
+// sender will write a ping to the connection once a second until the context is cancelled
+func sender(ctx context.Context, conn net.Conn) {
+	// Create a ticker to send a ping message once a second
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
 	for {
 		sendMessage(conn, ping, []byte("Hello, World!"))
 
-		// Sleep for a second
-		time.Sleep(time.Second)
+		// Wait for the next tick or for the context to be cancelled
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
 // receiver will read from the connection and write to the console in a loop
 func receiver(conn net.Conn) {
+	// Fail any streams still in progress when the connection closes
+	if streams := streamsFor(conn); streams != nil {
+		defer streams.abort()
+	}
+
 	for {
 		// Read a message from the connection
 		message, err := readMessage(conn)
@@ -193,8 +551,14 @@ func receiver(conn net.Conn) {
 			return
 		}
 
-		// Print the message to the console
-		fmt.Println("Received message:", message)
+		// Print the message to the console, along with who sent it if they authenticated
+		if logMessages {
+			if identity := peerIdentity(conn); identity != "" {
+				fmt.Printf("Received message from %s: %v\n", identity, message)
+			} else {
+				fmt.Println("Received message:", message)
+			}
+		}
 
 		// Switch on the type of message
 		switch message.Type {
@@ -210,51 +574,100 @@ func receiver(conn net.Conn) {
 		case close:
 			// Close the connection
 			return
+		case hello:
+			// Agree the features that both ends support
+			acceptHello(conn, message.Data)
+		case welcome:
+			// Start using the agreed features
+			acceptWelcome(conn, message.Data)
+		case streamStart, streamChunk, streamEnd, streamAck, streamReject:
+			// Pass the message on to the stream it belongs to
+			handleStreamMessage(conn, message)
 		}
 	}
 }
 
-// sendMessage will send a message over the connection
-func sendMessage(conn net.Conn, messageType commandType, data []byte) {
-	// Create a message to send
+// sendMessage will send a message over the connection, printing it to the console
+func sendMessage(conn net.Conn, messageType commandType, data []byte) error {
+	// The length has to fit in the header
+	if len(data) > maxDataLength {
+		err := fmt.Errorf("message data too long: %d bytes", len(data))
+		fmt.Println("Error writing message:", err)
+		return err
+	}
+
+	// Create a message to send using the features agreed with the peer
 	message := Message{
 		Type:   messageType,
+		Flags:  messageFlagsFor(conn, data),
 		Length: uint16(len(data)),
 		Data:   data,
 	}
 
 	// Print the message to the console
-	fmt.Println("Sending message:", message)
+	if logMessages {
+		fmt.Println("Sending message:", message)
+	}
+
+	// Write the message to the connection
+	err := writeMessage(conn, message)
+	if err != nil {
+		fmt.Println("Error writing message:", err)
+		return err
+	}
+
+	return nil
+}
 
+// writeMessage will marshall a message and write it to the connection in a single write
+func writeMessage(conn net.Conn, message Message) error {
 	// Marshall the message into a byte array
 	messageData, err := message.MarshallBinary()
 	if err != nil {
-		fmt.Println("Error marshalling message:", err)
-		return
+		return err
 	}
 
 	// Write the message to the connection
 	_, err = conn.Write(messageData)
-	if err != nil {
-		fmt.Println("Error writing message:", err)
-		return
-	}
+	return err
 }
 
 // readMessage will read a message from the connection
 func readMessage(conn net.Conn) (Message, error) {
-	// Create a buffer to read the data into
-	buf := make([]byte, 1024)
+	// Read the type so we know if there is a flags byte
+	buf := make([]byte, 1, 8)
+	_, err := io.ReadFull(conn, buf)
+	if err != nil {
+		return Message{}, err
+	}
+
+	// Read the rest of the header, including the flags if they are present
+	headerLength := 3
+	if buf[0]&flagsPresent != 0 {
+		headerLength = 4
+	}
+	buf = buf[:headerLength]
+	_, err = io.ReadFull(conn, buf[1:])
+	if err != nil {
+		return Message{}, err
+	}
+
+	// Work out how much follows the header, including the checksum if there is one
+	length := int(binary.BigEndian.Uint16(buf[headerLength-2:]))
+	if headerLength == 4 && messageFlags(buf[1])&flagChecksum != 0 {
+		length += crc32.Size
+	}
 
-	// Read the data from the connection
-	n, err := conn.Read(buf)
+	// Read exactly the rest of this message, leaving any following messages on the connection
+	buf = append(buf, make([]byte, length)...)
+	_, err = io.ReadFull(conn, buf[headerLength:])
 	if err != nil {
 		return Message{}, err
 	}
 
 	// Unmarshall the data into a message
 	message := Message{}
-	err = message.UnmarshallBinary(buf[:n])
+	err = message.UnmarshallBinary(buf)
 	if err != nil {
 		return Message{}, err
 	}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// marshal will encode the message, failing the test if it can't be
+func marshal(t testing.TB, m Message) []byte {
+	t.Helper()
+
+	data, err := m.MarshallBinary()
+	if err != nil {
+		t.Fatalf("MarshallBinary(%v) error: %v", m, err)
+	}
+
+	return data
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	compressible := []byte(strings.Repeat("compress me ", 100))
+
+	tests := []struct {
+		name string
+		in   Message
+		// wantFlags are the flags expected on the wire, compression is dropped if it doesn't help
+		wantFlags messageFlags
+	}{
+		{"no flags", Message{Type: dataMessage, Length: 5, Data: []byte("hello")}, 0},
+		{"no flags empty", Message{Type: ping}, 0},
+		{"checksum", Message{Type: dataMessage, Flags: flagChecksum, Data: []byte("hello")}, flagChecksum},
+		{"compressed", Message{Type: dataMessage, Flags: flagCompressed, Data: compressible}, flagCompressed},
+		{"compressed and checksum", Message{Type: streamChunk, Flags: flagCompressed | flagChecksum, Data: compressible}, flagCompressed | flagChecksum},
+		{"compression dropped when larger", Message{Type: dataMessage, Flags: flagCompressed, Data: []byte("hi")}, 0},
+		{"compression dropped keeps checksum", Message{Type: dataMessage, Flags: flagCompressed | flagChecksum, Data: []byte("hi")}, flagChecksum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := marshal(t, tt.in)
+
+			// The flags present bit is only set when there are flags
+			if got := data[0]&flagsPresent != 0; got != (tt.in.Flags != 0) {
+				t.Errorf("flags present bit = %v, want %v", got, tt.in.Flags != 0)
+			}
+
+			var out Message
+			if err := out.UnmarshallBinary(data); err != nil {
+				t.Fatalf("UnmarshallBinary error: %v", err)
+			}
+
+			if out.Type != tt.in.Type {
+				t.Errorf("Type = %d, want %d", out.Type, tt.in.Type)
+			}
+			if out.Flags != tt.wantFlags {
+				t.Errorf("Flags = %v, want %v", out.Flags, tt.wantFlags)
+			}
+			if !bytes.Equal(out.Data, tt.in.Data) {
+				t.Errorf("Data = %q, want %q", out.Data, tt.in.Data)
+			}
+		})
+	}
+}
+
+func TestMessageCompressionShrinksWire(t *testing.T) {
+	data := []byte(strings.Repeat("a", 1000))
+
+	plain := marshal(t, Message{Type: dataMessage, Flags: flagChecksum, Data: data})
+	compressed := marshal(t, Message{Type: dataMessage, Flags: flagCompressed | flagChecksum, Data: data})
+
+	if len(compressed) >= len(plain) {
+		t.Errorf("compressed message is %d bytes, uncompressed is %d", len(compressed), len(plain))
+	}
+}
+
+func TestMessageChecksumRejectsCorruption(t *testing.T) {
+	in := Message{Type: dataMessage, Flags: flagChecksum, Data: []byte("hello, world")}
+
+	tests := []struct {
+		name string
+		// index is the byte to corrupt, counting back from the end if negative
+		index int
+	}{
+		{"trailer", -1},
+		{"trailer first byte", -4},
+		{"payload", 5},
+		{"length", 3},
+		{"type", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := marshal(t, in)
+
+			i := tt.index
+			if i < 0 {
+				i += len(data)
+			}
+			data[i] ^= 0x01
+
+			var out Message
+			err := out.UnmarshallBinary(data)
+			if err == nil {
+				t.Fatalf("UnmarshallBinary accepted a corrupted message: %v", out)
+			}
+
+			// Corrupting the length can leave too few bytes to read, which is also an error
+			if tt.name != "length" && !errors.Is(err, errChecksum) {
+				t.Errorf("UnmarshallBinary error = %v, want %v", err, errChecksum)
+			}
+		})
+	}
+}
+
+func TestMessageMissingTrailer(t *testing.T) {
+	data := marshal(t, Message{Type: dataMessage, Flags: flagChecksum, Data: []byte("hello")})
+
+	var out Message
+	if err := out.UnmarshallBinary(data[:len(data)-2]); err == nil {
+		t.Error("UnmarshallBinary accepted a message with a truncated checksum")
+	}
+}
+
+func TestMessageUnknownFlags(t *testing.T) {
+	if _, err := (Message{Type: dataMessage, Flags: 0x40, Data: []byte("x")}).MarshallBinary(); err == nil {
+		t.Error("MarshallBinary accepted unknown flags")
+	}
+
+	var out Message
+	if err := out.UnmarshallBinary([]byte{uint8(dataMessage) | flagsPresent, 0x40, 0, 0}); err == nil {
+		t.Error("UnmarshallBinary accepted unknown flags")
+	}
+}
+
+func FuzzUnmarshallBinary(f *testing.F) {
+	// Seed with valid frames of every encoding
+	compressible := []byte(strings.Repeat("fuzz ", 100))
+	seeds := []Message{
+		{Type: ping},
+		{Type: dataMessage, Length: 5, Data: []byte("hello")},
+		{Type: dataMessage, Flags: flagChecksum, Data: []byte("hello")},
+		{Type: dataMessage, Flags: flagCompressed, Data: compressible},
+		{Type: streamChunk, Flags: flagCompressed | flagChecksum, Data: compressible},
+		{Type: hello, Length: 1, Data: []byte{uint8(knownFlags)}},
+	}
+	for _, m := range seeds {
+		f.Add(marshal(f, m))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var m Message
+		if err := m.UnmarshallBinary(data); err != nil {
+			return
+		}
+
+		// Anything that decodes must encode and decode back to the same message
+		if m.Flags == 0 {
+			m.Length = uint16(len(m.Data))
+		}
+
+		var out Message
+		if err := out.UnmarshallBinary(marshal(t, m)); err != nil {
+			t.Fatalf("UnmarshallBinary of re-encoded %v error: %v", m, err)
+		}
+
+		if out.Type != m.Type || !bytes.Equal(out.Data, m.Data) {
+			t.Fatalf("round trip changed %v to %v", m, out)
+		}
+	})
+}
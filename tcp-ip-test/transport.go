@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// latencyHeaderLength is the length of the sequence number and send time at the start of a latency ping
+const latencyHeaderLength = 12
+
+// latencyDrain is how long to wait for outstanding pongs once every ping has been sent
+const latencyDrain = time.Second
+
+// maxDatagramData is the most data a message sent as a single UDP datagram can carry
+// 65507 is the largest UDP payload over IPv4, less the header of a message without flags
+const maxDatagramData = 65507 - 3
+
+// transport sends and receives messages over either a stream or a datagram connection
+type transport interface {
+	// Send will send a message to the other end
+	Send(messageType commandType, data []byte) error
+	// Receive will wait for the next message from the other end
+	Receive() (Message, error)
+	// Close will close the underlying connection
+	Close() error
+}
+
+// streamTransport sends messages over a stream connection such as TCP
+type streamTransport struct {
+	conn net.Conn
+}
+
+// Send will write the message to the stream
+func (t streamTransport) Send(messageType commandType, data []byte) error {
+	return writeMessage(t.conn, Message{
+		Type:   messageType,
+		Flags:  messageFlagsFor(t.conn, data),
+		Length: uint16(len(data)),
+		Data:   data,
+	})
+}
+
+// Receive will read the next message from the stream
+func (t streamTransport) Receive() (Message, error) {
+	return readMessage(t.conn)
+}
+
+// Close will close the stream
+func (t streamTransport) Close() error {
+	return t.conn.Close()
+}
+
+// datagramTransport sends each message in its own datagram over a connected UDP socket
+// Datagrams can be lost, duplicated or reordered so callers must sequence their own messages
+type datagramTransport struct {
+	conn net.Conn
+}
+
+// Send will write the message as a single datagram
+func (t datagramTransport) Send(messageType commandType, data []byte) error {
+	return writeMessage(t.conn, Message{
+		Type:   messageType,
+		Length: uint16(len(data)),
+		Data:   data,
+	})
+}
+
+// Receive will read the next datagram and unmarshall it into a message
+func (t datagramTransport) Receive() (Message, error) {
+	// Make the buffer big enough for the largest message
+	buf := make([]byte, math.MaxUint16+8)
+
+	n, err := t.conn.Read(buf)
+	if err != nil {
+		return Message{}, err
+	}
+
+	message := Message{}
+	err = message.UnmarshallBinary(buf[:n])
+	if err != nil {
+		return Message{}, err
+	}
+
+	return message, nil
+}
+
+// Close will close the socket
+func (t datagramTransport) Close() error {
+	return t.conn.Close()
+}
+
+// serveUDP will answer pings sent as datagrams with pongs until the context is cancelled
+func serveUDP(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	// Close the socket when the context is cancelled to unblock the read
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, math.MaxUint16+8)
+
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// Unmarshall the datagram into a message
+		message := Message{}
+		err = message.UnmarshallBinary(buf[:n])
+		if err != nil {
+			fmt.Println("Error unmarshalling datagram from", from, err)
+			continue
+		}
+
+		// Only pings are answered
+		if message.Type != ping {
+			continue
+		}
+
+		reply, err := Message{Type: pong, Length: message.Length, Data: message.Data}.MarshallBinary()
+		if err != nil {
+			fmt.Println("Error marshalling message:", err)
+			continue
+		}
+
+		_, err = conn.WriteTo(reply, from)
+		if err != nil {
+			fmt.Println("Error writing datagram to", from, err)
+		}
+	}
+}
+
+// latencyReport is the result of a latency test
+type latencyReport struct {
+	// Sent is the number of pings sent
+	Sent int
+	// Received is the number of distinct pongs received
+	Received int
+	// Duplicates is the number of pongs received more than once
+	Duplicates int
+	// Reordered is the number of pongs received after a later one
+	Reordered int
+	// RTT is the distribution of round trip times
+	RTT latencySummary
+}
+
+// Loss will return the percentage of pings that were not answered
+func (r latencyReport) Loss() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+
+	return 100 * float64(r.Sent-r.Received) / float64(r.Sent)
+}
+
+// String will return a string representation of the report
+func (r latencyReport) String() string {
+	return fmt.Sprintf(
+		"sent %d, received %d, lost %.2f%%, duplicates %d, reordered %d\nrtt %v",
+		r.Sent, r.Received, r.Loss(), r.Duplicates, r.Reordered, r.RTT,
+	)
+}
+
+// validateLatencyTest will check the options for a latency test before any pings are sent
+func validateLatencyTest(interval time.Duration, size int, udp bool) error {
+	if interval <= 0 {
+		return fmt.Errorf("the interval must be positive, got %v", interval)
+	}
+
+	// The pings have to fit in a single message, and in a single datagram over UDP
+	limit := maxDataLength
+	if udp {
+		limit = maxDatagramData
+	}
+	if size > limit {
+		return fmt.Errorf("the size must be at most %d bytes, got %d", limit, size)
+	}
+
+	return nil
+}
+
+// runLatencyTest will send count sequenced pings, one every interval, and measure the pongs
+// The pings are padded to size bytes so that different payloads can be compared
+func runLatencyTest(ctx context.Context, t transport, count int, interval time.Duration, size int) latencyReport {
+	var (
+		stats  latencyStats
+		mutex  sync.Mutex
+		report latencyReport
+		seen   = make(map[uint32]bool)
+		latest = -1
+	)
+
+	// Start a goroutine to receive the pongs
+	done := make(chan struct{}, 1)
+	go func() {
+		defer func() { done <- struct{}{} }()
+
+		for {
+			message, err := t.Receive()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					fmt.Println("Error receiving message:", err)
+				}
+				return
+			}
+
+			switch message.Type {
+			case ping:
+				// Answer pings from the server
+				t.Send(pong, message.Data)
+				continue
+			case pong:
+			default:
+				continue
+			}
+
+			// Ignore pongs that aren't for our pings
+			if len(message.Data) < latencyHeaderLength {
+				continue
+			}
+
+			seq := binary.BigEndian.Uint32(message.Data)
+			sent := time.Unix(0, int64(binary.BigEndian.Uint64(message.Data[4:])))
+			rtt := time.Since(sent)
+
+			mutex.Lock()
+			switch {
+			case seen[seq]:
+				report.Duplicates++
+			case int(seq) < latest:
+				report.Reordered++
+				fallthrough
+			default:
+				seen[seq] = true
+				report.Received++
+				stats.add(rtt)
+				latest = max(latest, int(seq))
+			}
+			complete := report.Received == count
+			mutex.Unlock()
+
+			// Stop once every ping has been answered
+			if complete {
+				return
+			}
+		}
+	}()
+
+	// Send the pings
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	data := make([]byte, max(size, latencyHeaderLength))
+
+sending:
+	for seq := 0; seq < count; seq++ {
+		binary.BigEndian.PutUint32(data, uint32(seq))
+		binary.BigEndian.PutUint64(data[4:], uint64(time.Now().UnixNano()))
+
+		err := t.Send(ping, data)
+		if err != nil {
+			fmt.Println("Error sending ping:", err)
+			break
+		}
+
+		mutex.Lock()
+		report.Sent++
+		mutex.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break sending
+		}
+	}
+
+	// Give the last pongs a chance to arrive before closing the connection
+	select {
+	case <-done:
+	case <-time.After(latencyDrain):
+		t.Close()
+		<-done
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	report.RTT = stats.summary()
+
+	return report
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestValidateLatencyTest(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		size     int
+		udp      bool
+		wantErr  bool
+	}{
+		{"defaults", 100 * time.Millisecond, latencyHeaderLength, false, false},
+		{"small sizes are padded", time.Millisecond, 0, false, false},
+		{"zero interval", 0, latencyHeaderLength, false, true},
+		{"negative interval", -time.Second, latencyHeaderLength, false, true},
+		{"largest tcp message", time.Millisecond, maxDataLength, false, false},
+		{"tcp message too long", time.Millisecond, maxDataLength + 1, false, true},
+		{"largest datagram", time.Millisecond, maxDatagramData, true, false},
+		{"datagram too long", time.Millisecond, maxDatagramData + 1, true, true},
+		{"tcp size over udp", time.Millisecond, maxDataLength, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLatencyTest(tt.interval, tt.size, tt.udp)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateLatencyTest(%v, %d, %v) error = %v, want error %v", tt.interval, tt.size, tt.udp, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLatencyTestLargestDatagram(t *testing.T) {
+	// Find a free port for the UDP server
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.LocalAddr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serveUDP(ctx, addr)
+	}()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := datagramTransport{conn: conn}
+	defer tr.Close()
+
+	// Datagrams sent before the server is listening are lost, so retry until one is answered
+	var report latencyReport
+	for i := 0; i < 20 && report.Received == 0; i++ {
+		report = runLatencyTest(ctx, tr, 1, time.Millisecond, maxDatagramData)
+	}
+
+	if report.Sent != 1 || report.Received != 1 {
+		t.Errorf("report = %v, want the largest datagram answered", report)
+	}
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("serveUDP error = %v", err)
+	}
+}